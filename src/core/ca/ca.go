@@ -0,0 +1,243 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rerorero/meshem/src/model"
+	"github.com/sirupsen/logrus"
+)
+
+// CertificateAuthority issues the workload certificates of the hosts.
+type CertificateAuthority interface {
+	// Issue returns the certificate of the host. A new certificate is issued if the host doesn't have it yet,
+	// its identity is changed or it's going to expire.
+	Issue(hostName string, identity string) (*KeyPair, error)
+	// TrustBundle returns the PEM encoded certificate of the CA.
+	TrustBundle() []byte
+}
+
+// KeyPair is a PEM encoded certificate and its private key.
+type KeyPair struct {
+	CertPEM  []byte
+	KeyPEM   []byte
+	Identity string
+	Serial   string
+	NotAfter time.Time
+}
+
+type builtinCA struct {
+	cert        *x509.Certificate
+	key         crypto.Signer
+	certPEM     []byte
+	ttl         time.Duration
+	renewBefore time.Duration
+	issued      map[string]*KeyPair
+	mutex       sync.Mutex
+	logger      *logrus.Logger
+	now         func() time.Time
+}
+
+const (
+	caValidity = 10 * 365 * 24 * time.Hour
+	// allows the clock skew between the hosts
+	backdate = time.Minute
+)
+
+// NewCertificateAuthority creates a CA which signs with the key in the files of the configuration.
+// A self-signed CA is generated if the files are not specified.
+func NewCertificateAuthority(conf *model.MTLSConf, logger *logrus.Logger) (CertificateAuthority, error) {
+	var cert *x509.Certificate
+	var key crypto.Signer
+	var err error
+	if len(conf.CACertFile) > 0 {
+		cert, key, err = loadCA(conf.CACertFile, conf.CAKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		logger.Infof("CA loaded from %s", conf.CACertFile)
+	} else {
+		cert, key, err = generateCA(conf.TrustDomain, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		logger.Warnf("self-signed CA is generated, the certificates are invalidated on restart.")
+	}
+
+	return &builtinCA{
+		cert:        cert,
+		key:         key,
+		certPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		ttl:         time.Duration(conf.CertTTLMinutes) * time.Minute,
+		renewBefore: time.Duration(conf.RenewBeforeMinutes) * time.Minute,
+		issued:      map[string]*KeyPair{},
+		logger:      logger,
+		now:         time.Now,
+	}, nil
+}
+
+func (ca *builtinCA) TrustBundle() []byte {
+	return ca.certPEM
+}
+
+func (ca *builtinCA) Issue(hostName string, identity string) (*KeyPair, error) {
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	now := ca.now()
+	if kp, ok := ca.issued[hostName]; ok {
+		if kp.Identity == identity && now.Before(kp.NotAfter.Add(-ca.renewBefore)) {
+			return kp, nil
+		}
+	}
+
+	kp, err := ca.sign(hostName, identity, now)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to issue a certificate: host=%s, identity=%s", hostName, identity)
+	}
+	ca.issued[hostName] = kp
+	ca.logger.Infof("Issued a certificate! host=%s, identity=%s, serial=%s, notAfter=%s", hostName, identity, kp.Serial, kp.NotAfter)
+	return kp, nil
+}
+
+func (ca *builtinCA) sign(hostName string, identity string, now time.Time) (*KeyPair, error) {
+	uri, err := url.Parse(identity)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	notAfter := now.Add(ca.ttl)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hostName},
+		URIs:         []*url.URL{uri},
+		NotBefore:    now.Add(-backdate),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyPair{
+		CertPEM:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		KeyPEM:   pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+		Identity: identity,
+		Serial:   serial.Text(16),
+		NotAfter: notAfter,
+	}, nil
+}
+
+func generateCA(trustDomain string, now time.Time) (*x509.Certificate, crypto.Signer, error) {
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate CA key")
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "meshem CA", Organization: []string{trustDomain}},
+		NotBefore:             now.Add(-backdate),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate CA certificate")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func loadCA(certFile string, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to read CA certificate: %s", certFile)
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to read CA key: %s", keyFile)
+	}
+	return parseCA(certPEM, keyPEM)
+}
+
+func parseCA(certPEM []byte, keyPEM []byte) (*x509.Certificate, crypto.Signer, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no PEM data is found in CA certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid CA certificate")
+	}
+	if !cert.IsCA {
+		return nil, nil, fmt.Errorf("the certificate is not for CA: %s", cert.Subject)
+	}
+
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no PEM data is found in CA key")
+	}
+	var key interface{}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid CA key")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported CA key type: %T", key)
+	}
+	return cert, signer, nil
+}
+
+func newSerialNumber() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate serial number")
+	}
+	return serial, nil
+}
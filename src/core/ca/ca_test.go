@@ -0,0 +1,96 @@
+package ca
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/rerorero/meshem/src/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func parseCert(t *testing.T, certPEM []byte) *x509.Certificate {
+	block, _ := pem.Decode(certPEM)
+	assert.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	assert.NoError(t, err)
+	return cert
+}
+
+func TestIssue(t *testing.T) {
+	conf := &model.MTLSConf{
+		Mode:               model.MTLSModeStrict,
+		TrustDomain:        "example.com",
+		CertTTLMinutes:     60,
+		RenewBeforeMinutes: 20,
+	}
+	authority, err := NewCertificateAuthority(conf, logrus.New())
+	assert.NoError(t, err)
+	sut := authority.(*builtinCA)
+	now := time.Now()
+	sut.now = func() time.Time { return now }
+
+	identity := conf.SpiffeID("app")
+	kp, err := sut.Issue("app1", identity)
+	assert.NoError(t, err)
+	assert.Equal(t, identity, kp.Identity)
+
+	// verify the certificate with the trust bundle
+	cert := parseCert(t, kp.CertPEM)
+	roots := x509.NewCertPool()
+	assert.True(t, roots.AppendCertsFromPEM(sut.TrustBundle()))
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: now,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "spiffe://example.com/service/app", cert.URIs[0].String())
+	assert.Equal(t, "app1", cert.Subject.CommonName)
+	assert.Equal(t, now.Add(time.Hour).Unix(), cert.NotAfter.Unix())
+
+	// the same certificate is returned until the renewal time
+	now = now.Add(39 * time.Minute)
+	kp2, err := sut.Issue("app1", identity)
+	assert.NoError(t, err)
+	assert.Equal(t, kp.Serial, kp2.Serial)
+
+	// rotated
+	now = now.Add(2 * time.Minute)
+	kp3, err := sut.Issue("app1", identity)
+	assert.NoError(t, err)
+	assert.NotEqual(t, kp.Serial, kp3.Serial)
+
+	// identity changed
+	kp4, err := sut.Issue("app1", conf.SpiffeID("other"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, kp3.Serial, kp4.Serial)
+
+	// other host
+	kp5, err := sut.Issue("app2", identity)
+	assert.NoError(t, err)
+	assert.NotEqual(t, kp4.Serial, kp5.Serial)
+}
+
+func TestParseCA(t *testing.T) {
+	cert, key, err := generateCA("example.com", time.Now())
+	assert.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+
+	parsedCert, parsedKey, err := parseCA(certPEM, keyPEM)
+	assert.NoError(t, err)
+	assert.Equal(t, cert.Raw, parsedCert.Raw)
+	assert.Equal(t, key.Public(), parsedKey.Public())
+
+	// invalid
+	_, _, err = parseCA([]byte("invalid"), keyPEM)
+	assert.Error(t, err)
+	_, _, err = parseCA(certPEM, []byte("invalid"))
+	assert.Error(t, err)
+}
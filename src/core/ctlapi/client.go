@@ -13,8 +13,9 @@ import (
 
 // APIClient is client for ctlapi.
 type APIClient struct {
-	endpoint *url.URL
-	client   http.Client
+	endpoint  *url.URL
+	client    http.Client
+	namespace string
 }
 
 // NewClient returns a new ApiClient.
@@ -32,6 +33,11 @@ func NewClient(endpoint string, timeout time.Duration) (*APIClient, error) {
 	}, nil
 }
 
+// SetNamespace sets the namespace from which the names in the requests are resolved.
+func (client *APIClient) SetNamespace(namespace string) {
+	client.namespace = namespace
+}
+
 // Post requests a POST method.
 func (client *APIClient) Post(url string, body interface{}) (int, []byte, error) {
 	return client.request(url, http.MethodPost, body)
@@ -61,6 +67,12 @@ func (client *APIClient) request(url string, method string, body interface{}) (i
 			return 0, nil, err
 		}
 	}
+	if len(client.namespace) > 0 {
+		url, err = client.withNamespace(url)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
 	req, err := http.NewRequest(method, url, bytes.NewBuffer(byte))
 	if err != nil {
 		return 0, nil, err
@@ -75,3 +87,14 @@ func (client *APIClient) request(url string, method string, body interface{}) (i
 	}
 	return res.StatusCode, resBody, err
 }
+
+func (client *APIClient) withNamespace(rawurl string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid url: %s", rawurl)
+	}
+	query := u.Query()
+	query.Set(NamespaceQuery, client.namespace)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,110 @@
+package ctlapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rerorero/meshem/src/model"
+)
+
+const (
+	// FaultURI is uri suffix for fault resources of the dependencies.
+	FaultURI = "fault"
+)
+
+// findDependency responds not found if the service or its dependency doesn't exist.
+func (srv *Server) findDependency(w http.ResponseWriter, name string, ref string) bool {
+	service, ok, err := srv.inventory.GetService(name)
+	if err != nil {
+		srv.respondError(http.StatusInternalServerError, w, err)
+		return false
+	}
+	if !ok {
+		srv.respondError(http.StatusNotFound, w, fmt.Errorf("service not found"))
+		return false
+	}
+	if ok, _ := service.FindDependentServiceRef(ref); !ok {
+		srv.respondError(http.StatusNotFound, w, fmt.Errorf("dependency not found"))
+		return false
+	}
+	return true
+}
+
+// putFault is handler to inject a fault into the requests to the dependency until it expires.
+func (srv *Server) putFault(w http.ResponseWriter, r *http.Request, param httprouter.Params, body []byte) {
+	var req model.FaultPolicy
+	if err := json.Unmarshal(body, &req); err != nil {
+		srv.respondError(http.StatusBadRequest, w, err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		srv.respondError(http.StatusBadRequest, w, err)
+		return
+	}
+	if req.ExpiresAt == nil || !req.ExpiresAt.After(time.Now()) {
+		srv.respondError(http.StatusBadRequest, w, fmt.Errorf("expiresAt must be a future time"))
+		return
+	}
+	namespace, ok := srv.namespaceOf(w, r)
+	if !ok {
+		return
+	}
+	name := model.ResolveName(namespace, param.ByName("name"))
+	ref := model.ResolveServiceRef(namespace, param.ByName("dependency"))
+	if !srv.findDependency(w, name, ref) {
+		return
+	}
+
+	err := srv.inventory.SetDependencyFault(name, ref, &req)
+	if err != nil {
+		srv.respondError(http.StatusInternalServerError, w, err)
+		return
+	}
+
+	srv.respondJson(http.StatusOK, w, &req)
+}
+
+// PutFault calls PUT fault.
+func (client *APIClient) PutFault(name string, dependency string, req model.FaultPolicy) (resp model.FaultPolicy, status int, err error) {
+	var body []byte
+	status, body, err = client.Put(client.faultURIof(name, dependency), req)
+	if err != nil {
+		return resp, status, err
+	}
+	err = json.Unmarshal(body, &resp)
+	return resp, status, err
+}
+
+// deleteFault is handler to remove the fault from the dependency.
+func (srv *Server) deleteFault(w http.ResponseWriter, r *http.Request, param httprouter.Params, _ []byte) {
+	namespace, ok := srv.namespaceOf(w, r)
+	if !ok {
+		return
+	}
+	name := model.ResolveName(namespace, param.ByName("name"))
+	ref := model.ResolveServiceRef(namespace, param.ByName("dependency"))
+	if !srv.findDependency(w, name, ref) {
+		return
+	}
+
+	err := srv.inventory.SetDependencyFault(name, ref, nil)
+	if err != nil {
+		srv.respondError(http.StatusInternalServerError, w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteFault calls DELETE fault.
+func (client *APIClient) DeleteFault(name string, dependency string) (status int, err error) {
+	status, _, err = client.Delete(client.faultURIof(name, dependency))
+	return status, err
+}
+
+func (client *APIClient) faultURIof(name string, dependency string) string {
+	return fmt.Sprintf("%s/%s/%s/dependencies/%s/%s", client.endpoint.String(), ServiceURI, name, dependency, FaultURI)
+}
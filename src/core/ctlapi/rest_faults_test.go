@@ -0,0 +1,99 @@
+package ctlapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rerorero/meshem/src/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPutFault(t *testing.T) {
+	inventory := MockedInventory{}
+	server := NewServer(&inventory, model.CtlAPIConf{}, logrus.New())
+	sut := httptest.NewServer(server)
+	defer sut.Close()
+	client, _ := NewClient(sut.URL, 60*time.Second)
+
+	svc := model.Service{
+		Name:              "front",
+		Protocol:          model.ProtocolHTTP,
+		DependentServices: []model.DependentService{{Name: "app", EgressPort: 9001}},
+	}
+	inventory.On("GetService", "front").Return(svc, true, nil)
+	inventory.On("GetService", "unknown").Return(model.Service{}, false, nil)
+	inventory.On("SetDependencyFault", "front", "app", mock.MatchedBy(func(f *model.FaultPolicy) bool {
+		return f != nil && f.Abort.HTTPStatus == 503
+	})).Return(nil)
+
+	expiresAt := time.Now().Add(time.Hour)
+	fault := model.FaultPolicy{
+		Abort:     &model.FaultAbort{Percentage: 10, HTTPStatus: 503},
+		ExpiresAt: &expiresAt,
+	}
+	actual, status, err := client.PutFault("front", "app", fault)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, fault.Abort, actual.Abort)
+	assert.True(t, expiresAt.Equal(*actual.ExpiresAt))
+
+	// without expiration
+	_, status, err = client.PutFault("front", "app", model.FaultPolicy{Abort: fault.Abort})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// expired
+	past := time.Now().Add(-time.Minute)
+	_, status, err = client.PutFault("front", "app", model.FaultPolicy{Abort: fault.Abort, ExpiresAt: &past})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// invalid faults
+	_, status, err = client.PutFault("front", "app", model.FaultPolicy{Abort: &model.FaultAbort{Percentage: 150, HTTPStatus: 503}, ExpiresAt: &expiresAt})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+	_, status, err = client.PutFault("front", "app", model.FaultPolicy{Abort: &model.FaultAbort{Percentage: 10}, ExpiresAt: &expiresAt})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+	_, status, err = client.PutFault("front", "app", model.FaultPolicy{ExpiresAt: &expiresAt})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+	inventory.AssertNumberOfCalls(t, "SetDependencyFault", 1)
+
+	// not found
+	_, status, err = client.PutFault("unknown", "app", fault)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, status)
+	_, status, err = client.PutFault("front", "unknown", fault)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestDeleteFault(t *testing.T) {
+	inventory := MockedInventory{}
+	server := NewServer(&inventory, model.CtlAPIConf{}, logrus.New())
+	sut := httptest.NewServer(server)
+	defer sut.Close()
+	client, _ := NewClient(sut.URL, 60*time.Second)
+
+	svc := model.Service{
+		Name:              "front",
+		Protocol:          model.ProtocolHTTP,
+		DependentServices: []model.DependentService{{Name: "app", EgressPort: 9001}},
+	}
+	inventory.On("GetService", "front").Return(svc, true, nil)
+	inventory.On("SetDependencyFault", "front", "app", (*model.FaultPolicy)(nil)).Return(nil)
+
+	status, err := client.DeleteFault("front", "app")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status)
+	inventory.AssertCalled(t, "SetDependencyFault", "front", "app", (*model.FaultPolicy)(nil))
+
+	status, err = client.DeleteFault("front", "unknown")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, status)
+}
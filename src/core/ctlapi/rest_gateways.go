@@ -0,0 +1,101 @@
+package ctlapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rerorero/meshem/src/model"
+)
+
+const (
+	// GatewayURI is uri prefix for gateway resources.
+	GatewayURI = "gateways"
+)
+
+// PutGatewayResp is the response type of PUT gateway method.
+type PutGatewayResp struct {
+	Changed bool `json:"changed"`
+}
+
+// getGateway is handler to get a gateway.
+func (srv *Server) getGateway(w http.ResponseWriter, r *http.Request, param httprouter.Params, _ []byte) {
+	gw, ok, err := srv.inventory.GetGateway(param.ByName("name"))
+	if err != nil {
+		srv.respondError(http.StatusInternalServerError, w, err)
+		return
+	}
+	if !ok {
+		srv.respondError(http.StatusNotFound, w, fmt.Errorf("not found"))
+		return
+	}
+
+	res := model.NewGatewayParam(&gw)
+	srv.respondJson(http.StatusOK, w, res)
+}
+
+// GetGateway calls GET gateway.
+func (client *APIClient) GetGateway(name string) (resp model.GatewayParam, status int, err error) {
+	var body []byte
+	status, body, err = client.Get(client.gatewayURIof(name))
+	if err != nil {
+		return resp, status, err
+	}
+	err = json.Unmarshal(body, &resp)
+	return resp, status, err
+}
+
+// putGateway is handler to create/update a gateway idempotently.
+func (srv *Server) putGateway(w http.ResponseWriter, r *http.Request, param httprouter.Params, body []byte) {
+	var req model.GatewayParam
+	if err := json.Unmarshal(body, &req); err != nil {
+		srv.respondError(http.StatusBadRequest, w, err)
+		return
+	}
+
+	changed, err := srv.inventory.IdempotentGateway(param.ByName("name"), req)
+	if err != nil {
+		srv.respondError(http.StatusInternalServerError, w, err)
+		return
+	}
+
+	res := PutGatewayResp{Changed: changed}
+	srv.respondJson(http.StatusOK, w, &res)
+}
+
+// PutGateway calls PUT gateway.
+func (client *APIClient) PutGateway(name string, req model.GatewayParam) (resp PutGatewayResp, status int, err error) {
+	var body []byte
+	status, body, err = client.Put(client.gatewayURIof(name), req)
+	if err != nil {
+		return resp, status, err
+	}
+	err = json.Unmarshal(body, &resp)
+	return resp, status, err
+}
+
+// deleteGateway is handler to remove a gateway.
+func (srv *Server) deleteGateway(w http.ResponseWriter, r *http.Request, param httprouter.Params, _ []byte) {
+	deleted, err := srv.inventory.UnregisterGateway(param.ByName("name"))
+	if err != nil {
+		srv.respondError(http.StatusInternalServerError, w, err)
+		return
+	}
+	if !deleted {
+		srv.respondError(http.StatusNotFound, w, fmt.Errorf("not found"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteGateway calls DELETE gateway.
+func (client *APIClient) DeleteGateway(name string) (status int, err error) {
+	status, _, err = client.Delete(client.gatewayURIof(name))
+	return status, err
+}
+
+func (client *APIClient) gatewayURIof(name string) string {
+	return fmt.Sprintf("%s/%s/%s", client.endpoint.String(), GatewayURI, name)
+}
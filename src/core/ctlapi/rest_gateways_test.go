@@ -0,0 +1,64 @@
+package ctlapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rerorero/meshem/src/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway(t *testing.T) {
+	inventory := MockedInventory{}
+	server := NewServer(&inventory, model.CtlAPIConf{}, logrus.New())
+	sut := httptest.NewServer(server)
+	defer sut.Close()
+	client, _ := NewClient(sut.URL, 60*time.Second)
+
+	param := model.GatewayParam{
+		Nodes: []string{"gw1"},
+		Listeners: []model.GatewayListener{{
+			Address: model.Address{Hostname: "0.0.0.0", Port: 443},
+			TLS:     &model.GatewayTLS{CertFile: "/etc/cert.pem", KeyFile: "/etc/key.pem"},
+			VirtualHosts: []model.GatewayVirtualHost{{
+				Name:    "front",
+				Domains: []string{"example.com"},
+				Routes:  []model.RouteRule{{Prefix: "/", Service: "front"}},
+			}},
+		}},
+	}
+	gw := param.NewGateway("public")
+	gw.Version = "abc"
+	inventory.On("IdempotentGateway", "public", param).Return(true, nil)
+	inventory.On("GetGateway", "public").Return(gw, true, nil)
+	inventory.On("GetGateway", "unknown").Return(model.Gateway{}, false, nil)
+	inventory.On("UnregisterGateway", "public").Return(true, nil)
+	inventory.On("UnregisterGateway", "unknown").Return(false, nil)
+
+	// put
+	resp, status, err := client.PutGateway("public", param)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.True(t, resp.Changed)
+	inventory.AssertCalled(t, "IdempotentGateway", "public", param)
+
+	// get
+	actual, status, err := client.GetGateway("public")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, param, actual)
+	_, status, err = client.GetGateway("unknown")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// delete
+	status, err = client.DeleteGateway("public")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, status)
+	status, err = client.DeleteGateway("unknown")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, status)
+}
@@ -0,0 +1,133 @@
+package ctlapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rerorero/meshem/src/model"
+	"github.com/rerorero/meshem/src/utils"
+)
+
+const (
+	// HostURI is uri suffix for host resources of the services.
+	HostURI = "hosts"
+	// StateURI is uri suffix for the state of the hosts.
+	StateURI = "state"
+)
+
+// PutHostStateReq is the request type of PUT host state method.
+type PutHostStateReq struct {
+	State string `json:"state"`
+}
+
+// findHost responds not found if the service or its host doesn't exist.
+func (srv *Server) findHost(w http.ResponseWriter, name string, host string) bool {
+	service, ok, err := srv.inventory.GetService(name)
+	if err != nil {
+		srv.respondError(http.StatusInternalServerError, w, err)
+		return false
+	}
+	if !ok {
+		srv.respondError(http.StatusNotFound, w, fmt.Errorf("service not found"))
+		return false
+	}
+	if _, ok := utils.ContainsString(service.HostNames, host); !ok {
+		srv.respondError(http.StatusNotFound, w, fmt.Errorf("host not found"))
+		return false
+	}
+	return true
+}
+
+// getHosts is handler to list the hosts of a service which match the label selector.
+func (srv *Server) getHosts(w http.ResponseWriter, r *http.Request, param httprouter.Params, _ []byte) {
+	selector, err := model.ParseLabelSelector(r.URL.Query().Get(SelectorQuery))
+	if err != nil {
+		srv.respondError(http.StatusBadRequest, w, err)
+		return
+	}
+	namespace, ok := srv.namespaceOf(w, r)
+	if !ok {
+		return
+	}
+	name := model.ResolveName(namespace, param.ByName("name"))
+	_, ok, err = srv.inventory.GetService(name)
+	if err != nil {
+		srv.respondError(http.StatusInternalServerError, w, err)
+		return
+	}
+	if !ok {
+		srv.respondError(http.StatusNotFound, w, fmt.Errorf("service not found"))
+		return
+	}
+
+	hosts, err := srv.inventory.GetHostsOfServiceByLabels(name, selector)
+	if err != nil {
+		srv.respondError(http.StatusInternalServerError, w, err)
+		return
+	}
+	srv.respondJson(http.StatusOK, w, hosts)
+}
+
+// GetHosts calls GET hosts. All hosts of the service are returned if the selector is empty.
+func (client *APIClient) GetHosts(name string, selector string) (resp []model.Host, status int, err error) {
+	var body []byte
+	status, body, err = client.Get(fmt.Sprintf("%s/%s/%s/%s/?%s=%s", client.endpoint.String(), ServiceURI, name, HostURI, SelectorQuery, url.QueryEscape(selector)))
+	// the error response is not a list
+	if err != nil || status != http.StatusOK {
+		return resp, status, err
+	}
+	err = json.Unmarshal(body, &resp)
+	return resp, status, err
+}
+
+// putHostState is handler to change the state of a host.
+func (srv *Server) putHostState(w http.ResponseWriter, r *http.Request, param httprouter.Params, body []byte) {
+	var req PutHostStateReq
+	if err := json.Unmarshal(body, &req); err != nil {
+		srv.respondError(http.StatusBadRequest, w, err)
+		return
+	}
+	if len(req.State) == 0 {
+		srv.respondError(http.StatusBadRequest, w, fmt.Errorf("state is required"))
+		return
+	}
+	if err := model.ValidateHostState(req.State); err != nil {
+		srv.respondError(http.StatusBadRequest, w, err)
+		return
+	}
+	namespace, ok := srv.namespaceOf(w, r)
+	if !ok {
+		return
+	}
+	name := model.ResolveName(namespace, param.ByName("name"))
+	hostName := model.ResolveName(namespace, param.ByName("host"))
+	if !srv.findHost(w, name, hostName) {
+		return
+	}
+
+	host, err := srv.inventory.SetHostState(name, hostName, req.State)
+	if err != nil {
+		srv.respondError(http.StatusInternalServerError, w, err)
+		return
+	}
+
+	srv.respondJson(http.StatusOK, w, &host)
+}
+
+// PutHostState calls PUT host state.
+func (client *APIClient) PutHostState(name string, host string, req PutHostStateReq) (resp model.Host, status int, err error) {
+	var body []byte
+	status, body, err = client.Put(client.hostStateURIof(name, host), req)
+	if err != nil {
+		return resp, status, err
+	}
+	err = json.Unmarshal(body, &resp)
+	return resp, status, err
+}
+
+func (client *APIClient) hostStateURIof(name string, host string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s/%s", client.endpoint.String(), ServiceURI, name, HostURI, host, StateURI)
+}
@@ -0,0 +1,91 @@
+package ctlapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rerorero/meshem/src/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPutHostState(t *testing.T) {
+	inventory := MockedInventory{}
+	server := NewServer(&inventory, model.CtlAPIConf{}, logrus.New())
+	sut := httptest.NewServer(server)
+	defer sut.Close()
+	client, _ := NewClient(sut.URL, 60*time.Second)
+
+	svc := model.Service{
+		Name:      "front",
+		Protocol:  model.ProtocolHTTP,
+		HostNames: []string{"front1"},
+	}
+	now := time.Now()
+	host := model.Host{Name: "front1", State: model.HostStateDraining, DrainingSince: &now}
+	inventory.On("GetService", "front").Return(svc, true, nil)
+	inventory.On("GetService", "unknown").Return(model.Service{}, false, nil)
+	inventory.On("SetHostState", "front", "front1", model.HostStateDraining).Return(host, nil)
+
+	actual, status, err := client.PutHostState("front", "front1", PutHostStateReq{State: model.HostStateDraining})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, model.HostStateDraining, actual.State)
+	assert.True(t, now.Equal(*actual.DrainingSince))
+
+	// invalid state
+	_, status, err = client.PutHostState("front", "front1", PutHostStateReq{State: "unknown"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+	_, status, err = client.PutHostState("front", "front1", PutHostStateReq{})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// not found
+	_, status, err = client.PutHostState("unknown", "front1", PutHostStateReq{State: model.HostStateDraining})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, status)
+	_, status, err = client.PutHostState("front", "unknown", PutHostStateReq{State: model.HostStateDraining})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestGetHosts(t *testing.T) {
+	inventory := MockedInventory{}
+	server := NewServer(&inventory, model.CtlAPIConf{}, logrus.New())
+	sut := httptest.NewServer(server)
+	defer sut.Close()
+	client, _ := NewClient(sut.URL, 60*time.Second)
+
+	svc := model.Service{
+		Name:      "front",
+		Protocol:  model.ProtocolHTTP,
+		HostNames: []string{"front1", "front2"},
+		Labels:    map[string]string{"team": "payments"},
+	}
+	hosts := []model.Host{{Name: "front1", Labels: map[string]string{"tier": "web"}}}
+	selector := model.LabelSelector{
+		{Key: "team", Value: "payments", Operator: model.LabelEquals},
+		{Key: "tier", Value: "batch", Operator: model.LabelNotEquals},
+	}
+	inventory.On("GetService", "front").Return(svc, true, nil)
+	inventory.On("GetService", "unknown").Return(model.Service{}, false, nil)
+	inventory.On("GetHostsOfServiceByLabels", "front", selector).Return(hosts, nil)
+
+	actual, status, err := client.GetHosts("front", "team=payments,tier!=batch")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, hosts, actual)
+
+	// invalid selector
+	_, status, err = client.GetHosts("front", "team=pay ments")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// not found
+	_, status, err = client.GetHosts("unknown", "")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, status)
+}
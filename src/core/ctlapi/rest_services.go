@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/rerorero/meshem/src/model"
@@ -27,7 +28,12 @@ func (srv *Server) postSerivce(w http.ResponseWriter, r *http.Request, param htt
 		return
 	}
 
-	service, err := srv.inventory.RegisterService(param.ByName("name"), req.Protocol)
+	namespace, ok := srv.namespaceOf(w, r)
+	if !ok {
+		return
+	}
+
+	service, err := srv.inventory.RegisterService(model.ResolveName(namespace, param.ByName("name")), req.Protocol)
 	if err != nil {
 		srv.respondError(http.StatusInternalServerError, w, err)
 		return
@@ -49,7 +55,12 @@ func (client *APIClient) PostService(name string, req PostServiceReq) (service m
 
 // getSerivce is handler to get a service.
 func (srv *Server) getSerivce(w http.ResponseWriter, r *http.Request, param httprouter.Params, _ []byte) {
-	service, ok, err := srv.inventory.GetService(param.ByName("name"))
+	namespace, ok := srv.namespaceOf(w, r)
+	if !ok {
+		return
+	}
+
+	service, ok, err := srv.inventory.GetService(model.ResolveName(namespace, param.ByName("name")))
 	if err != nil {
 		srv.respondError(http.StatusInternalServerError, w, err)
 		return
@@ -64,8 +75,9 @@ func (srv *Server) getSerivce(w http.ResponseWriter, r *http.Request, param http
 		srv.respondError(http.StatusInternalServerError, w, err)
 		return
 	}
+	// the names are relative to the namespace so that the response can be put back as it is
 	res := model.NewIdempotentService(&service, hosts)
-	srv.respondJson(http.StatusOK, w, res)
+	srv.respondJson(http.StatusOK, w, res.RelativeNames(namespace))
 }
 
 // GetService calls GET service.
@@ -79,6 +91,48 @@ func (client *APIClient) GetService(name string) (resp model.IdempotentServicePa
 	return resp, status, err
 }
 
+// getServices is handler to list the services which match the label selector.
+// The services in all namespaces are listed unless the namespace is specified.
+func (srv *Server) getServices(w http.ResponseWriter, r *http.Request, param httprouter.Params, _ []byte) {
+	selector, err := model.ParseLabelSelector(r.URL.Query().Get(SelectorQuery))
+	if err != nil {
+		srv.respondError(http.StatusBadRequest, w, err)
+		return
+	}
+	namespace := r.URL.Query().Get(NamespaceQuery)
+	if len(namespace) > 0 {
+		if err := model.ValidateNamespace(namespace); err != nil {
+			srv.respondError(http.StatusBadRequest, w, err)
+			return
+		}
+	}
+
+	services, err := srv.inventory.GetServicesByLabels(selector)
+	if err != nil {
+		srv.respondError(http.StatusInternalServerError, w, err)
+		return
+	}
+	filtered := []model.Service{}
+	for _, svc := range services {
+		if len(namespace) == 0 || svc.Namespace() == namespace {
+			filtered = append(filtered, svc)
+		}
+	}
+	srv.respondJson(http.StatusOK, w, filtered)
+}
+
+// GetServices calls GET services. All services are returned if the selector is empty.
+func (client *APIClient) GetServices(selector string) (resp []model.Service, status int, err error) {
+	var body []byte
+	status, body, err = client.Get(fmt.Sprintf("%s/%s/?%s=%s", client.endpoint.String(), ServiceURI, SelectorQuery, url.QueryEscape(selector)))
+	// the error response is not a list
+	if err != nil || status != http.StatusOK {
+		return resp, status, err
+	}
+	err = json.Unmarshal(body, &resp)
+	return resp, status, err
+}
+
 // putService is handler to create/update a service idempotently.
 func (srv *Server) putSerivce(w http.ResponseWriter, r *http.Request, param httprouter.Params, body []byte) {
 	var req model.IdempotentServiceParam
@@ -87,7 +141,12 @@ func (srv *Server) putSerivce(w http.ResponseWriter, r *http.Request, param http
 		return
 	}
 
-	changed, err := srv.inventory.IdempotentService(param.ByName("name"), req)
+	namespace, ok := srv.namespaceOf(w, r)
+	if !ok {
+		return
+	}
+
+	changed, err := srv.inventory.IdempotentService(model.ResolveName(namespace, param.ByName("name")), req.ResolveNames(namespace))
 	if err != nil {
 		srv.respondError(http.StatusInternalServerError, w, err)
 		return
@@ -33,6 +33,10 @@ func (i *MockedInventory) GetServiceNames() ([]string, error) {
 	args := i.Called()
 	return args.Get(0).([]string), args.Error(1)
 }
+func (i *MockedInventory) GetServicesByLabels(selector model.LabelSelector) ([]model.Service, error) {
+	args := i.Called(selector)
+	return args.Get(0).([]model.Service), args.Error(1)
+}
 func (i *MockedInventory) AddServiceDependency(serviceName string, dependServiceNames string, egressPort uint32) error {
 	args := i.Called(serviceName, dependServiceNames, egressPort)
 	return args.Error(0)
@@ -65,6 +69,10 @@ func (i *MockedInventory) GetHostsOfService(serviceName string) ([]model.Host, e
 	args := i.Called(serviceName)
 	return args.Get(0).([]model.Host), args.Error(1)
 }
+func (i *MockedInventory) GetHostsOfServiceByLabels(serviceName string, selector model.LabelSelector) ([]model.Host, error) {
+	args := i.Called(serviceName, selector)
+	return args.Get(0).([]model.Host), args.Error(1)
+}
 func (i *MockedInventory) UpdateHost(serviceName string, hostName string, ingressAddr, substanceAddr, egressHost *string) (host model.Host, err error) {
 	args := i.Called(serviceName, hostName, ingressAddr, substanceAddr, egressHost)
 	return args.Get(0).(model.Host), args.Error(1)
@@ -73,6 +81,38 @@ func (i *MockedInventory) IdempotentService(serviceName string, param model.Idem
 	args := i.Called(serviceName, param)
 	return args.Bool(0), args.Error(1)
 }
+func (i *MockedInventory) SetDependencyFault(serviceName string, dependServiceName string, fault *model.FaultPolicy) error {
+	args := i.Called(serviceName, dependServiceName, fault)
+	return args.Error(0)
+}
+func (i *MockedInventory) RemoveExpiredFaults(now time.Time) error {
+	args := i.Called(now)
+	return args.Error(0)
+}
+func (i *MockedInventory) SetHostState(serviceName string, hostName string, state string) (model.Host, error) {
+	args := i.Called(serviceName, hostName, state)
+	return args.Get(0).(model.Host), args.Error(1)
+}
+func (i *MockedInventory) RemoveDrainedHosts(now time.Time, gracePeriod time.Duration) error {
+	args := i.Called(now, gracePeriod)
+	return args.Error(0)
+}
+func (i *MockedInventory) IdempotentGateway(name string, param model.GatewayParam) (bool, error) {
+	args := i.Called(name, param)
+	return args.Bool(0), args.Error(1)
+}
+func (i *MockedInventory) GetGateway(name string) (model.Gateway, bool, error) {
+	args := i.Called(name)
+	return args.Get(0).(model.Gateway), args.Bool(1), args.Error(2)
+}
+func (i *MockedInventory) GetGatewayNames() ([]string, error) {
+	args := i.Called()
+	return args.Get(0).([]string), args.Error(1)
+}
+func (i *MockedInventory) UnregisterGateway(name string) (bool, error) {
+	args := i.Called(name)
+	return args.Bool(0), args.Error(1)
+}
 
 func TestPostService(t *testing.T) {
 	inventory := MockedInventory{}
@@ -99,6 +139,35 @@ func TestPostService(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, status)
 }
 
+func TestGetServices(t *testing.T) {
+	inventory := MockedInventory{}
+	server := NewServer(&inventory, model.CtlAPIConf{}, logrus.New())
+	sut := httptest.NewServer(server)
+	defer sut.Close()
+	client, _ := NewClient(sut.URL, 60*time.Second)
+
+	expect := []model.Service{
+		{Name: "app", Protocol: model.ProtocolHTTP, Labels: map[string]string{"team": "payments"}},
+	}
+	inventory.On("GetServicesByLabels", model.LabelSelector{{Key: "team", Value: "payments", Operator: model.LabelEquals}}).Return(expect, nil)
+	inventory.On("GetServicesByLabels", model.LabelSelector{}).Return(expect, nil)
+
+	actual, status, err := client.GetServices("team=payments")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, expect, actual)
+
+	actual, status, err = client.GetServices("")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, expect, actual)
+
+	// invalid selector
+	_, status, err = client.GetServices("!=payments")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
 func TestGetService(t *testing.T) {
 	inventory := MockedInventory{}
 	server := NewServer(&inventory, model.CtlAPIConf{}, logrus.New())
@@ -107,13 +176,27 @@ func TestGetService(t *testing.T) {
 	client, _ := NewClient(sut.URL, 60*time.Second)
 
 	expect := model.IdempotentServiceParam{
-		Protocol: "HTTP",
-		Hosts: []model.Host{{
-			Name:          "host1",
-			IngressAddr:   model.Address{Hostname: "host1", Port: 1234},
-			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 5678},
-			EgressHost:    "hostname",
-		}},
+		Protocol:  "HTTP",
+		RateLimit: &model.RateLimit{RequestsPerSecond: 100, Burst: 150},
+		LoadBalancer: &model.LoadBalancer{
+			Policy:  model.LBRingHash,
+			HashKey: &model.HashKey{Header: "x-user-id"},
+		},
+		Hosts: []model.Host{
+			{
+				Name:          "host1",
+				IngressAddr:   model.Address{Hostname: "host1", Port: 1234},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 5678},
+				EgressHost:    "hostname",
+			},
+			{
+				Name:          "host2",
+				IngressAddr:   model.Address{Hostname: "host2", Port: 1234},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 5678},
+				EgressHost:    "hostname",
+				AdminAddr:     &model.Address{Hostname: "host2", Port: 18001},
+			},
+		},
 	}
 	expectsvc := expect.NewService("test1")
 	inventory.On("GetService", "test1").Return(expectsvc, true, nil)
@@ -152,6 +235,7 @@ func TestIdempotentService(t *testing.T) {
 				IngressAddr:   model.Address{Hostname: "192.168.0.2", Port: 9000},
 				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 9001},
 				EgressHost:    "127.0.0.1",
+				AdminAddr:     &model.Address{Hostname: "127.0.0.1", Port: 18001},
 			},
 		},
 	}
@@ -168,3 +252,69 @@ func TestIdempotentService(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, http.StatusInternalServerError, status)
 }
+
+func TestServiceNamespace(t *testing.T) {
+	inventory := MockedInventory{}
+	server := NewServer(&inventory, model.CtlAPIConf{}, logrus.New())
+	sut := httptest.NewServer(server)
+	defer sut.Close()
+	client, _ := NewClient(sut.URL, 60*time.Second)
+	client.SetNamespace("payments")
+
+	param := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		DependentServices: []model.DependentService{
+			{Name: "db", EgressPort: 9001},
+			{Name: "db.billing", EgressPort: 9002},
+			{Name: "cache.default", EgressPort: 9003},
+		},
+		Hosts: []model.Host{
+			{
+				Name:          "api1",
+				IngressAddr:   model.Address{Hostname: "192.168.0.2", Port: 9000},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 9001},
+				EgressHost:    "127.0.0.1",
+			},
+		},
+	}
+	resolved := param.ResolveNames("payments")
+	assert.Equal(t, "cache", resolved.DependentServices[2].Name)
+
+	// the names are resolved from the namespace
+	inventory.On("IdempotentService", "api.payments", resolved).Return(true, nil)
+	_, status, err := client.PutService("api", param)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+
+	// and returned relatively
+	svc := model.Service{
+		Name:              "api.payments",
+		Protocol:          model.ProtocolHTTP,
+		HostNames:         []string{"api1.payments"},
+		DependentServices: resolved.DependentServices,
+	}
+	inventory.On("GetService", "api.payments").Return(svc, true, nil)
+	inventory.On("GetHostsOfService", "api.payments").Return(resolved.Hosts, nil)
+	actual, status, err := client.GetService("api")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, param, actual)
+
+	// list the services in the namespace
+	services := []model.Service{svc, {Name: "api", Protocol: model.ProtocolHTTP}}
+	inventory.On("GetServicesByLabels", model.LabelSelector{}).Return(services, nil)
+	listed, status, err := client.GetServices("")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []model.Service{svc}, listed)
+	client.SetNamespace("")
+	listed, _, err = client.GetServices("")
+	assert.NoError(t, err)
+	assert.Equal(t, services, listed)
+
+	// invalid namespace
+	client.SetNamespace("pay.ments")
+	_, status, err = client.GetService("api")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
@@ -33,6 +33,10 @@ type errorRes struct {
 const (
 	// ServiceURI is uri prefix for service resources.
 	ServiceURI = "services"
+	// SelectorQuery is the query parameter of the label selector to filter the listed resources.
+	SelectorQuery = "selector"
+	// NamespaceQuery is the query parameter of the namespace from which the names in the request are resolved.
+	NamespaceQuery = "namespace"
 )
 
 // NewServer creates a new API server.
@@ -44,9 +48,17 @@ func NewServer(inventory core.InventoryService, conf model.CtlAPIConf, logger *l
 		logger:         logger,
 		bodyMaxbyteLen: 1024 * 1024,
 	}
+	srv.router.GET(fmt.Sprintf("/%s/", ServiceURI), srv.handlerOf(srv.getServices))
 	srv.router.POST(fmt.Sprintf("/%s/:name/", ServiceURI), srv.handlerOf(srv.postSerivce))
 	srv.router.GET(fmt.Sprintf("/%s/:name/", ServiceURI), srv.handlerOf(srv.getSerivce))
 	srv.router.PUT(fmt.Sprintf("/%s/:name/", ServiceURI), srv.handlerOf(srv.putSerivce))
+	srv.router.PUT(fmt.Sprintf("/%s/:name/dependencies/:dependency/%s", ServiceURI, FaultURI), srv.handlerOf(srv.putFault))
+	srv.router.DELETE(fmt.Sprintf("/%s/:name/dependencies/:dependency/%s", ServiceURI, FaultURI), srv.handlerOf(srv.deleteFault))
+	srv.router.GET(fmt.Sprintf("/%s/:name/%s/", ServiceURI, HostURI), srv.handlerOf(srv.getHosts))
+	srv.router.PUT(fmt.Sprintf("/%s/:name/%s/:host/%s", ServiceURI, HostURI, StateURI), srv.handlerOf(srv.putHostState))
+	srv.router.GET(fmt.Sprintf("/%s/:name/", GatewayURI), srv.handlerOf(srv.getGateway))
+	srv.router.PUT(fmt.Sprintf("/%s/:name/", GatewayURI), srv.handlerOf(srv.putGateway))
+	srv.router.DELETE(fmt.Sprintf("/%s/:name/", GatewayURI), srv.handlerOf(srv.deleteGateway))
 	return srv
 }
 
@@ -86,6 +98,20 @@ func (srv *Server) respondError(code int, w http.ResponseWriter, err error) {
 	}
 }
 
+// namespaceOf returns the namespace of the request, which is the default namespace if it's omitted.
+// It responds bad request if the namespace is invalid.
+func (srv *Server) namespaceOf(w http.ResponseWriter, r *http.Request) (string, bool) {
+	namespace := r.URL.Query().Get(NamespaceQuery)
+	if len(namespace) == 0 {
+		return model.DefaultNamespace, true
+	}
+	if err := model.ValidateNamespace(namespace); err != nil {
+		srv.respondError(http.StatusBadRequest, w, err)
+		return "", false
+	}
+	return namespace, true
+}
+
 func (srv *Server) authenticate(r *http.Request) error {
 	// TODO
 	return nil
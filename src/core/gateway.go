@@ -0,0 +1,175 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/rerorero/meshem/src/model"
+	"github.com/rerorero/meshem/src/utils"
+)
+
+// IdempotentGateway creates or updates a gateway idempotently.
+func (inv *inventoryService) IdempotentGateway(name string, param model.GatewayParam) (changed bool, err error) {
+	gw := param.NewGateway(name)
+	err = gw.Validate()
+	if err != nil {
+		return false, err
+	}
+	err = inv.validateGatewayNodes(&gw)
+	if err != nil {
+		return false, err
+	}
+	err = inv.validateGatewayRouteTargets(&gw)
+	if err != nil {
+		return false, err
+	}
+
+	current, ok, err := inv.GetGateway(name)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		gw.Version = current.Version
+		if reflect.DeepEqual(current, gw) {
+			return false, nil
+		}
+	}
+
+	version := inv.versionGen.New()
+	err = inv.repo.PutGateway(gw, version)
+	if err != nil {
+		return false, err
+	}
+	inv.logger.Infof("Gateway %s is updated! version=%s", name, version)
+	return true, nil
+}
+
+// GetGateway finds a gateway by name.
+func (inv *inventoryService) GetGateway(name string) (model.Gateway, bool, error) {
+	return inv.repo.SelectGatewayByName(name)
+}
+
+// GetGatewayNames returns all gateway names.
+func (inv *inventoryService) GetGatewayNames() ([]string, error) {
+	return inv.repo.SelectAllGatewayNames()
+}
+
+// UnregisterGateway removes the gateway.
+func (inv *inventoryService) UnregisterGateway(name string) (bool, error) {
+	deleted, err := inv.repo.DeleteGateway(name)
+	if err != nil {
+		return false, err
+	}
+	if deleted {
+		inv.logger.Infof("Gateway %s is deleted!", name)
+	}
+	return deleted, nil
+}
+
+// validateGatewayNodes checks that the nodes of the gateway are not used by the hosts and the other gateways,
+// since the snapshots are identified by the node names.
+func (inv *inventoryService) validateGatewayNodes(gw *model.Gateway) error {
+	hostNames, err := inv.repo.SelectAllHostNames()
+	if err != nil {
+		return err
+	}
+	for _, node := range gw.Nodes {
+		if _, ok := utils.ContainsString(hostNames, node); ok {
+			return fmt.Errorf("node=%s of gateway=%s is already used by a host", node, gw.Name)
+		}
+	}
+
+	names, err := inv.GetGatewayNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name == gw.Name {
+			continue
+		}
+		other, ok, err := inv.GetGateway(name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		for _, node := range gw.Nodes {
+			if _, ok := utils.ContainsString(other.Nodes, node); ok {
+				return fmt.Errorf("node=%s of gateway=%s is already used by gateway=%s", node, gw.Name, other.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// validateGatewayRouteTargets checks that the services to which the gateway routes exist and speak HTTP.
+func (inv *inventoryService) validateGatewayRouteTargets(gw *model.Gateway) error {
+	for _, l := range gw.Listeners {
+		for _, vh := range l.VirtualHosts {
+			for _, rule := range vh.Routes {
+				if rule.Redirect != nil {
+					continue
+				}
+				target, ok, err := inv.GetService(rule.Service)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return fmt.Errorf("route target service=%s of gateway=%s is not found", rule.Service, gw.Name)
+				}
+				if !model.IsHTTPBasedProtocol(target.Protocol) {
+					return fmt.Errorf("route target service=%s of gateway=%s provides %s protocol", rule.Service, gw.Name, target.Protocol)
+				}
+				if len(rule.Subset) > 0 {
+					if ok, _ := target.FindSubset(rule.Subset); !ok {
+						return fmt.Errorf("route target subset=%s is not defined in service=%s", rule.Subset, rule.Service)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// gatewayNodeOf returns the name of the gateway which has the node, it's used to prevent the hosts from colliding with the nodes.
+func (inv *inventoryService) gatewayNodeOf(node string) (string, bool, error) {
+	names, err := inv.GetGatewayNames()
+	if err != nil {
+		return "", false, err
+	}
+	for _, name := range names {
+		gw, ok, err := inv.GetGateway(name)
+		if err != nil {
+			return "", false, err
+		}
+		if !ok {
+			continue
+		}
+		if _, ok := utils.ContainsString(gw.Nodes, node); ok {
+			return name, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// gatewaysRoutingTo returns the names of the gateways which route to the service.
+func (inv *inventoryService) gatewaysRoutingTo(service string) (referrers []string, err error) {
+	names, err := inv.GetGatewayNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		gw, ok, err := inv.GetGateway(name)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if _, ok := utils.ContainsString(gw.TargetServiceNames(), service); ok {
+			referrers = append(referrers, name)
+		}
+	}
+	return referrers, nil
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/rerorero/meshem/src/model"
+	"github.com/rerorero/meshem/src/repository"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotentGateway(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := &MockedVersionGen{Version: "abc"}
+	sut := NewInventoryService(repo, nil, gen, logrus.New())
+
+	_, err := sut.IdempotentService("front", model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Subsets:  []model.SubsetWeight{{Name: "v1", Weight: 1}},
+		Hosts: []model.Host{{
+			Name:          "front1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+			Subset:        "v1",
+		}},
+	})
+	assert.NoError(t, err)
+	_, err = sut.IdempotentService("db", model.IdempotentServiceParam{Protocol: model.ProtocolTCP})
+	assert.NoError(t, err)
+
+	param := model.GatewayParam{
+		Nodes: []string{"gw1", "gw2"},
+		Listeners: []model.GatewayListener{{
+			Address: model.Address{Hostname: "0.0.0.0", Port: 80},
+			VirtualHosts: []model.GatewayVirtualHost{{
+				Name:    "front",
+				Domains: []string{"*"},
+				Routes:  []model.RouteRule{{Prefix: "/", Service: "front", Subset: "v1"}},
+			}},
+		}},
+	}
+	changed, err := sut.IdempotentGateway("public", param)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = sut.IdempotentGateway("public", param)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	actual, ok, err := sut.GetGateway("public")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, model.Gateway{Name: "public", Nodes: param.Nodes, Listeners: param.Listeners, Version: "abc"}, actual)
+
+	// update
+	gen.Version = "def"
+	param.Nodes = []string{"gw1"}
+	changed, err = sut.IdempotentGateway("public", param)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	actual, _, err = sut.GetGateway("public")
+	assert.NoError(t, err)
+	assert.Equal(t, model.Version("def"), actual.Version)
+
+	// the nodes must be unique among the gateways and the hosts
+	_, err = sut.IdempotentGateway("private", model.GatewayParam{Nodes: []string{"gw1"}, Listeners: param.Listeners})
+	assert.Error(t, err)
+	_, err = sut.IdempotentGateway("private", model.GatewayParam{Nodes: []string{"front1"}, Listeners: param.Listeners})
+	assert.Error(t, err)
+	_, err = sut.RegisterHost("front", "gw1", "192.168.0.2:80", "127.0.0.1:8080", "127.0.0.1")
+	assert.Error(t, err)
+
+	// invalid route targets
+	invalids := []model.RouteRule{
+		{Prefix: "/", Service: "unknown"},
+		{Prefix: "/", Service: "db"},
+		{Prefix: "/", Service: "front", Subset: "v2"},
+	}
+	for _, rule := range invalids {
+		p := param
+		p.Listeners = []model.GatewayListener{{
+			Address: model.Address{Hostname: "0.0.0.0", Port: 80},
+			VirtualHosts: []model.GatewayVirtualHost{{
+				Name:    "front",
+				Domains: []string{"*"},
+				Routes:  []model.RouteRule{rule},
+			}},
+		}}
+		_, err = sut.IdempotentGateway("public", p)
+		assert.Error(t, err, rule)
+	}
+
+	// the service can not be deleted while the gateway routes to it
+	_, _, err = sut.UnregisterService("front")
+	assert.Error(t, err)
+
+	// delete
+	ok, err = sut.UnregisterGateway("public")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	names, err := sut.GetGatewayNames()
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+	ok, _, err = sut.UnregisterService("front")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
@@ -3,6 +3,7 @@ package core
 import (
 	"fmt"
 	"reflect"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rerorero/meshem/src/model"
@@ -17,16 +18,26 @@ type InventoryService interface {
 	UnregisterService(name string) (deleted bool, referer []string, err error)
 	GetService(name string) (model.Service, bool, error)
 	GetServiceNames() ([]string, error)
-	AddServiceDependency(serviceName string, dependServiceNames string, egressPort uint32) error
-	RemoveServiceDependency(serviceName string, dependServiceNames string) (bool, error)
+	GetServicesByLabels(selector model.LabelSelector) ([]model.Service, error)
+	AddServiceDependency(serviceName string, dependServiceRef string, egressPort uint32) error
+	RemoveServiceDependency(serviceName string, dependServiceRef string) (bool, error)
 	GetRefferersOf(serviceName string) ([]string, error)
 	RegisterHost(serviceName, hostName, ingressAddr, substanceAddr, egressHost string) (model.Host, error)
 	UnregisterHost(serviceName string, hostName string) (bool, error)
 	GetHostByName(name string) (model.Host, bool, error)
 	GetHostNames() ([]string, error)
 	GetHostsOfService(serviceName string) ([]model.Host, error)
+	GetHostsOfServiceByLabels(serviceName string, selector model.LabelSelector) ([]model.Host, error)
 	UpdateHost(serviceName string, hostName string, ingressAddr, substanceAddr, egressHost *string) (host model.Host, err error)
 	IdempotentService(serviceName string, param model.IdempotentServiceParam) (changed bool, err error)
+	SetDependencyFault(serviceName string, dependServiceRef string, fault *model.FaultPolicy) error
+	RemoveExpiredFaults(now time.Time) error
+	SetHostState(serviceName string, hostName string, state string) (model.Host, error)
+	RemoveDrainedHosts(now time.Time, gracePeriod time.Duration) error
+	IdempotentGateway(name string, param model.GatewayParam) (changed bool, err error)
+	GetGateway(name string) (model.Gateway, bool, error)
+	GetGatewayNames() ([]string, error)
+	UnregisterGateway(name string) (bool, error)
 }
 
 type inventoryService struct {
@@ -85,13 +96,21 @@ func (inv *inventoryService) RegisterService(name string, protocol string) (serv
 
 // UnregisterService removes the Service object and removes dependencies of all service.
 func (inv *inventoryService) UnregisterService(name string) (deleted bool, referrers []string, err error) {
+	gateways, err := inv.gatewaysRoutingTo(name)
+	if err != nil {
+		return false, nil, err
+	}
+	if len(gateways) > 0 {
+		return false, nil, fmt.Errorf("service=%s can not be deleted while gateways=%v route to it", name, gateways)
+	}
+
 	referrers, err = inv.repo.SelectReferringServiceNamesTo(name)
 	if err != nil {
 		return false, nil, err
 	}
 
 	for _, ref := range referrers {
-		_, err := inv.RemoveServiceDependency(ref, name)
+		err := inv.removeAllDependenciesTo(ref, name)
 		if err != nil {
 			return false, referrers, err
 		}
@@ -109,6 +128,24 @@ func (inv *inventoryService) UnregisterService(name string) (deleted bool, refer
 	return deleted, referrers, nil
 }
 
+// removeAllDependenciesTo removes the dependencies on all ports of the dependent service.
+func (inv *inventoryService) removeAllDependenciesTo(serviceName string, dependServiceName string) error {
+	service, ok, err := inv.GetService(serviceName)
+	if err != nil || !ok {
+		return err
+	}
+	for _, dep := range service.DependentServices {
+		if dep.Name != dependServiceName {
+			continue
+		}
+		_, err := inv.RemoveServiceDependency(serviceName, dep.Ref())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // GetServiceRelations returns names of service which refferes the service.
 func (inv *inventoryService) GetRefferersOf(serviceName string) ([]string, error) {
 	return inv.repo.SelectReferringServiceNamesTo(serviceName)
@@ -124,12 +161,54 @@ func (inv *inventoryService) GetServiceNames() ([]string, error) {
 	return inv.repo.SelectAllServiceNames()
 }
 
+// GetServicesByLabels returns the services whose labels match the selector.
+func (inv *inventoryService) GetServicesByLabels(selector model.LabelSelector) ([]model.Service, error) {
+	services, err := inv.repo.SelectAllServices()
+	if err != nil {
+		return nil, err
+	}
+	filtered := []model.Service{}
+	for _, svc := range services {
+		if selector.Matches(svc.Labels) {
+			filtered = append(filtered, svc)
+		}
+	}
+	return filtered, nil
+}
+
 // AddServiceDependency adds a new service dependency to the service.
-func (inv *inventoryService) AddServiceDependency(serviceName string, dependServiceName string, egressPort uint32) error {
+// The dependent service is referred with its port name like 'app:grpc' unless the default port is used.
+func (inv *inventoryService) AddServiceDependency(serviceName string, dependServiceRef string, egressPort uint32) error {
 	hosts, err := inv.GetHostsOfService(serviceName)
 	if err != nil {
 		return nil
 	}
+	service, ok, err := inv.GetService(serviceName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("No such service: %s", serviceName)
+	}
+	for _, p := range service.Ports {
+		if p.IngressPort == egressPort {
+			return fmt.Errorf("port=%d is already used by the ingress of port=%s", egressPort, p.Name)
+		}
+	}
+
+	dependServiceName, portName := model.ParseServiceRef(dependServiceRef)
+	if len(portName) > 0 {
+		// the dependent service which is not registered yet is checked when the snapshots are made.
+		depService, ok, err := inv.GetService(dependServiceName)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if ok, _ := depService.FindPort(portName); !ok {
+				return fmt.Errorf("port=%s is not defined in service=%s", portName, dependServiceName)
+			}
+		}
+	}
 
 	var i int
 	for i = 0; i < len(hosts); i++ {
@@ -141,36 +220,51 @@ func (inv *inventoryService) AddServiceDependency(serviceName string, dependServ
 		}
 	}
 
-	depend := model.DependentService{Name: dependServiceName, EgressPort: egressPort}
+	depend := model.DependentService{Name: dependServiceName, Port: portName, EgressPort: egressPort}
 	version := inv.versionGen.New()
 	err = inv.repo.AddServiceDependency(serviceName, depend, version)
 	if err != nil {
 		return err
 	}
 
-	inv.logger.Infof("Added service dependency! service=%s, dep=%s, port=%d, version=%s", serviceName, dependServiceName, egressPort, version)
+	inv.logger.Infof("Added service dependency! service=%s, dep=%s, port=%d, version=%s", serviceName, dependServiceRef, egressPort, version)
 	return nil
 }
 
 // RemoveServiceDependencies removes a service dependency from the service.
-func (inv *inventoryService) RemoveServiceDependency(serviceName string, dependServiceName string) (bool, error) {
+func (inv *inventoryService) RemoveServiceDependency(serviceName string, dependServiceRef string) (bool, error) {
 	version := inv.versionGen.New()
-	ok, err := inv.repo.RemoveServiceDependency(serviceName, dependServiceName, version)
+	ok, err := inv.repo.RemoveServiceDependency(serviceName, dependServiceRef, version)
 	if ok {
-		inv.logger.Infof("Removed service dependency! service=%s, dep=%s, version=%s", serviceName, dependServiceName, version)
+		inv.logger.Infof("Removed service dependency! service=%s, dep=%s, version=%s", serviceName, dependServiceRef, version)
 	}
 	return ok, err
 }
 
 // RegisterHost stores a new Host object.
-// TODO: with consistency
 func (inv *inventoryService) RegisterHost(serviceName, hostName, ingressAddr, substanceAddr, egressHost string) (host model.Host, err error) {
 	host, err = model.NewHost(hostName, ingressAddr, substanceAddr, egressHost)
 	if err != nil {
 		return host, err
 	}
+	service, ok, err := inv.GetService(serviceName)
+	if err != nil {
+		return host, err
+	}
+	if ok {
+		err = service.ValidateHostSubset(&host)
+		if err != nil {
+			return host, err
+		}
+	}
+	return inv.registerHost(serviceName, host)
+}
 
-	err = host.Validate()
+// registerHost stores a Host object and appends it to the host list of the service.
+// TODO: with consistency
+func (inv *inventoryService) registerHost(serviceName string, host model.Host) (model.Host, error) {
+	hostName := host.Name
+	err := host.Validate()
 	if err != nil {
 		return host, err
 	}
@@ -184,6 +278,13 @@ func (inv *inventoryService) RegisterHost(serviceName, hostName, ingressAddr, su
 	if ok {
 		return host, fmt.Errorf("host %s already exists", hostName)
 	}
+	gateway, ok, err := inv.gatewayNodeOf(hostName)
+	if err != nil {
+		return host, err
+	}
+	if ok {
+		return host, fmt.Errorf("host %s is already used as a node of gateway=%s", hostName, gateway)
+	}
 
 	// get the service
 	service, ok, err := inv.GetService(serviceName)
@@ -193,6 +294,16 @@ func (inv *inventoryService) RegisterHost(serviceName, hostName, ingressAddr, su
 	if !ok {
 		return host, fmt.Errorf("No such service: %s", serviceName)
 	}
+	if service.IsExternal() {
+		return host, fmt.Errorf("hosts can not be registered to the external service: %s", serviceName)
+	}
+	if model.NamespaceOf(hostName) != service.Namespace() {
+		return host, fmt.Errorf("host %s is not in the namespace of the service(%s)", hostName, serviceName)
+	}
+	err = service.ValidateHostPorts(&host)
+	if err != nil {
+		return host, err
+	}
 
 	// save the host
 	err = inv.repo.PutHost(host)
@@ -270,8 +381,39 @@ func (inv *inventoryService) GetHostsOfService(serviceName string) ([]model.Host
 	return inv.repo.SelectHostsOfService(serviceName)
 }
 
+// GetHostsOfServiceByLabels returns the hosts of the service whose labels match the selector.
+// The labels of the hosts inherit the labels of the service.
+func (inv *inventoryService) GetHostsOfServiceByLabels(serviceName string, selector model.LabelSelector) ([]model.Host, error) {
+	service, ok, err := inv.GetService(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("No such service: %s", serviceName)
+	}
+	hosts, err := inv.GetHostsOfService(serviceName)
+	if err != nil {
+		return nil, err
+	}
+	filtered := []model.Host{}
+	var i int
+	for i = 0; i < len(hosts); i++ {
+		if selector.Matches(service.HostLabels(&hosts[i])) {
+			filtered = append(filtered, hosts[i])
+		}
+	}
+	return filtered, nil
+}
+
 // UpdateHost updates a host.
 func (inv *inventoryService) UpdateHost(serviceName string, hostName string, ingressAddr, substanceAddr, egressHost *string) (host model.Host, err error) {
+	return inv.updateHost(serviceName, hostName, func(h *model.Host) error {
+		return h.Update(ingressAddr, substanceAddr, egressHost)
+	})
+}
+
+// updateHost applies the update function to a host and saves it.
+func (inv *inventoryService) updateHost(serviceName string, hostName string, update func(*model.Host) error) (host model.Host, err error) {
 	svc, ok, err := inv.GetService(serviceName)
 	if err != nil {
 		return host, err
@@ -289,7 +431,7 @@ func (inv *inventoryService) UpdateHost(serviceName string, hostName string, ing
 		return host, fmt.Errorf("hostname=%s is not found in service=%s", hostName, serviceName)
 	}
 
-	err = host.Update(ingressAddr, substanceAddr, egressHost)
+	err = update(&host)
 	if err != nil {
 		return host, err
 	}
@@ -298,6 +440,10 @@ func (inv *inventoryService) UpdateHost(serviceName string, hostName string, ing
 	if err != nil {
 		return host, err
 	}
+	err = svc.ValidateHostPorts(&host)
+	if err != nil {
+		return host, err
+	}
 
 	// save the host
 	version := inv.versionGen.New()
@@ -305,7 +451,7 @@ func (inv *inventoryService) UpdateHost(serviceName string, hostName string, ing
 	if err != nil {
 		return host, err
 	}
-	inv.logger.Infof("Updated a host! host=%s, service=%s, ia=%v, sa=%v, eh=%v", hostName, serviceName, ingressAddr, substanceAddr, egressHost)
+	inv.logger.Infof("Updated a host! host=%s, service=%s, updated=%+v", hostName, serviceName, host)
 
 	// update the service version
 	err = inv.repo.PutService(svc, version)
@@ -314,6 +460,12 @@ func (inv *inventoryService) UpdateHost(serviceName string, hostName string, ing
 	}
 	inv.logger.Infof("Updated a service! host=%s, service=%s, version=%s", hostName, serviceName, version)
 
+	// the labels of the host may be changed
+	err = inv.registerDiscoverService(&svc, &host)
+	if err != nil {
+		return host, err
+	}
+
 	return host, nil
 }
 
@@ -322,18 +474,47 @@ func (inv *inventoryService) IdempotentService(serviceName string, param model.I
 	var i int
 	// validate
 	service := param.NewService(serviceName)
+	// the empty labels are not stored
+	if len(service.Labels) == 0 {
+		service.Labels = nil
+	}
 	err = service.Validate()
 	if err != nil {
 		return changed, err
 	}
+	err = inv.validateRouteTargets(&service)
+	if err != nil {
+		return changed, err
+	}
+	err = inv.validateDependencyPolicies(&service)
+	if err != nil {
+		return changed, err
+	}
+	err = inv.validatePortReferrers(&service)
+	if err != nil {
+		return changed, err
+	}
 	paramHostsMap := map[string]*model.Host{}
 	for i = 0; i < len(param.Hosts); i++ {
 		err = param.Hosts[i].Validate()
 		if err != nil {
 			return changed, err
 		}
-		paramHostsMap[param.Hosts[i].Name] = &param.Hosts[i]
+		err = service.ValidateHostPorts(&param.Hosts[i])
+		if err != nil {
+			return changed, err
+		}
+		err = service.ValidateHostSubset(&param.Hosts[i])
+		if err != nil {
+			return changed, err
+		}
+		host := param.Hosts[i]
+		if len(host.Labels) == 0 {
+			host.Labels = nil
+		}
+		paramHostsMap[host.Name] = &host
 	}
+	now := time.Now()
 
 	// get the current service state
 	currentService, ok, err := inv.GetService(serviceName)
@@ -343,6 +524,7 @@ func (inv *inventoryService) IdempotentService(serviceName string, param model.I
 
 	if ok {
 		// update
+		keepActiveFaults(&service, &currentService, now)
 		// get current host states
 		hosts, err := inv.GetHostsOfService(serviceName)
 		if err != nil {
@@ -365,7 +547,11 @@ func (inv *inventoryService) IdempotentService(serviceName string, param model.I
 			if !ok {
 				return changed, fmt.Errorf("something wrong, consistency may be broken: %+v, %+v", paramHostsMap, hosts)
 			}
-			_, err = inv.RegisterHost(service.Name, host.Name, host.IngressAddr.String(), host.SubstanceAddr.String(), host.EgressHost)
+			err = host.SetState(host.State, now)
+			if err != nil {
+				return changed, err
+			}
+			_, err = inv.registerHost(service.Name, *host)
 			if err != nil {
 				return changed, err
 			}
@@ -386,10 +572,22 @@ func (inv *inventoryService) IdempotentService(serviceName string, param model.I
 			if !ok1 || !ok2 {
 				return changed, fmt.Errorf("something wrong, consistency may be broken: %+v : %+v", paramHostsMap, hosts)
 			}
+			// the state changed through SetHostState is kept unless the param specifies it
+			state := new.State
+			if len(state) == 0 {
+				state = cur.State
+			}
+			new.State = cur.State
+			new.DrainingSince = cur.DrainingSince
+			err = new.SetState(state, now)
+			if err != nil {
+				return changed, err
+			}
 			if !reflect.DeepEqual(cur, new) {
-				ingress := new.IngressAddr.String()
-				substance := new.SubstanceAddr.String()
-				_, err = inv.UpdateHost(serviceName, new.Name, &ingress, &substance, &new.EgressHost)
+				_, err = inv.updateHost(serviceName, new.Name, func(h *model.Host) error {
+					*h = *new
+					return nil
+				})
 				if err != nil {
 					return changed, err
 				}
@@ -397,9 +595,21 @@ func (inv *inventoryService) IdempotentService(serviceName string, param model.I
 			}
 		}
 
-		// compare service dependencies and protocol
+		// compare service dependencies, protocol, subsets, routes and policies
 		if (service.Protocol != currentService.Protocol) ||
-			(!model.EqualsServiceDependencies(currentService.DependentServices, service.DependentServices)) {
+			(!model.EqualsServicePorts(currentService.Ports, service.Ports)) ||
+			(!model.EqualsServiceDependencies(currentService.DependentServices, service.DependentServices)) ||
+			(!model.EqualsSubsets(currentService.Subsets, service.Subsets)) ||
+			(!model.EqualsRouteRules(currentService.Routes, service.Routes)) ||
+			(!reflect.DeepEqual(currentService.Timeouts, service.Timeouts)) ||
+			(!reflect.DeepEqual(currentService.Resilience, service.Resilience)) ||
+			(!reflect.DeepEqual(currentService.HealthCheck, service.HealthCheck)) ||
+			(!reflect.DeepEqual(currentService.HealthCheckFilter, service.HealthCheckFilter)) ||
+			(!reflect.DeepEqual(currentService.RateLimit, service.RateLimit)) ||
+			(!reflect.DeepEqual(currentService.LoadBalancer, service.LoadBalancer)) ||
+			(!reflect.DeepEqual(currentService.External, service.External)) ||
+			(!reflect.DeepEqual(currentService.Labels, service.Labels)) ||
+			(!reflect.DeepEqual(currentService.Headers, service.Headers)) {
 			service.Version = inv.versionGen.New()
 			err := inv.repo.PutService(service, service.Version)
 			if err != nil {
@@ -408,6 +618,14 @@ func (inv *inventoryService) IdempotentService(serviceName string, param model.I
 			changed = true
 		}
 
+		// the hosts inherit the labels of the service
+		if !reflect.DeepEqual(currentService.Labels, service.Labels) {
+			err = inv.registerDiscoverServiceHosts(&service)
+			if err != nil {
+				return changed, err
+			}
+		}
+
 	} else {
 		// all new ones
 		_, err = inv.RegisterService(service.Name, service.Protocol)
@@ -416,14 +634,24 @@ func (inv *inventoryService) IdempotentService(serviceName string, param model.I
 		}
 		changed = true
 		for _, depsvc := range param.DependentServices {
-			err = inv.AddServiceDependency(service.Name, depsvc.Name, depsvc.EgressPort)
+			err = inv.AddServiceDependency(service.Name, depsvc.Ref(), depsvc.EgressPort)
 			if err != nil {
 				return changed, err
 			}
 		}
+		// store the rest of the service attributes such as subsets and routes,
+		// the hosts are registered after that to inherit the labels of the service
+		err = inv.putServiceAttributes(service)
+		if err != nil {
+			return changed, err
+		}
 		for i = 0; i < len(param.Hosts); i++ {
-			host := &param.Hosts[i]
-			_, err = inv.RegisterHost(service.Name, host.Name, host.IngressAddr.String(), host.SubstanceAddr.String(), host.EgressHost)
+			host := paramHostsMap[param.Hosts[i].Name]
+			err = host.SetState(host.State, now)
+			if err != nil {
+				return changed, err
+			}
+			_, err = inv.registerHost(service.Name, *host)
 			if err != nil {
 				return changed, err
 			}
@@ -435,6 +663,239 @@ func (inv *inventoryService) IdempotentService(serviceName string, param model.I
 	return changed, nil
 }
 
+// keepActiveFaults copies the faults set through SetDependencyFault which are not expired yet
+// to the dependencies of the service unless the service specifies their faults.
+func keepActiveFaults(service *model.Service, current *model.Service, now time.Time) {
+	if len(service.DependentServices) == 0 {
+		return
+	}
+	// copy not to modify the dependencies of the param
+	dependencies := make([]model.DependentService, len(service.DependentServices))
+	copy(dependencies, service.DependentServices)
+	service.DependentServices = dependencies
+	var i int
+	for i = 0; i < len(service.DependentServices); i++ {
+		dep := &service.DependentServices[i]
+		if dep.Fault != nil {
+			continue
+		}
+		if ok, cur := current.FindDependentServiceRef(dep.Ref()); ok && cur.Fault.IsActive(now) {
+			dep.Fault = cur.Fault
+		}
+	}
+}
+
+// validateRouteTargets checks that the other services and subsets which the routes refer to exist.
+func (inv *inventoryService) validateRouteTargets(service *model.Service) error {
+	var i int
+	for i = 0; i < len(service.Routes); i++ {
+		rule := &service.Routes[i]
+		target := rule.TargetService(service.Name)
+		if rule.Redirect != nil || target == service.Name {
+			continue
+		}
+		targetService, ok, err := inv.GetService(target)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("route target service=%s of %s is not found", target, service.Name)
+		}
+		if targetService.Protocol != service.Protocol {
+			return fmt.Errorf("route target service=%s provides %s protocol but %s provides %s", target, targetService.Protocol, service.Name, service.Protocol)
+		}
+		if len(rule.Subset) > 0 {
+			if ok, _ := targetService.FindSubset(rule.Subset); !ok {
+				return fmt.Errorf("route target subset=%s is not defined in service=%s", rule.Subset, target)
+			}
+		}
+	}
+	return nil
+}
+
+// validateDependencyPolicies checks that the named ports of the dependencies exist and the policies of the dependencies
+// are supported by the protocol of the dependent services. The dependent services which are not registered yet are skipped.
+func (inv *inventoryService) validateDependencyPolicies(service *model.Service) error {
+	var i int
+	for i = 0; i < len(service.DependentServices); i++ {
+		dep := &service.DependentServices[i]
+		httpOnly := dep.Retry != nil || dep.Fault != nil || dep.Headers != nil || (dep.Timeouts != nil && dep.Timeouts.RequestTimeoutMS > 0)
+		if !httpOnly && len(dep.Port) == 0 {
+			continue
+		}
+		depService, ok, err := inv.GetService(dep.Name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		protocol := depService.Protocol
+		if len(dep.Port) > 0 {
+			ok, port := depService.FindPort(dep.Port)
+			if !ok {
+				return fmt.Errorf("port=%s is not defined in service=%s", dep.Port, dep.Name)
+			}
+			protocol = port.Protocol
+		}
+		if !httpOnly {
+			continue
+		}
+		if !model.IsHTTPBasedProtocol(protocol) {
+			if dep.Retry != nil {
+				return fmt.Errorf("retry policy can not be applied to %s service=%s", protocol, dep.Ref())
+			}
+			if dep.Fault != nil {
+				return fmt.Errorf("fault policy can not be applied to %s service=%s", protocol, dep.Ref())
+			}
+			if dep.Headers != nil {
+				return fmt.Errorf("headers can not be applied to %s service=%s", protocol, dep.Ref())
+			}
+			return fmt.Errorf("request timeout can not be applied to %s service=%s", protocol, dep.Ref())
+		}
+		if dep.Retry != nil && dep.Retry.HasGRPCConditions() && protocol != model.ProtocolGRPC {
+			return fmt.Errorf("gRPC retry conditions can not be applied to %s service=%s", protocol, dep.Ref())
+		}
+	}
+	return nil
+}
+
+// validatePortReferrers checks that the named ports which the other services depend on are not removed.
+func (inv *inventoryService) validatePortReferrers(service *model.Service) error {
+	referrers, err := inv.repo.SelectReferringServiceNamesTo(service.Name)
+	if err != nil {
+		return err
+	}
+	for _, name := range referrers {
+		referrer, ok, err := inv.GetService(name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		for _, dep := range referrer.DependentServices {
+			if dep.Name != service.Name || len(dep.Port) == 0 {
+				continue
+			}
+			if ok, _ := service.FindPort(dep.Port); !ok {
+				return fmt.Errorf("port=%s of service=%s can not be removed while service=%s depends on it", dep.Port, service.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// SetDependencyFault sets the fault policy to the dependency of the service. The fault is removed if it's nil.
+func (inv *inventoryService) SetDependencyFault(serviceName string, dependServiceRef string, fault *model.FaultPolicy) error {
+	service, ok, err := inv.GetService(serviceName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("No such service: %s", serviceName)
+	}
+	if ok, _ := service.FindDependentServiceRef(dependServiceRef); !ok {
+		return fmt.Errorf("service=%s doesn't depend on %s", serviceName, dependServiceRef)
+	}
+
+	// copy not to modify the dependencies held by the repository
+	dependencies := make([]model.DependentService, len(service.DependentServices))
+	copy(dependencies, service.DependentServices)
+	service.DependentServices = dependencies
+	_, dep := service.FindDependentServiceRef(dependServiceRef)
+	dep.Fault = fault
+
+	err = service.Validate()
+	if err != nil {
+		return err
+	}
+	err = inv.validateDependencyPolicies(&service)
+	if err != nil {
+		return err
+	}
+
+	version := inv.versionGen.New()
+	err = inv.repo.PutService(service, version)
+	if err != nil {
+		return err
+	}
+	inv.logger.Infof("Set a fault policy! service=%s, dep=%s, fault=%+v, version=%s", serviceName, dependServiceRef, fault, version)
+	return nil
+}
+
+// RemoveExpiredFaults removes the fault policies which have expired from all services.
+func (inv *inventoryService) RemoveExpiredFaults(now time.Time) error {
+	names, err := inv.GetServiceNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		service, ok, err := inv.GetService(name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		for _, dep := range service.DependentServices {
+			if dep.Fault == nil || dep.Fault.IsActive(now) {
+				continue
+			}
+			err = inv.SetDependencyFault(name, dep.Ref(), nil)
+			if err != nil {
+				return errors.Wrapf(err, "failed to remove the expired fault: service=%s, dep=%s", name, dep.Ref())
+			}
+		}
+	}
+	return nil
+}
+
+// SetHostState changes the state of the host.
+func (inv *inventoryService) SetHostState(serviceName string, hostName string, state string) (model.Host, error) {
+	return inv.updateHost(serviceName, hostName, func(h *model.Host) error {
+		return h.SetState(state, time.Now())
+	})
+}
+
+// RemoveDrainedHosts unregisters the hosts which have been draining longer than the grace period.
+func (inv *inventoryService) RemoveDrainedHosts(now time.Time, gracePeriod time.Duration) error {
+	names, err := inv.GetServiceNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		hosts, err := inv.GetHostsOfService(name)
+		if err != nil {
+			return err
+		}
+		for _, host := range hosts {
+			if !host.IsDrained(now, gracePeriod) {
+				continue
+			}
+			_, err = inv.UnregisterHost(name, host.Name)
+			if err != nil {
+				return errors.Wrapf(err, "failed to remove the drained host: service=%s, host=%s", name, host.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// putServiceAttributes overwrites the attributes of the stored service except for its hosts.
+func (inv *inventoryService) putServiceAttributes(service model.Service) error {
+	current, ok, err := inv.GetService(service.Name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("No such service: %s", service.Name)
+	}
+	service.HostNames = current.HostNames
+	service.Version = inv.versionGen.New()
+	return inv.repo.PutService(service, service.Version)
+}
+
 func (inv *inventoryService) registerDiscoverService(svc *model.Service, host *model.Host) error {
 	if inv.discovery != nil {
 		tags := inv.makeDiscoveryTags(svc, host)
@@ -447,9 +908,30 @@ func (inv *inventoryService) registerDiscoverService(svc *model.Service, host *m
 	return nil
 }
 
+// registerDiscoverServiceHosts registers all hosts of the service to discovery service again to update their tags.
+func (inv *inventoryService) registerDiscoverServiceHosts(svc *model.Service) error {
+	if inv.discovery == nil {
+		return nil
+	}
+	hosts, err := inv.GetHostsOfService(svc.Name)
+	if err != nil {
+		return err
+	}
+	var i int
+	for i = 0; i < len(hosts); i++ {
+		err = inv.registerDiscoverService(svc, &hosts[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// makeDiscoveryTags makes the tags from the labels of the host and its service.
 func (inv *inventoryService) makeDiscoveryTags(service *model.Service, host *model.Host) map[string]string {
-	tags := map[string]string{}
+	tags := service.HostLabels(host)
 	tags["meshem_service"] = service.Name
+	tags["meshem_namespace"] = service.Namespace()
 	return tags
 }
 
@@ -2,6 +2,7 @@ package core
 
 import (
 	"testing"
+	"time"
 
 	"github.com/rerorero/meshem/src/model"
 	"github.com/rerorero/meshem/src/repository"
@@ -86,6 +87,7 @@ func TestRegisterHost(t *testing.T) {
 	expect, err := model.NewHost("host1", "192.168.0.1:8081", "127.0.0.1:8080", "127.0.0.1")
 	expectTags := map[string]string{}
 	expectTags["meshem_service"] = "svc1"
+	expectTags["meshem_namespace"] = model.DefaultNamespace
 	assert.NoError(t, err)
 	discovery.On("Register", expect, expectTags).Return(nil)
 	host1, err := sut.RegisterHost(svc.Name, "host1", "192.168.0.1:8081", "127.0.0.1:8080", "127.0.0.1")
@@ -364,3 +366,859 @@ func TestIdemopotentService(t *testing.T) {
 	actualHosts, err = sut.GetHostsOfService("svcB")
 	assert.ElementsMatch(t, svcBMod.Hosts, actualHosts)
 }
+
+func TestIdemopotentServiceHostWeight(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := &MockedVersionGen{Version: "abc"}
+	sut := NewInventoryService(repo, nil, gen, logrus.New())
+
+	svc := model.IdempotentServiceParam{
+		Protocol: "HTTP",
+		Hosts: []model.Host{{
+			Name:          "a-1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 8000},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8001},
+			EgressHost:    "127.0.0.1",
+		}},
+	}
+	changed, err := sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	// change the weight
+	hosts := make([]model.Host, len(svc.Hosts))
+	copy(hosts, svc.Hosts)
+	hosts[0].Weight = 5
+	svc.Hosts = hosts
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	actual, ok, err := sut.GetHostByName("a-1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(5), actual.Weight)
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// invalid weight
+	hosts[0].Weight = model.MaxHostWeight + 1
+	_, err = sut.IdempotentService("svcA", svc)
+	assert.Error(t, err)
+}
+
+func TestIdemopotentServiceSubsets(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := &MockedVersionGen{Version: "abc"}
+	sut := NewInventoryService(repo, nil, gen, logrus.New())
+
+	svc := model.IdempotentServiceParam{
+		Protocol: "HTTP",
+		Hosts: []model.Host{
+			{
+				Name:          "a-1",
+				IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 8000},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8001},
+				EgressHost:    "127.0.0.1",
+				Subset:        "v1",
+			},
+			{
+				Name:          "a-2",
+				IngressAddr:   model.Address{Hostname: "192.168.0.2", Port: 8000},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8001},
+				EgressHost:    "127.0.0.1",
+				Subset:        "v2",
+			},
+		},
+		Subsets: []model.SubsetWeight{
+			{Name: "v1", Weight: 100},
+			{Name: "v2", Weight: 0},
+		},
+	}
+
+	changed, err := sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	actualsvc, ok, err := sut.GetService("svcA")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, svc.Subsets, actualsvc.Subsets)
+	actualHosts, err := sut.GetHostsOfService("svcA")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, svc.Hosts, actualHosts)
+
+	// not changed when applied twice
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// shift the weight
+	svc.Subsets = []model.SubsetWeight{
+		{Name: "v1", Weight: 90},
+		{Name: "v2", Weight: 10},
+	}
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	actualsvc, _, err = sut.GetService("svcA")
+	assert.NoError(t, err)
+	assert.Equal(t, svc.Subsets, actualsvc.Subsets)
+
+	// move a host to the other subset
+	svc.Hosts[0].Subset = "v2"
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	actualHosts, err = sut.GetHostsOfService("svcA")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, svc.Hosts, actualHosts)
+
+	// undefined subset
+	svc.Hosts[0].Subset = "v3"
+	_, err = sut.IdempotentService("svcA", svc)
+	assert.Error(t, err)
+
+	// a host without the subset never receives the requests
+	svc.Hosts[0].Subset = ""
+	_, err = sut.IdempotentService("svcA", svc)
+	assert.Error(t, err)
+	_, err = sut.RegisterHost("svcA", "a-3", "192.168.0.3:8000", "127.0.0.1:8001", "127.0.0.1")
+	assert.Error(t, err)
+	actualHosts, err = sut.GetHostsOfService("svcA")
+	assert.NoError(t, err)
+	assert.Len(t, actualHosts, 2)
+}
+
+func TestIdemopotentServiceRoutes(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := &MockedVersionGen{Version: "abc"}
+	sut := NewInventoryService(repo, nil, gen, logrus.New())
+
+	_, err := sut.IdempotentService("other", model.IdempotentServiceParam{
+		Protocol: "HTTP",
+		Subsets:  []model.SubsetWeight{{Name: "v1", Weight: 100}},
+	})
+	assert.NoError(t, err)
+
+	svc := model.IdempotentServiceParam{
+		Protocol: "HTTP",
+		Routes: []model.RouteRule{
+			{Prefix: "/other", Service: "other", Subset: "v1"},
+			{Prefix: "/"},
+		},
+	}
+	changed, err := sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	actualsvc, _, err := sut.GetService("svcA")
+	assert.NoError(t, err)
+	assert.Equal(t, svc.Routes, actualsvc.Routes)
+
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// the order of routes is significant
+	svc.Routes = []model.RouteRule{svc.Routes[1], svc.Routes[0]}
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	// unknown target service
+	svc.Routes = []model.RouteRule{{Prefix: "/", Service: "unknown"}}
+	_, err = sut.IdempotentService("svcA", svc)
+	assert.Error(t, err)
+
+	// unknown target subset
+	svc.Routes = []model.RouteRule{{Prefix: "/", Service: "other", Subset: "v2"}}
+	_, err = sut.IdempotentService("svcA", svc)
+	assert.Error(t, err)
+
+	// target service of another protocol
+	_, err = sut.IdempotentService("grpc", model.IdempotentServiceParam{Protocol: "GRPC"})
+	assert.NoError(t, err)
+	svc.Routes = []model.RouteRule{{Prefix: "/", Service: "grpc"}}
+	_, err = sut.IdempotentService("svcA", svc)
+	assert.Error(t, err)
+}
+
+func TestIdemopotentServiceRetry(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := &MockedVersionGen{Version: "abc"}
+	sut := NewInventoryService(repo, nil, gen, logrus.New())
+
+	_, err := sut.IdempotentService("http", model.IdempotentServiceParam{Protocol: "HTTP"})
+	assert.NoError(t, err)
+	_, err = sut.IdempotentService("tcp", model.IdempotentServiceParam{Protocol: "TCP"})
+	assert.NoError(t, err)
+
+	retry := &model.RetryPolicy{RetryOn: []string{model.RetryOn5xx}, NumRetries: 2}
+	svc := model.IdempotentServiceParam{
+		Protocol: "HTTP",
+		DependentServices: []model.DependentService{
+			{Name: "http", EgressPort: 9001, Retry: retry},
+		},
+	}
+	changed, err := sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	actualsvc, _, err := sut.GetService("svcA")
+	assert.NoError(t, err)
+	assert.Equal(t, svc.DependentServices, actualsvc.DependentServices)
+
+	// change the number of retries
+	svc.DependentServices = []model.DependentService{
+		{Name: "http", EgressPort: 9001, Retry: &model.RetryPolicy{RetryOn: []string{model.RetryOn5xx}, NumRetries: 3}},
+	}
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// TCP service can not be retried
+	svc.DependentServices = append(svc.DependentServices, model.DependentService{Name: "tcp", EgressPort: 9002, Retry: retry})
+	_, err = sut.IdempotentService("svcA", svc)
+	assert.Error(t, err)
+
+	// gRPC conditions are only for gRPC service
+	_, err = sut.IdempotentService("grpc", model.IdempotentServiceParam{Protocol: "GRPC"})
+	assert.NoError(t, err)
+	grpcRetry := &model.RetryPolicy{RetryOn: []string{model.RetryOnUnavailable}}
+	svc.DependentServices = []model.DependentService{
+		{Name: "grpc", EgressPort: 9003, Retry: grpcRetry},
+	}
+	_, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	svc.DependentServices = []model.DependentService{
+		{Name: "http", EgressPort: 9001, Retry: grpcRetry},
+	}
+	_, err = sut.IdempotentService("svcA", svc)
+	assert.Error(t, err)
+}
+
+func TestIdemopotentServicePolicies(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := &MockedVersionGen{Version: "abc"}
+	sut := NewInventoryService(repo, nil, gen, logrus.New())
+
+	_, err := sut.IdempotentService("tcp", model.IdempotentServiceParam{Protocol: "TCP"})
+	assert.NoError(t, err)
+
+	svc := model.IdempotentServiceParam{
+		Protocol: "HTTP",
+		DependentServices: []model.DependentService{
+			{Name: "tcp", EgressPort: 9001, Timeouts: &model.Timeouts{IdleTimeoutMS: 1000}},
+		},
+		Timeouts: &model.Timeouts{RequestTimeoutMS: 60000},
+	}
+	changed, err := sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	actualsvc, _, err := sut.GetService("svcA")
+	assert.NoError(t, err)
+	assert.Equal(t, svc.Timeouts, actualsvc.Timeouts)
+	assert.Equal(t, svc.DependentServices, actualsvc.DependentServices)
+
+	// change the service timeouts
+	svc.Timeouts = &model.Timeouts{RequestTimeoutMS: 200}
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	// change the resilience policy
+	svc.Resilience = &model.ResiliencePolicy{CircuitBreaker: &model.CircuitBreaker{MaxConnections: 10}}
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	actualsvc, _, err = sut.GetService("svcA")
+	assert.NoError(t, err)
+	assert.Equal(t, svc.Resilience, actualsvc.Resilience)
+
+	// change the health check
+	svc.HealthCheck = &model.ActiveHealthCheck{Path: "/health", IntervalMS: 5000, TimeoutMS: 1000, HealthyThreshold: 1, UnhealthyThreshold: 2}
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	actualsvc, _, err = sut.GetService("svcA")
+	assert.NoError(t, err)
+	assert.Equal(t, svc.HealthCheck, actualsvc.HealthCheck)
+
+	// change the health check filter
+	svc.HealthCheckFilter = &model.HealthCheckFilter{Mode: model.HealthCheckFilterEndpoint, Endpoint: "/health"}
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	actualsvc, _, err = sut.GetService("svcA")
+	assert.NoError(t, err)
+	assert.Equal(t, svc.HealthCheckFilter, actualsvc.HealthCheckFilter)
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// change the rate limit
+	svc.RateLimit = &model.RateLimit{RequestsPerSecond: 100, Burst: 200}
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	actualsvc, _, err = sut.GetService("svcA")
+	assert.NoError(t, err)
+	assert.Equal(t, svc.RateLimit, actualsvc.RateLimit)
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// change the load balancer
+	svc.LoadBalancer = &model.LoadBalancer{Policy: model.LBRingHash, HashKey: &model.HashKey{Header: "x-user-id"}}
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	actualsvc, _, err = sut.GetService("svcA")
+	assert.NoError(t, err)
+	assert.Equal(t, svc.LoadBalancer, actualsvc.LoadBalancer)
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// TCP service has no request timeout
+	svc.DependentServices = []model.DependentService{
+		{Name: "tcp", EgressPort: 9001, Timeouts: &model.Timeouts{RequestTimeoutMS: 1000}},
+	}
+	_, err = sut.IdempotentService("svcA", svc)
+	assert.Error(t, err)
+}
+
+func TestSetDependencyFault(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := &MockedVersionGen{Version: "abc"}
+	sut := NewInventoryService(repo, nil, gen, logrus.New())
+
+	_, err := sut.IdempotentService("app", model.IdempotentServiceParam{Protocol: "HTTP"})
+	assert.NoError(t, err)
+	_, err = sut.IdempotentService("tcp", model.IdempotentServiceParam{Protocol: "TCP"})
+	assert.NoError(t, err)
+	front := model.IdempotentServiceParam{
+		Protocol: "HTTP",
+		DependentServices: []model.DependentService{
+			{Name: "app", EgressPort: 9001},
+			{Name: "tcp", EgressPort: 9002},
+		},
+	}
+	_, err = sut.IdempotentService("front", front)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+	fault := &model.FaultPolicy{
+		Abort:     &model.FaultAbort{Percentage: 10, HTTPStatus: 503},
+		ExpiresAt: &expiresAt,
+	}
+	gen.Version = "def"
+	err = sut.SetDependencyFault("front", "app", fault)
+	assert.NoError(t, err)
+	actual, _, err := sut.GetService("front")
+	assert.NoError(t, err)
+	_, dep := actual.FindDependentServiceName("app")
+	assert.Equal(t, fault, dep.Fault)
+	assert.Equal(t, model.Version("def"), actual.Version)
+
+	// the fault is kept when the same param is applied
+	gen.Version = "xyz"
+	changed, err := sut.IdempotentService("front", front)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	actual, _, err = sut.GetService("front")
+	assert.NoError(t, err)
+	_, dep = actual.FindDependentServiceName("app")
+	assert.Equal(t, fault, dep.Fault)
+	assert.Equal(t, model.Version("def"), actual.Version)
+	assert.Nil(t, front.DependentServices[0].Fault)
+
+	// invalid
+	assert.Error(t, sut.SetDependencyFault("front", "tcp", fault))
+	assert.Error(t, sut.SetDependencyFault("front", "unknown", fault))
+	assert.Error(t, sut.SetDependencyFault("unknown", "app", fault))
+	assert.Error(t, sut.SetDependencyFault("front", "app", &model.FaultPolicy{}))
+
+	// not expired yet
+	gen.Version = "ghi"
+	assert.NoError(t, sut.RemoveExpiredFaults(now))
+	actual, _, err = sut.GetService("front")
+	assert.NoError(t, err)
+	_, dep = actual.FindDependentServiceName("app")
+	assert.Equal(t, fault, dep.Fault)
+	assert.Equal(t, model.Version("def"), actual.Version)
+
+	// expired
+	assert.NoError(t, sut.RemoveExpiredFaults(expiresAt))
+	actual, _, err = sut.GetService("front")
+	assert.NoError(t, err)
+	_, dep = actual.FindDependentServiceName("app")
+	assert.Nil(t, dep.Fault)
+	assert.Equal(t, model.Version("ghi"), actual.Version)
+	changed, err = sut.IdempotentService("front", front)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestHostStates(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := &MockedVersionGen{Version: "abc"}
+	sut := NewInventoryService(repo, nil, gen, logrus.New())
+
+	svc := model.IdempotentServiceParam{
+		Protocol: "HTTP",
+		Hosts: []model.Host{
+			{
+				Name:          "a-1",
+				IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 8000},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8001},
+				EgressHost:    "127.0.0.1",
+			},
+			{
+				Name:          "a-2",
+				IngressAddr:   model.Address{Hostname: "192.168.0.2", Port: 8000},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8001},
+				EgressHost:    "127.0.0.1",
+			},
+		},
+	}
+	_, err := sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+
+	// drain
+	gen.Version = "def"
+	before := time.Now()
+	host, err := sut.SetHostState("svcA", "a-1", model.HostStateDraining)
+	assert.NoError(t, err)
+	assert.True(t, host.IsDraining())
+	assert.False(t, host.DrainingSince.Before(before))
+	actual, _, err := sut.GetService("svcA")
+	assert.NoError(t, err)
+	assert.Equal(t, model.Version("def"), actual.Version)
+
+	// the state is kept unless the param specifies it
+	changed, err := sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	host, _, err = sut.GetHostByName("a-1")
+	assert.NoError(t, err)
+	assert.True(t, host.IsDraining())
+
+	// maintenance
+	_, err = sut.SetHostState("svcA", "a-2", model.HostStateMaintenance)
+	assert.NoError(t, err)
+	_, err = sut.SetHostState("svcA", "a-2", "unknown")
+	assert.Error(t, err)
+	_, err = sut.SetHostState("svcA", "unknown", model.HostStateMaintenance)
+	assert.Error(t, err)
+
+	// not drained yet
+	assert.NoError(t, sut.RemoveDrainedHosts(time.Now(), time.Hour))
+	actual, _, err = sut.GetService("svcA")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a-1", "a-2"}, actual.HostNames)
+
+	// drained
+	assert.NoError(t, sut.RemoveDrainedHosts(time.Now().Add(time.Hour), time.Hour))
+	actual, _, err = sut.GetService("svcA")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a-2"}, actual.HostNames)
+	_, ok, err := sut.GetHostByName("a-1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// activate by the param
+	hosts := []model.Host{svc.Hosts[1]}
+	hosts[0].State = model.HostStateActive
+	svc.Hosts = hosts
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	host, _, err = sut.GetHostByName("a-2")
+	assert.NoError(t, err)
+	assert.Equal(t, "", host.State)
+}
+
+func TestIdemopotentServiceNewHostStates(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := &MockedVersionGen{Version: "abc"}
+	sut := NewInventoryService(repo, nil, gen, logrus.New())
+
+	svc := model.IdempotentServiceParam{
+		Protocol: "HTTP",
+		Hosts: []model.Host{
+			{
+				Name:          "a-1",
+				IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 8000},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8001},
+				EgressHost:    "127.0.0.1",
+				State:         model.HostStateDraining,
+			},
+			{
+				Name:          "a-2",
+				IngressAddr:   model.Address{Hostname: "192.168.0.2", Port: 8000},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8001},
+				EgressHost:    "127.0.0.1",
+				State:         model.HostStateActive,
+			},
+		},
+	}
+	before := time.Now()
+	changed, err := sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	host, _, err := sut.GetHostByName("a-1")
+	assert.NoError(t, err)
+	assert.True(t, host.IsDraining())
+	assert.False(t, host.DrainingSince.Before(before))
+	host, _, err = sut.GetHostByName("a-2")
+	assert.NoError(t, err)
+	assert.Equal(t, "", host.State)
+
+	// the same param
+	changed, err = sut.IdempotentService("svcA", svc)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// the host created as draining is removed
+	assert.NoError(t, sut.RemoveDrainedHosts(time.Now().Add(time.Hour), time.Hour))
+	actual, _, err := sut.GetService("svcA")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a-2"}, actual.HostNames)
+	_, ok, err := sut.GetHostByName("a-1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestIdemopotentServiceExternal(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := &MockedVersionGen{Version: "abc"}
+	sut := NewInventoryService(repo, nil, gen, logrus.New())
+
+	payment := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		External: &model.ExternalService{Hostname: "api.payment.example.com", Port: 443},
+	}
+	changed, err := sut.IdempotentService("payment", payment)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	front := model.IdempotentServiceParam{
+		Protocol:          model.ProtocolHTTP,
+		DependentServices: []model.DependentService{{Name: "payment", EgressPort: 9001}},
+	}
+	_, err = sut.IdempotentService("front", front)
+	assert.NoError(t, err)
+
+	// change the external service
+	gen.Version = "def"
+	payment.External = &model.ExternalService{Hostname: "api.payment.example.com", Port: 443, TLS: &model.ExternalTLS{CAFile: "/etc/ssl/certs/ca-certificates.crt"}}
+	changed, err = sut.IdempotentService("payment", payment)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	actual, _, err := sut.GetService("payment")
+	assert.NoError(t, err)
+	assert.Equal(t, payment.External, actual.External)
+	assert.Equal(t, model.Version("def"), actual.Version)
+	changed, err = sut.IdempotentService("payment", payment)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// hosts can not be registered
+	_, err = sut.RegisterHost("payment", "payment1", "192.168.0.1:80", "127.0.0.1:8080", "127.0.0.1")
+	assert.Error(t, err)
+	payment.Hosts = []model.Host{{
+		Name:          "payment1",
+		IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+		SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+	}}
+	_, err = sut.IdempotentService("payment", payment)
+	assert.Error(t, err)
+}
+
+func TestIdemopotentServicePorts(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := &MockedVersionGen{Version: "abc"}
+	sut := NewInventoryService(repo, nil, gen, logrus.New())
+
+	app := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Ports: []model.ServicePort{
+			{Name: "grpc", Protocol: model.ProtocolGRPC, IngressPort: 8081, SubstancePort: 9091},
+			{Name: "admin", Protocol: model.ProtocolTCP, IngressPort: 8082, SubstancePort: 9092},
+		},
+		Hosts: []model.Host{{
+			Name:          "app1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+	}
+	changed, err := sut.IdempotentService("app", app)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	actual, _, err := sut.GetService("app")
+	assert.NoError(t, err)
+	assert.Equal(t, app.Ports, actual.Ports)
+
+	// the same ports in the other order
+	app.Ports = []model.ServicePort{app.Ports[1], app.Ports[0]}
+	changed, err = sut.IdempotentService("app", app)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// the ports conflict with the host
+	conflicted := app
+	conflicted.Ports = []model.ServicePort{{Name: "grpc", Protocol: model.ProtocolGRPC, IngressPort: 80, SubstancePort: 9091}}
+	_, err = sut.IdempotentService("app", conflicted)
+	assert.Error(t, err)
+	_, err = sut.RegisterHost("app", "app2", "192.168.0.2:8081", "127.0.0.1:8080", "127.0.0.1")
+	assert.Error(t, err)
+	substance := "127.0.0.1:9091"
+	_, err = sut.UpdateHost("app", "app1", nil, &substance, nil)
+	assert.Error(t, err)
+
+	// depend on the ports
+	front := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		DependentServices: []model.DependentService{
+			{Name: "app", EgressPort: 9001},
+			{Name: "app", Port: "grpc", EgressPort: 9002, Retry: &model.RetryPolicy{NumRetries: 2, RetryOn: []string{model.RetryOnCancelled}}},
+			{Name: "app", Port: "admin", EgressPort: 9003},
+		},
+	}
+	_, err = sut.IdempotentService("front", front)
+	assert.NoError(t, err)
+	actual, _, err = sut.GetService("front")
+	assert.NoError(t, err)
+	assert.True(t, model.EqualsServiceDependencies(front.DependentServices, actual.DependentServices))
+
+	// the policies are checked by the protocol of the port
+	invalid := front
+	invalid.DependentServices = []model.DependentService{{Name: "app", Port: "admin", EgressPort: 9003, Retry: &model.RetryPolicy{NumRetries: 2, RetryOn: []string{model.RetryOn5xx}}}}
+	_, err = sut.IdempotentService("front", invalid)
+	assert.Error(t, err)
+	invalid.DependentServices = []model.DependentService{{Name: "app", Port: "unknown", EgressPort: 9003}}
+	_, err = sut.IdempotentService("front", invalid)
+	assert.Error(t, err)
+	assert.Error(t, sut.AddServiceDependency("front", "app:unknown", 9004))
+
+	// fault is set by the reference to the port
+	assert.NoError(t, sut.SetDependencyFault("front", "app:grpc", &model.FaultPolicy{Abort: &model.FaultAbort{Percentage: 10, HTTPStatus: 503}}))
+	actual, _, err = sut.GetService("front")
+	assert.NoError(t, err)
+	_, dep := actual.FindDependentServiceRef("app:grpc")
+	assert.NotNil(t, dep.Fault)
+	_, dep = actual.FindDependentServiceRef("app")
+	assert.Nil(t, dep.Fault)
+
+	// the port which is depended on can not be removed
+	removed := app
+	removed.Ports = app.Ports[:1]
+	_, err = sut.IdempotentService("app", removed)
+	assert.Error(t, err)
+
+	// all dependencies to the service are removed with it
+	_, referrers, err := sut.UnregisterService("app")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"front"}, referrers)
+	actual, _, err = sut.GetService("front")
+	assert.NoError(t, err)
+	assert.Empty(t, actual.DependentServices)
+}
+
+func TestIdemopotentServiceLabels(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	discovery := MockedDiscoveryRepository{}
+	gen := &MockedVersionGen{Version: "abc"}
+	sut := NewInventoryService(repo, &discovery, gen, logrus.New())
+
+	app := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Labels:   map[string]string{"team": "payments", "tier": "web"},
+		Hosts: []model.Host{
+			{
+				Name:          "app1",
+				IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+				EgressHost:    "127.0.0.1",
+			},
+			{
+				Name:          "app2",
+				IngressAddr:   model.Address{Hostname: "192.168.0.2", Port: 80},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+				EgressHost:    "127.0.0.1",
+				Labels:        map[string]string{"tier": "batch"},
+			},
+		},
+	}
+	discovery.On("Register", app.Hosts[0], map[string]string{"team": "payments", "tier": "web", "meshem_service": "app", "meshem_namespace": "default"}).Return(nil)
+	discovery.On("Register", app.Hosts[1], map[string]string{"team": "payments", "tier": "batch", "meshem_service": "app", "meshem_namespace": "default"}).Return(nil)
+	changed, err := sut.IdempotentService("app", app)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	discovery.AssertExpectations(t)
+
+	other := model.IdempotentServiceParam{Protocol: model.ProtocolTCP, Labels: map[string]string{"team": "search"}}
+	_, err = sut.IdempotentService("other", other)
+	assert.NoError(t, err)
+
+	// select services
+	selector, _ := model.ParseLabelSelector("team=payments")
+	services, err := sut.GetServicesByLabels(selector)
+	assert.NoError(t, err)
+	assert.Len(t, services, 1)
+	assert.Equal(t, "app", services[0].Name)
+	selector, _ = model.ParseLabelSelector("team")
+	services, err = sut.GetServicesByLabels(selector)
+	assert.NoError(t, err)
+	assert.Len(t, services, 2)
+
+	// select hosts by the labels inherited from the service
+	selector, _ = model.ParseLabelSelector("team=payments,tier!=batch")
+	hosts, err := sut.GetHostsOfServiceByLabels("app", selector)
+	assert.NoError(t, err)
+	assert.Len(t, hosts, 1)
+	assert.Equal(t, "app1", hosts[0].Name)
+	_, err = sut.GetHostsOfServiceByLabels("unknown", selector)
+	assert.Error(t, err)
+
+	// the same labels
+	changed, err = sut.IdempotentService("app", app)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// the hosts are registered again with the new labels
+	updated := app
+	updated.Labels = map[string]string{"team": "billing", "tier": "web"}
+	discovery.On("Register", app.Hosts[0], map[string]string{"team": "billing", "tier": "web", "meshem_service": "app", "meshem_namespace": "default"}).Return(nil)
+	discovery.On("Register", app.Hosts[1], map[string]string{"team": "billing", "tier": "batch", "meshem_service": "app", "meshem_namespace": "default"}).Return(nil)
+	changed, err = sut.IdempotentService("app", updated)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	discovery.AssertExpectations(t)
+
+	// invalid labels
+	invalid := updated
+	invalid.Labels = map[string]string{"meshem_service": "app"}
+	_, err = sut.IdempotentService("app", invalid)
+	assert.Error(t, err)
+}
+
+func TestNamespaces(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := &MockedVersionGen{Version: "abc"}
+	sut := NewInventoryService(repo, nil, gen, logrus.New())
+
+	db := model.IdempotentServiceParam{
+		Protocol: model.ProtocolTCP,
+		Hosts: []model.Host{{
+			Name:          "db1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+	}
+	api := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Hosts: []model.Host{{
+			Name:          "api1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.2", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+		DependentServices: []model.DependentService{
+			{Name: "db", EgressPort: 9001},
+			{Name: "db.billing", EgressPort: 9002},
+		},
+	}
+
+	// the same names in the different namespaces
+	_, err := sut.IdempotentService("db.payments", db.ResolveNames("payments"))
+	assert.NoError(t, err)
+	_, err = sut.IdempotentService("db.billing", db.ResolveNames("billing"))
+	assert.NoError(t, err)
+	_, err = sut.IdempotentService("api.payments", api.ResolveNames("payments"))
+	assert.NoError(t, err)
+	names, err := sut.GetHostNames()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"db1.payments", "db1.billing", "api1.payments"}, names)
+
+	actual, _, err := sut.GetService("api.payments")
+	assert.NoError(t, err)
+	assert.Equal(t, "payments", actual.Namespace())
+	_, dep := actual.FindDependentServiceName("db.payments")
+	assert.NotNil(t, dep)
+	_, dep = actual.FindDependentServiceName("db.billing")
+	assert.NotNil(t, dep)
+	referrers, err := sut.GetRefferersOf("db.billing")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"api.payments"}, referrers)
+
+	// the host is in the namespace of the service
+	_, err = sut.RegisterHost("api.payments", "api2.billing", "192.168.0.3:80", "127.0.0.1:8080", "127.0.0.1")
+	assert.Error(t, err)
+	_, err = sut.RegisterHost("api.payments", "api2", "192.168.0.3:80", "127.0.0.1:8080", "127.0.0.1")
+	assert.Error(t, err)
+	_, err = sut.RegisterHost("api.payments", "api2.payments", "192.168.0.3:80", "127.0.0.1:8080", "127.0.0.1")
+	assert.NoError(t, err)
+
+	// unregistering the service keeps the one of the same name in the other namespace
+	_, referrers, err = sut.UnregisterService("db.billing")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"api.payments"}, referrers)
+	_, ok, err := sut.GetService("db.payments")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestIdemopotentServiceHeaders(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := &MockedVersionGen{Version: "abc"}
+	sut := NewInventoryService(repo, nil, gen, logrus.New())
+
+	_, err := sut.IdempotentService("db", model.IdempotentServiceParam{Protocol: model.ProtocolTCP})
+	assert.NoError(t, err)
+	_, err = sut.IdempotentService("app", model.IdempotentServiceParam{Protocol: model.ProtocolHTTP})
+	assert.NoError(t, err)
+
+	front := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Headers:  &model.HeaderPolicy{ResponseHeadersToRemove: []string{"x-internal-debug"}},
+		DependentServices: []model.DependentService{{
+			Name:       "app",
+			EgressPort: 9001,
+			Headers:    &model.HeaderPolicy{RequestHeadersToAdd: []model.HeaderValue{{Name: "x-caller-service", Value: "${service}"}}},
+		}},
+	}
+	changed, err := sut.IdempotentService("front", front)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	actual, _, err := sut.GetService("front")
+	assert.NoError(t, err)
+	assert.Equal(t, front.Headers, actual.Headers)
+	assert.Equal(t, front.DependentServices[0].Headers, actual.DependentServices[0].Headers)
+
+	// the same headers
+	changed, err = sut.IdempotentService("front", front)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// the headers are changed
+	updated := front
+	updated.Headers = &model.HeaderPolicy{ResponseHeadersToRemove: []string{"x-internal-trace"}}
+	changed, err = sut.IdempotentService("front", updated)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	// the headers can not be applied to TCP dependencies
+	invalid := updated
+	invalid.DependentServices = []model.DependentService{{
+		Name:       "db",
+		EgressPort: 9002,
+		Headers:    &model.HeaderPolicy{RequestHeadersToAdd: []model.HeaderValue{{Name: "x-caller-service", Value: "${service}"}}},
+	}}
+	_, err = sut.IdempotentService("front", invalid)
+	assert.Error(t, err)
+}
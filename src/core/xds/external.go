@@ -0,0 +1,57 @@
+package xds
+
+import (
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
+	"github.com/rerorero/meshem/src/model"
+)
+
+// MakeDNSCluster creates a cluster which resolves the hostname of the external service by DNS.
+func MakeDNSCluster(clusterName string, timeout time.Duration, external *model.ExternalService) *v2.Cluster {
+	discoveryType := v2.Cluster_STRICT_DNS
+	if external.Resolution == model.ResolutionLogicalDNS {
+		discoveryType = v2.Cluster_LOGICAL_DNS
+	}
+	return &v2.Cluster{
+		Name:           clusterName,
+		ConnectTimeout: timeout,
+		Type:           discoveryType,
+		LoadAssignment: &v2.ClusterLoadAssignment{
+			ClusterName: clusterName,
+			Endpoints: []endpoint.LocalityLbEndpoints{{
+				LbEndpoints: []endpoint.LbEndpoint{
+					makeLbEndpoint(model.Address{Hostname: external.Hostname, Port: external.Port}),
+				},
+			}},
+		},
+	}
+}
+
+// applyExternalTLS makes the cluster originate TLS to the external service if it's required.
+// The mesh certificates are never presented to the external services.
+func applyExternalTLS(c *v2.Cluster, external *model.ExternalService, protocol string) *v2.Cluster {
+	if external.TLS == nil {
+		return c
+	}
+	common := &auth.CommonTlsContext{}
+	if protocol == model.ProtocolGRPC {
+		common.AlpnProtocols = []string{"h2"}
+	}
+	if !external.TLS.InsecureSkipVerify {
+		common.ValidationContextType = &auth.CommonTlsContext_ValidationContext{
+			ValidationContext: &auth.CertificateValidationContext{
+				TrustedCa:            &core.DataSource{Specifier: &core.DataSource_Filename{Filename: external.TLS.CAFile}},
+				VerifySubjectAltName: []string{external.SNI()},
+			},
+		}
+	}
+	c.TlsContext = &auth.UpstreamTlsContext{
+		CommonTlsContext: common,
+		Sni:              external.SNI(),
+	}
+	return c
+}
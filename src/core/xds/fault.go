@@ -0,0 +1,55 @@
+package xds
+
+import (
+	"time"
+
+	fault "github.com/envoyproxy/go-control-plane/envoy/config/filter/fault/v2"
+	httpfault "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/fault/v2"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	envoytype "github.com/envoyproxy/go-control-plane/envoy/type"
+	"github.com/envoyproxy/go-control-plane/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/rerorero/meshem/src/model"
+)
+
+// activeFault returns the fault policy if it's not expired yet, otherwise nil.
+func activeFault(policy *model.FaultPolicy, now time.Time) *model.FaultPolicy {
+	if !policy.IsActive(now) {
+		return nil
+	}
+	return policy
+}
+
+func percentageOf(percent uint32) *envoytype.FractionalPercent {
+	return &envoytype.FractionalPercent{
+		Numerator:   percent,
+		Denominator: envoytype.FractionalPercent_HUNDRED,
+	}
+}
+
+// makeFaultFilter creates the HTTP filter which injects the delays and aborts of the policy.
+func makeFaultFilter(policy *model.FaultPolicy) (*hcm.HttpFilter, error) {
+	config := &httpfault.HTTPFault{}
+	if policy.Delay != nil {
+		delay := time.Duration(policy.Delay.DurationMS) * time.Millisecond
+		config.Delay = &fault.FaultDelay{
+			Type:               fault.FaultDelay_FIXED,
+			FaultDelaySecifier: &fault.FaultDelay_FixedDelay{FixedDelay: &delay},
+			Percentage:         percentageOf(policy.Delay.Percentage),
+		}
+	}
+	if policy.Abort != nil {
+		config.Abort = &httpfault.FaultAbort{
+			ErrorType:  &httpfault.FaultAbort_HttpStatus{HttpStatus: policy.Abort.HTTPStatus},
+			Percentage: percentageOf(policy.Abort.Percentage),
+		}
+	}
+	pbst, err := util.MessageToStruct(config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create fault filter %+v", policy)
+	}
+	return &hcm.HttpFilter{
+		Name:   FaultFilter,
+		Config: pbst,
+	}, nil
+}
@@ -0,0 +1,171 @@
+package xds
+
+import (
+	"fmt"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+	"github.com/pkg/errors"
+	"github.com/rerorero/meshem/src/model"
+)
+
+const (
+	gatewayStatPrefix = "gateway"
+)
+
+// GatewayRouteName returns the name of the route configuration of the gateway listener.
+func GatewayRouteName(port uint32) string {
+	return fmt.Sprintf("route-gateway-%d", port)
+}
+
+// MakeSnapshotsOfGateway makes the snapshots of the nodes of the gateway, they are keyed by the node names.
+func (gen *snapGen) MakeSnapshotsOfGateway(gatewayName string) (map[string]*Snapshot, error) {
+	gw, ok, err := gen.inventory.GetGateway(gatewayName)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("gateway=%s not found", gatewayName)
+	}
+
+	dependencies := map[*model.Service][]model.Host{}
+	for _, name := range gw.TargetServiceNames() {
+		upsvc, uphosts, err := gen.getUpstreamService(name)
+		if err != nil {
+			return nil, err
+		}
+		dependencies[&upsvc] = uphosts
+	}
+
+	snapshots := map[string]*Snapshot{}
+	for _, node := range gw.Nodes {
+		snapshot, err := gen.makeGatewaySnapshot(&gw, node, dependencies)
+		if err != nil {
+			return nil, errors.Wrapf(err, "udpate snapshot failed: gateway=%s, node=%s", gw.Name, node)
+		}
+		err = snapshot.Consistent()
+		if err != nil {
+			return nil, errors.Wrapf(err, "snapshot incosistency: %+v", snapshot.Snapshot)
+		}
+		snapshots[node] = snapshot
+	}
+	return snapshots, nil
+}
+
+func (gen *snapGen) makeGatewaySnapshot(gw *model.Gateway, node string, dependencies map[*model.Service][]model.Host) (*Snapshot, error) {
+	clusters := []cache.Resource{}
+	endpoints := []cache.Resource{}
+	routes := []cache.Resource{}
+	listeners := []cache.Resource{}
+
+	version := string(gen.latestNodeVersion(gw.Version, dependencies))
+
+	// the gateway nodes have no locality
+	caller := &model.Host{Name: node}
+	upstreams := map[string]*model.Service{}
+	for upsvc, uphosts := range dependencies {
+		upstreams[upsvc.Name] = upsvc
+		c, e := gen.makeUpstreamResources(caller, upsvc, uphosts, gen.connectTimeout(upsvc.Timeouts))
+		clusters = append(clusters, c...)
+		endpoints = append(endpoints, e...)
+	}
+
+	var i int
+	for i = 0; i < len(gw.Listeners); i++ {
+		l := &gw.Listeners[i]
+		routeName := GatewayRouteName(l.Address.Port)
+		r, err := MakeGatewayRoute(routeName, l.VirtualHosts, upstreams)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, r)
+
+		listener, err := MakeHTTPListener(&httpListenerParam{
+			listenerName: fmt.Sprintf("listener-gateway-%s", l.Address.ListenerSuffix()),
+			protocol:     model.ProtocolHTTP,
+			address:      &l.Address,
+			route:        routeName,
+			statPrefix:   gatewayStatPrefix,
+			logfileDir:   gen.envoyConf.AccessLogDir,
+			logfileName:  gatewayStatPrefix + ".log",
+			health:       NewDisabledHTTPHealthCheck(),
+			isIngress:    true,
+			traceEnabled: true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, applyGatewayTLS(listener, l.TLS))
+	}
+
+	// the gateway connects to the services with its own identity
+	var secrets cache.Resources
+	if gen.mtls != nil {
+		keyPair, err := gen.mtls.CA.Issue(node, gen.mtls.Conf.GatewaySpiffeID(gw.Name))
+		if err != nil {
+			return nil, err
+		}
+		secrets = gen.mtls.makeSecrets(fmt.Sprintf("%s-%s", version, keyPair.Serial), keyPair, upstreams)
+	}
+
+	return &Snapshot{
+		Snapshot: cache.NewSnapshot(version, endpoints, clusters, routes, listeners),
+		Secrets:  secrets,
+	}, nil
+}
+
+// MakeGatewayRoute creates an HTTP route which has the virtual hosts of the gateway listener.
+// upstreams must contain all of the services which the rules refer to.
+func MakeGatewayRoute(routeName string, virtualHosts []model.GatewayVirtualHost, upstreams map[string]*model.Service) (*v2.RouteConfiguration, error) {
+	vhosts := make([]route.VirtualHost, len(virtualHosts))
+	var i int
+	for i = 0; i < len(virtualHosts); i++ {
+		vh := &virtualHosts[i]
+		routes := make([]route.Route, len(vh.Routes))
+		var j int
+		for j = 0; j < len(vh.Routes); j++ {
+			rule := &vh.Routes[j]
+			r, err := makeRouteRule(rule, "", upstreams)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to make route rule[%d] of virtual host=%s", j, vh.Name)
+			}
+			if action, ok := r.Action.(*route.Route_Route); ok {
+				target := upstreams[rule.Service]
+				action.Route.Timeout = requestTimeoutOf(target.Timeouts)
+				if len(target.TraceSpan) > 0 {
+					r.Decorator = &route.Decorator{Operation: target.TraceSpan}
+				}
+			}
+			routes[j] = r
+		}
+		vhosts[i] = route.VirtualHost{
+			Name:    vh.Name,
+			Domains: vh.Domains,
+			Routes:  routes,
+		}
+	}
+	return &v2.RouteConfiguration{
+		Name:         routeName,
+		VirtualHosts: vhosts,
+	}, nil
+}
+
+// applyGatewayTLS makes the listener serve the certificate on the gateway nodes.
+func applyGatewayTLS(l *v2.Listener, tls *model.GatewayTLS) *v2.Listener {
+	if tls == nil {
+		return l
+	}
+	l.FilterChains[0].TlsContext = &auth.DownstreamTlsContext{
+		CommonTlsContext: &auth.CommonTlsContext{
+			TlsCertificates: []*auth.TlsCertificate{{
+				CertificateChain: &core.DataSource{Specifier: &core.DataSource_Filename{Filename: tls.CertFile}},
+				PrivateKey:       &core.DataSource{Specifier: &core.DataSource_Filename{Filename: tls.KeyFile}},
+			}},
+			AlpnProtocols: []string{"h2", "http/1.1"},
+		},
+	}
+	return l
+}
@@ -0,0 +1,153 @@
+package xds
+
+import (
+	"testing"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+	mcore "github.com/rerorero/meshem/src/core"
+	"github.com/rerorero/meshem/src/model"
+	"github.com/rerorero/meshem/src/repository"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMakeSnapshotsOfGateway(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := mcore.NewCurrentTimeGenerator()
+	inventory := mcore.NewInventoryService(repo, nil, gen, logrus.New())
+	conf := model.EnvoyConf{
+		ClusterTimeoutMS: 2000,
+		AccessLogDir:     "/var/log/test",
+	}
+	mtls := &MTLS{
+		Conf: model.MTLSConf{Mode: model.MTLSModeStrict, TrustDomain: "example.com"},
+		CA:   &mockedCA{serial: "1"},
+	}
+	sut := NewSnapshotGen(inventory, logrus.New(), gen, conf, mtls)
+
+	front := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Subsets:  []model.SubsetWeight{{Name: "v1", Weight: 1}, {Name: "v2", Weight: 3}},
+		Timeouts: &model.Timeouts{RequestTimeoutMS: 3000},
+		Hosts: []model.Host{
+			{
+				Name:          "front1",
+				IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+				EgressHost:    "127.0.0.1",
+				Subset:        "v1",
+			},
+			{
+				Name:          "front2",
+				IngressAddr:   model.Address{Hostname: "192.168.0.2", Port: 80},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+				EgressHost:    "127.0.0.1",
+				Subset:        "v2",
+			},
+		},
+	}
+	api := model.IdempotentServiceParam{
+		Protocol: model.ProtocolGRPC,
+		Hosts: []model.Host{{
+			Name:          "api1",
+			IngressAddr:   model.Address{Hostname: "192.168.1.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+	}
+	_, err := inventory.IdempotentService("front", front)
+	assert.NoError(t, err)
+	_, err = inventory.IdempotentService("api", api)
+	assert.NoError(t, err)
+
+	gw := model.GatewayParam{
+		Nodes: []string{"gw1", "gw2"},
+		Listeners: []model.GatewayListener{
+			{
+				Address: model.Address{Hostname: "0.0.0.0", Port: 80},
+				VirtualHosts: []model.GatewayVirtualHost{{
+					Name:    "https",
+					Domains: []string{"*"},
+					Routes:  []model.RouteRule{{Prefix: "/", Redirect: &model.RouteRedirect{Host: "example.com", ResponseCode: 308}}},
+				}},
+			},
+			{
+				Address: model.Address{Hostname: "0.0.0.0", Port: 443},
+				TLS:     &model.GatewayTLS{CertFile: "/etc/cert.pem", KeyFile: "/etc/key.pem"},
+				VirtualHosts: []model.GatewayVirtualHost{
+					{
+						Name:    "api",
+						Domains: []string{"api.example.com"},
+						Routes:  []model.RouteRule{{Prefix: "/", Service: "api"}},
+					},
+					{
+						Name:    "front",
+						Domains: []string{"example.com"},
+						Routes: []model.RouteRule{
+							{Prefix: "/beta", Service: "front", Subset: "v2"},
+							{Prefix: "/", Service: "front"},
+						},
+					},
+				},
+			},
+		},
+	}
+	_, err = inventory.IdempotentGateway("public", gw)
+	assert.NoError(t, err)
+
+	shots, err := sut.MakeSnapshotsOfGateway("public")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(shots))
+	actual, ok := shots["gw1"]
+	assert.True(t, ok)
+	assert.NoError(t, actual.Consistent())
+
+	// clusters and endpoints of the services
+	assert.ElementsMatch(t, []string{"egress-api", "egress-front~v1", "egress-front~v2"}, keysOf(actual.Clusters.Items))
+	assert.ElementsMatch(t, []string{"egress-api", "egress-front~v1", "egress-front~v2"}, keysOf(actual.Endpoints.Items))
+	v1 := actual.Endpoints.Items["egress-front~v1"].(*v2.ClusterLoadAssignment)
+	assert.Equal(t, "192.168.0.1:80", addr2str(v1.Endpoints[0].LbEndpoints[0].Endpoint.Address))
+	apiCluster := actual.Clusters.Items["egress-api"].(*v2.Cluster)
+	assert.NotNil(t, apiCluster.Http2ProtocolOptions)
+	assert.Equal(t, "trust-bundle-api", apiCluster.TlsContext.CommonTlsContext.GetValidationContextSdsSecretConfig().Name)
+
+	// routes
+	redirect := actual.Routes.Items["route-gateway-80"].(*v2.RouteConfiguration)
+	assert.Equal(t, "example.com", redirect.VirtualHosts[0].Routes[0].GetRedirect().HostRedirect)
+	public := actual.Routes.Items["route-gateway-443"].(*v2.RouteConfiguration)
+	assert.Equal(t, 2, len(public.VirtualHosts))
+	assert.Equal(t, []string{"api.example.com"}, public.VirtualHosts[0].Domains)
+	assert.Equal(t, "egress-api", public.VirtualHosts[0].Routes[0].GetRoute().GetCluster())
+	frontRoutes := public.VirtualHosts[1].Routes
+	assert.Equal(t, "egress-front~v2", frontRoutes[0].GetRoute().GetCluster())
+	assert.Equal(t, 2, len(frontRoutes[1].GetRoute().GetWeightedClusters().Clusters))
+	assert.Equal(t, "3s", frontRoutes[1].GetRoute().Timeout.String())
+
+	// listeners
+	assert.ElementsMatch(t, []string{"listener-gateway-0000-80", "listener-gateway-0000-443"}, keysOf(actual.Listeners.Items))
+	plain := actual.Listeners.Items["listener-gateway-0000-80"].(*v2.Listener)
+	assert.Nil(t, plain.FilterChains[0].TlsContext)
+	tls := actual.Listeners.Items["listener-gateway-0000-443"].(*v2.Listener).FilterChains[0].TlsContext
+	assert.Equal(t, "/etc/cert.pem", tls.CommonTlsContext.TlsCertificates[0].CertificateChain.GetFilename())
+	assert.Equal(t, "/etc/key.pem", tls.CommonTlsContext.TlsCertificates[0].PrivateKey.GetFilename())
+
+	// secrets
+	keyPair := actual.Secrets.Items[KeyPairSecret].(*auth.Secret).GetTlsCertificate()
+	assert.Equal(t, []byte("cert-gw1"), keyPair.CertificateChain.GetInlineBytes())
+	_, ok = actual.Secrets.Items["trust-bundle-front"]
+	assert.True(t, ok)
+
+	// unknown
+	_, err = sut.MakeSnapshotsOfGateway("unknown")
+	assert.Error(t, err)
+}
+
+func keysOf(items map[string]cache.Resource) []string {
+	keys := []string{}
+	for key := range items {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,74 @@
+package xds
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	"github.com/gogo/protobuf/types"
+	"github.com/rerorero/meshem/src/model"
+)
+
+// applyHeaderPolicy adds and removes the headers on the virtual hosts of the route configuration.
+// The variables in the values are expanded with vars.
+func applyHeaderPolicy(rc *v2.RouteConfiguration, policy *model.HeaderPolicy, vars model.HeaderVariables) *v2.RouteConfiguration {
+	if policy == nil {
+		return rc
+	}
+	var i int
+	for i = 0; i < len(rc.VirtualHosts); i++ {
+		vh := &rc.VirtualHosts[i]
+		vh.RequestHeadersToAdd = append(vh.RequestHeadersToAdd, makeHeaderValueOptions(policy.RequestHeadersToAdd, vars)...)
+		vh.ResponseHeadersToAdd = append(vh.ResponseHeadersToAdd, makeHeaderValueOptions(policy.ResponseHeadersToAdd, vars)...)
+		vh.ResponseHeadersToRemove = append(vh.ResponseHeadersToRemove, policy.ResponseHeadersToRemove...)
+	}
+	return rc
+}
+
+func makeHeaderValueOptions(headers []model.HeaderValue, vars model.HeaderVariables) []*core.HeaderValueOption {
+	options := make([]*core.HeaderValueOption, len(headers))
+	var i int
+	for i = 0; i < len(headers); i++ {
+		options[i] = &core.HeaderValueOption{
+			Header: &core.HeaderValue{
+				Key: headers[i].Name,
+				// '%' is escaped since envoy regards it as the beginning of its own variables
+				Value: strings.Replace(vars.Expand(headers[i].Value), "%", "%%", -1),
+			},
+			Append: &types.BoolValue{Value: headers[i].Append},
+		}
+	}
+	return options
+}
+
+// The route configuration of the envoy we use can't remove the request headers,
+// so they are removed by the Lua filter of the listener which has the only virtual host.
+const headerRemovalScript = `local headers = {%s}
+
+function envoy_on_request(request_handle)
+  for _, name in ipairs(headers) do
+    request_handle:headers():remove(name)
+  end
+end
+`
+
+// requestHeadersToRemove returns the request headers to remove of the policy which may be nil.
+func requestHeadersToRemove(policy *model.HeaderPolicy) []string {
+	if policy == nil {
+		return nil
+	}
+	return policy.RequestHeadersToRemove
+}
+
+// makeHeaderRemovalFilter creates the HTTP filter which removes the request headers.
+func makeHeaderRemovalFilter(names []string) (*hcm.HttpFilter, error) {
+	quoted := make([]string, len(names))
+	var i int
+	for i = 0; i < len(names); i++ {
+		// the names are validated to consist of the characters which need no escape
+		quoted[i] = fmt.Sprintf("%q", names[i])
+	}
+	return makeLuaFilter(fmt.Sprintf(headerRemovalScript, strings.Join(quoted, ", ")))
+}
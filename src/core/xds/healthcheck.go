@@ -3,14 +3,17 @@ package xds
 import (
 	"time"
 
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	hc "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/health_check/v2"
 	hcm "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
 	"github.com/envoyproxy/go-control-plane/pkg/util"
 	"github.com/gogo/protobuf/types"
 	"github.com/pkg/errors"
+	"github.com/rerorero/meshem/src/model"
 )
 
-// HTTPHealthCheck TODO: to be able to configure via ctlapi
+// HTTPHealthCheck configures the health check filter of the HTTP listener.
 type HTTPHealthCheck struct {
 	Enabled     bool
 	PassThrough bool
@@ -21,7 +24,7 @@ type HTTPHealthCheck struct {
 // NewDisabledHTTPHealthCheck creates default health check configuration.
 func NewDisabledHTTPHealthCheck() *HTTPHealthCheck {
 	return &HTTPHealthCheck{
-		Enabled: true,
+		Enabled: false,
 	}
 }
 
@@ -35,6 +38,22 @@ func NewDefaultPassThroghHTTPHealthCheck() *HTTPHealthCheck {
 	}
 }
 
+// NewHTTPHealthCheck creates health check configuration of the service. The default values are used for the unspecified fields.
+func NewHTTPHealthCheck(filter *model.HealthCheckFilter) *HTTPHealthCheck {
+	if !filter.IsEnabled() {
+		return NewDisabledHTTPHealthCheck()
+	}
+	hhc := NewDefaultPassThroghHTTPHealthCheck()
+	hhc.PassThrough = filter.Mode == model.HealthCheckFilterPassThrough
+	if len(filter.Endpoint) > 0 {
+		hhc.Endpoint = filter.Endpoint
+	}
+	if filter.CacheTimeMS > 0 {
+		hhc.CacheTime = time.Duration(filter.CacheTimeMS) * time.Millisecond
+	}
+	return hhc
+}
+
 func (hhc *HTTPHealthCheck) createEnvoyHTTPFilter() (*hcm.HttpFilter, error) {
 	if !hhc.Enabled {
 		return nil, nil
@@ -55,3 +74,39 @@ func (hhc *HTTPHealthCheck) createEnvoyHTTPFilter() (*hcm.HttpFilter, error) {
 		Config: pbst,
 	}, nil
 }
+
+// applyActiveHealthCheck adds the active health check to the cluster.
+func applyActiveHealthCheck(c *v2.Cluster, policy *model.ActiveHealthCheck, protocol string) *v2.Cluster {
+	if policy == nil {
+		return c
+	}
+	interval := time.Duration(policy.IntervalMS) * time.Millisecond
+	timeout := time.Duration(policy.TimeoutMS) * time.Millisecond
+	healthCheck := &core.HealthCheck{
+		Interval:           &interval,
+		Timeout:            &timeout,
+		HealthyThreshold:   &types.UInt32Value{Value: policy.HealthyThreshold},
+		UnhealthyThreshold: &types.UInt32Value{Value: policy.UnhealthyThreshold},
+	}
+	switch {
+	case policy.IsHTTP():
+		healthCheck.HealthChecker = &core.HealthCheck_HttpHealthCheck_{
+			HttpHealthCheck: &core.HealthCheck_HttpHealthCheck{
+				Path:     policy.Path,
+				UseHttp2: protocol == model.ProtocolGRPC,
+			},
+		}
+	case protocol == model.ProtocolGRPC:
+		// grpc.health.v1.Health
+		healthCheck.HealthChecker = &core.HealthCheck_GrpcHealthCheck_{
+			GrpcHealthCheck: &core.HealthCheck_GrpcHealthCheck{},
+		}
+	default:
+		// connect only
+		healthCheck.HealthChecker = &core.HealthCheck_TcpHealthCheck_{
+			TcpHealthCheck: &core.HealthCheck_TcpHealthCheck{},
+		}
+	}
+	c.HealthChecks = []*core.HealthCheck{healthCheck}
+	return c
+}
@@ -0,0 +1,39 @@
+package xds
+
+import (
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/gogo/protobuf/types"
+	"github.com/rerorero/meshem/src/model"
+)
+
+const (
+	// LabelMetadataNamespace is the filter metadata namespace of the endpoints in which the labels of the hosts are set.
+	LabelMetadataNamespace = "meshem"
+)
+
+// makeLabelMetadata creates the endpoint metadata of the labels, it returns nil if there are no labels.
+func makeLabelMetadata(labels map[string]string) *core.Metadata {
+	if len(labels) == 0 {
+		return nil
+	}
+	fields := map[string]*types.Value{}
+	for k, v := range labels {
+		fields[k] = &types.Value{Kind: &types.Value_StringValue{StringValue: v}}
+	}
+	return &core.Metadata{
+		FilterMetadata: map[string]*types.Struct{
+			LabelMetadataNamespace: {Fields: fields},
+		},
+	}
+}
+
+// labeledHosts returns the copies of the hosts whose labels inherit the labels of the service.
+func labeledHosts(svc *model.Service, hosts []model.Host) []model.Host {
+	copied := make([]model.Host, len(hosts))
+	var i int
+	for i = 0; i < len(hosts); i++ {
+		copied[i] = hosts[i]
+		copied[i].Labels = svc.HostLabels(&hosts[i])
+	}
+	return copied
+}
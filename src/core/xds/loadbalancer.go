@@ -0,0 +1,49 @@
+package xds
+
+import (
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/route"
+	"github.com/rerorero/meshem/src/model"
+)
+
+var (
+	lbPolicies = map[string]v2.Cluster_LbPolicy{
+		model.LBRoundRobin:   v2.Cluster_ROUND_ROBIN,
+		model.LBLeastRequest: v2.Cluster_LEAST_REQUEST,
+		model.LBRandom:       v2.Cluster_RANDOM,
+		model.LBRingHash:     v2.Cluster_RING_HASH,
+		model.LBMaglev:       v2.Cluster_MAGLEV,
+	}
+)
+
+// applyLoadBalancer sets the load balancing policy of the upstream to the cluster. Round robin is used if lb is nil.
+func applyLoadBalancer(c *v2.Cluster, lb *model.LoadBalancer) *v2.Cluster {
+	if lb == nil {
+		return c
+	}
+	c.LbPolicy = lbPolicies[lb.Policy]
+	return c
+}
+
+// makeHashPolicy creates the hash policy of the route for the consistent hashing load balancers.
+func makeHashPolicy(lb *model.LoadBalancer) []*route.RouteAction_HashPolicy {
+	if lb == nil || !lb.IsConsistentHashing() || lb.HashKey == nil {
+		return nil
+	}
+	policy := &route.RouteAction_HashPolicy{}
+	switch {
+	case len(lb.HashKey.Header) > 0:
+		policy.PolicySpecifier = &route.RouteAction_HashPolicy_Header_{
+			Header: &route.RouteAction_HashPolicy_Header{HeaderName: lb.HashKey.Header},
+		}
+	case len(lb.HashKey.Cookie) > 0:
+		policy.PolicySpecifier = &route.RouteAction_HashPolicy_Cookie_{
+			Cookie: &route.RouteAction_HashPolicy_Cookie{Name: lb.HashKey.Cookie},
+		}
+	default:
+		policy.PolicySpecifier = &route.RouteAction_HashPolicy_ConnectionProperties_{
+			ConnectionProperties: &route.RouteAction_HashPolicy_ConnectionProperties{SourceIp: lb.HashKey.SourceIP},
+		}
+	}
+	return []*route.RouteAction_HashPolicy{policy}
+}
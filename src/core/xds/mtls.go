@@ -0,0 +1,132 @@
+package xds
+
+import (
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+	"github.com/gogo/protobuf/types"
+	"github.com/rerorero/meshem/src/core/ca"
+	"github.com/rerorero/meshem/src/model"
+)
+
+// MTLS is the mutual TLS setting between the sidecars.
+type MTLS struct {
+	Conf model.MTLSConf
+	CA   ca.CertificateAuthority
+}
+
+const (
+	// TLSInspector is the name of the listener filter which detects TLS connections.
+	TLSInspector = "envoy.listener.tls_inspector"
+	// KeyPairSecret is the name of the secret which has the certificate of the host.
+	KeyPairSecret = "key-pair"
+	// TrustBundleSecret is the name of the secret which validates the certificates with the CA.
+	TrustBundleSecret = "trust-bundle"
+)
+
+// UpstreamValidationSecret returns the name of the secret which validates the certificates of the upstream service.
+func UpstreamValidationSecret(service string) string {
+	return TrustBundleSecret + "-" + service
+}
+
+func inlineDataSource(data []byte) *core.DataSource {
+	return &core.DataSource{
+		Specifier: &core.DataSource_InlineBytes{InlineBytes: data},
+	}
+}
+
+func sdsSecretConfig(name string) *auth.SdsSecretConfig {
+	return &auth.SdsSecretConfig{
+		Name: name,
+		SdsConfig: &core.ConfigSource{
+			ConfigSourceSpecifier: &core.ConfigSource_ApiConfigSource{
+				ApiConfigSource: &core.ApiConfigSource{
+					ApiType:      core.ApiConfigSource_GRPC,
+					ClusterNames: []string{XdsCluster},
+				},
+			},
+		},
+	}
+}
+
+// makeSecrets makes the secrets which the listeners and the clusters refer to.
+func (m *MTLS) makeSecrets(version string, keyPair *ca.KeyPair, upstreams map[string]*model.Service) cache.Resources {
+	secrets := []*auth.Secret{
+		{
+			Name: KeyPairSecret,
+			Type: &auth.Secret_TlsCertificate{TlsCertificate: &auth.TlsCertificate{
+				CertificateChain: inlineDataSource(keyPair.CertPEM),
+				PrivateKey:       inlineDataSource(keyPair.KeyPEM),
+			}},
+		},
+		m.validationSecret(TrustBundleSecret, nil),
+	}
+	for name, upstream := range upstreams {
+		if upstream.IsExternal() {
+			continue
+		}
+		secrets = append(secrets, m.validationSecret(UpstreamValidationSecret(name), []string{m.Conf.SpiffeID(name)}))
+	}
+
+	items := map[string]cache.Resource{}
+	for _, secret := range secrets {
+		items[secret.Name] = secret
+	}
+	return cache.Resources{Version: version, Items: items}
+}
+
+func (m *MTLS) validationSecret(name string, verifySAN []string) *auth.Secret {
+	return &auth.Secret{
+		Name: name,
+		Type: &auth.Secret_ValidationContext{ValidationContext: &auth.CertificateValidationContext{
+			TrustedCa:            inlineDataSource(m.CA.TrustBundle()),
+			VerifySubjectAltName: verifySAN,
+		}},
+	}
+}
+
+func commonTLSContext(validationSecret string, protocol string) *auth.CommonTlsContext {
+	var alpn []string
+	if protocol == model.ProtocolGRPC {
+		alpn = []string{"h2"}
+	}
+	return &auth.CommonTlsContext{
+		TlsCertificateSdsSecretConfigs: []*auth.SdsSecretConfig{sdsSecretConfig(KeyPairSecret)},
+		ValidationContextType: &auth.CommonTlsContext_ValidationContextSdsSecretConfig{
+			ValidationContextSdsSecretConfig: sdsSecretConfig(validationSecret),
+		},
+		AlpnProtocols: alpn,
+	}
+}
+
+// applyDownstreamTLS makes the ingress listener require client certificates signed by the CA.
+// Plaintext connections are also accepted in permissive mode.
+func (m *MTLS) applyDownstreamTLS(l *v2.Listener, protocol string) *v2.Listener {
+	tlsContext := &auth.DownstreamTlsContext{
+		CommonTlsContext:         commonTLSContext(TrustBundleSecret, protocol),
+		RequireClientCertificate: &types.BoolValue{Value: true},
+	}
+	if m.Conf.Mode != model.MTLSModePermissive {
+		l.FilterChains[0].TlsContext = tlsContext
+		return l
+	}
+
+	plaintext := l.FilterChains[0]
+	tls := plaintext
+	tls.FilterChainMatch = &listener.FilterChainMatch{TransportProtocol: "tls"}
+	tls.TlsContext = tlsContext
+	l.FilterChains = []listener.FilterChain{tls, plaintext}
+	l.ListenerFilters = []listener.ListenerFilter{{Name: TLSInspector}}
+	return l
+}
+
+// applyUpstreamTLS makes the egress cluster connect to the upstream with the client certificate,
+// and verify that the upstream has the identity of the service.
+func (m *MTLS) applyUpstreamTLS(c *v2.Cluster, upstream *model.Service) *v2.Cluster {
+	c.TlsContext = &auth.UpstreamTlsContext{
+		CommonTlsContext: commonTLSContext(UpstreamValidationSecret(upstream.Name), upstream.Protocol),
+	}
+	return c
+}
@@ -0,0 +1,37 @@
+package xds
+
+import (
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+	"github.com/rerorero/meshem/src/model"
+)
+
+// PortClusterName returns the name of the cluster of the named port, the cluster of the default port is returned if port is empty.
+func PortClusterName(clusterName string, port string) string {
+	if len(port) == 0 {
+		return clusterName
+	}
+	return clusterName + model.ServiceRefSeparator + port
+}
+
+// makePortUpstreamResources creates the cluster and the endpoints of the named port of the upstream service.
+// The subsets of the upstream are not applied since they are routed on its default port.
+func (gen *snapGen) makePortUpstreamResources(caller *model.Host, upsvc *model.Service, port *model.ServicePort, uphosts []model.Host, connectTimeout time.Duration) (clusters []cache.Resource, endpoints []cache.Resource) {
+	clusterName := PortClusterName(EgressClusterName(upsvc.Name), port.Name)
+	portService := upsvc.PortService(port)
+	clusters = append(clusters, gen.makeEgressCluster(clusterName, connectTimeout, &portService))
+	endpoints = append(endpoints, MakeLocalityEndpoint(clusterName, caller, hostsOnPort(labeledHosts(upsvc, uphosts), port), nil))
+	return clusters, endpoints
+}
+
+// hostsOnPort returns the copies of the hosts whose ingress addresses are replaced with the ingress port of the named port.
+func hostsOnPort(hosts []model.Host, port *model.ServicePort) []model.Host {
+	copied := make([]model.Host, len(hosts))
+	var i int
+	for i = 0; i < len(hosts); i++ {
+		copied[i] = hosts[i]
+		copied[i].IngressAddr.Port = port.IngressPort
+	}
+	return copied
+}
@@ -0,0 +1,99 @@
+package xds
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/ratelimit"
+	lua "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/lua/v2"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	rl "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/rate_limit/v2"
+	"github.com/envoyproxy/go-control-plane/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/rerorero/meshem/src/model"
+)
+
+const (
+	// LuaFilter is the name of the HTTP filter which runs the Lua script.
+	LuaFilter = "envoy.lua"
+	// RateLimitedStatus is the status of the responses to the requests which exceed the rate limit.
+	RateLimitedStatus = 429
+	// NetworkRateLimitFilter is the name of the network filter which asks the rate limit service whether to accept the connections.
+	NetworkRateLimitFilter = "envoy.ratelimit"
+	// RateLimitDomain is the domain of the rate limits which the rate limit service of meshem serves.
+	RateLimitDomain = "meshem"
+
+	rateLimitKeyHost        = "host"
+	rateLimitKeyListener    = "listener"
+	rateLimitKeyConnections = "connections_per_second"
+)
+
+// The envoy we use has no local rate limit filter, so the token bucket is implemented in Lua.
+// Each worker thread of envoy has its own Lua state, so the bucket is per worker thread.
+// The bucket is refilled every second since os.time() has the resolution of seconds.
+const rateLimitScript = `local max_tokens = %d
+local tokens_per_fill = %d
+local tokens = max_tokens
+local last_fill = os.time()
+
+function envoy_on_request(request_handle)
+  local now = os.time()
+  if now > last_fill then
+    tokens = math.min(max_tokens, tokens + (now - last_fill) * tokens_per_fill)
+    last_fill = now
+  end
+  if tokens < 1 then
+    request_handle:respond({[":status"] = "%d", ["x-envoy-ratelimited"] = "true"}, "too many requests")
+    return
+  end
+  tokens = tokens - 1
+end
+`
+
+// makeLuaFilter creates the HTTP filter which runs the script.
+func makeLuaFilter(script string) (*hcm.HttpFilter, error) {
+	pbst, err := util.MessageToStruct(&lua.Lua{InlineCode: script})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create lua filter")
+	}
+	return &hcm.HttpFilter{
+		Name:   LuaFilter,
+		Config: pbst,
+	}, nil
+}
+
+// makeHTTPRateLimitFilter creates the HTTP filter which limits the requests per second.
+func makeHTTPRateLimitFilter(limit *model.RateLimit) (*hcm.HttpFilter, error) {
+	return makeLuaFilter(fmt.Sprintf(rateLimitScript, limit.MaxTokens(), limit.RequestsPerSecond, RateLimitedStatus))
+}
+
+// applyTCPRateLimit places the network filter which limits the connections per second to the listener before the TCP proxy.
+// The limit is sent in the descriptor so that the rate limit service doesn't look up the inventory for each connection.
+func applyTCPRateLimit(l *v2.Listener, hostName string, statPrefix string, limit *model.RateLimit) error {
+	config := &rl.RateLimit{
+		StatPrefix: statPrefix,
+		Domain:     RateLimitDomain,
+		Descriptors: []*ratelimit.RateLimitDescriptor{{
+			Entries: []*ratelimit.RateLimitDescriptor_Entry{
+				{Key: rateLimitKeyHost, Value: hostName},
+				{Key: rateLimitKeyListener, Value: l.Name},
+				{Key: rateLimitKeyConnections, Value: strconv.FormatUint(uint64(limit.ConnectionsPerSecond), 10)},
+			},
+		}},
+	}
+	pbst, err := util.MessageToStruct(config)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create rate limit filter(listener=%s)", l.Name)
+	}
+	filter := listener.Filter{
+		Name:   NetworkRateLimitFilter,
+		Config: pbst,
+	}
+	var i int
+	for i = 0; i < len(l.FilterChains); i++ {
+		l.FilterChains[i].Filters = append([]listener.Filter{filter}, l.FilterChains[i].Filters...)
+	}
+	return nil
+}
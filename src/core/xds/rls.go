@@ -0,0 +1,132 @@
+package xds
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/ratelimit"
+	rls "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// bucketSweepInterval is the interval to remove the idle token buckets.
+// A bucket idle for a second is full, so it's the same as the new one.
+const bucketSweepInterval = time.Minute
+
+// RateLimitServer is the rate limit service which envoy asks whether to accept the connections of the TCP services.
+// The limits are sent in the descriptors by envoy, and the token buckets are kept in memory of each meshem server.
+type RateLimitServer interface {
+	rls.RateLimitServiceServer
+}
+
+type tokenBucket struct {
+	rate     uint32
+	tokens   float64
+	lastFill time.Time
+}
+
+type rateLimitServer struct {
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+	mutex     sync.Mutex
+	logger    *logrus.Logger
+}
+
+// NewRateLimitServer creates a rate limit server.
+func NewRateLimitServer(logger *logrus.Logger) RateLimitServer {
+	return newRateLimitServer(time.Now, logger)
+}
+
+func newRateLimitServer(now func() time.Time, logger *logrus.Logger) *rateLimitServer {
+	return &rateLimitServer{
+		buckets:   map[string]*tokenBucket{},
+		lastSweep: now(),
+		now:       now,
+		logger:    logger,
+	}
+}
+
+func (s *rateLimitServer) ShouldRateLimit(ctx context.Context, req *rls.RateLimitRequest) (*rls.RateLimitResponse, error) {
+	hits := req.HitsAddend
+	if hits == 0 {
+		hits = 1
+	}
+	res := &rls.RateLimitResponse{
+		OverallCode: rls.RateLimitResponse_OK,
+		Statuses:    make([]*rls.RateLimitResponse_DescriptorStatus, len(req.Descriptors)),
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	var i int
+	for i = 0; i < len(req.Descriptors); i++ {
+		res.Statuses[i] = s.take(req.Domain, req.Descriptors[i], hits, now)
+		if res.Statuses[i].Code == rls.RateLimitResponse_OVER_LIMIT {
+			res.OverallCode = rls.RateLimitResponse_OVER_LIMIT
+		}
+	}
+	if now.Sub(s.lastSweep) >= bucketSweepInterval {
+		s.sweep(now)
+	}
+	return res, nil
+}
+
+// take takes the tokens from the bucket of the descriptor. The descriptors meshem doesn't know are not limited.
+func (s *rateLimitServer) take(domain string, descriptor *ratelimit.RateLimitDescriptor, hits uint32, now time.Time) *rls.RateLimitResponse_DescriptorStatus {
+	ok := &rls.RateLimitResponse_DescriptorStatus{Code: rls.RateLimitResponse_OK}
+	if domain != RateLimitDomain {
+		return ok
+	}
+	entries := map[string]string{}
+	for _, e := range descriptor.Entries {
+		entries[e.Key] = e.Value
+	}
+	host, hasHost := entries[rateLimitKeyHost]
+	listenerName, hasListener := entries[rateLimitKeyListener]
+	rate, err := strconv.ParseUint(entries[rateLimitKeyConnections], 10, 32)
+	if !hasHost || !hasListener || err != nil || rate == 0 {
+		s.logger.Warnf("unknown rate limit descriptor: %+v", descriptor.Entries)
+		return ok
+	}
+
+	key := host + "/" + listenerName
+	bucket, found := s.buckets[key]
+	if !found || bucket.rate != uint32(rate) {
+		bucket = &tokenBucket{rate: uint32(rate), tokens: float64(rate), lastFill: now}
+		s.buckets[key] = bucket
+	}
+	if now.After(bucket.lastFill) {
+		bucket.tokens += now.Sub(bucket.lastFill).Seconds() * float64(bucket.rate)
+		if bucket.tokens > float64(bucket.rate) {
+			bucket.tokens = float64(bucket.rate)
+		}
+		bucket.lastFill = now
+	}
+
+	status := &rls.RateLimitResponse_DescriptorStatus{
+		Code:         rls.RateLimitResponse_OK,
+		CurrentLimit: &rls.RateLimitResponse_RateLimit{RequestsPerUnit: bucket.rate, Unit: rls.RateLimitResponse_RateLimit_SECOND},
+	}
+	if bucket.tokens < float64(hits) {
+		status.Code = rls.RateLimitResponse_OVER_LIMIT
+		return status
+	}
+	bucket.tokens -= float64(hits)
+	status.LimitRemaining = uint32(bucket.tokens)
+	return status
+}
+
+// sweep removes the buckets which are full, the buckets of the removed hosts are also removed by this.
+func (s *rateLimitServer) sweep(now time.Time) {
+	for key, bucket := range s.buckets {
+		if now.Sub(bucket.lastFill) >= time.Second {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,84 @@
+package xds
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/ratelimit"
+	rls "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeRateLimitRequest(host string, rate string) *rls.RateLimitRequest {
+	return &rls.RateLimitRequest{
+		Domain: RateLimitDomain,
+		Descriptors: []*ratelimit.RateLimitDescriptor{{
+			Entries: []*ratelimit.RateLimitDescriptor_Entry{
+				{Key: rateLimitKeyHost, Value: host},
+				{Key: rateLimitKeyListener, Value: "listener-ingress"},
+				{Key: rateLimitKeyConnections, Value: rate},
+			},
+		}},
+	}
+}
+
+func TestShouldRateLimit(t *testing.T) {
+	now := time.Now()
+	sut := newRateLimitServer(func() time.Time { return now }, logrus.New())
+	ctx := context.Background()
+
+	// the tokens are consumed
+	var i int
+	for i = 0; i < 3; i++ {
+		res, err := sut.ShouldRateLimit(ctx, makeRateLimitRequest("db1", "3"))
+		assert.NoError(t, err)
+		assert.Equal(t, rls.RateLimitResponse_OK, res.OverallCode)
+		assert.Equal(t, uint32(2-i), res.Statuses[0].LimitRemaining)
+		assert.Equal(t, uint32(3), res.Statuses[0].CurrentLimit.RequestsPerUnit)
+	}
+	res, err := sut.ShouldRateLimit(ctx, makeRateLimitRequest("db1", "3"))
+	assert.NoError(t, err)
+	assert.Equal(t, rls.RateLimitResponse_OVER_LIMIT, res.OverallCode)
+	assert.Equal(t, rls.RateLimitResponse_OVER_LIMIT, res.Statuses[0].Code)
+
+	// the other hosts have their own buckets
+	res, err = sut.ShouldRateLimit(ctx, makeRateLimitRequest("db2", "3"))
+	assert.NoError(t, err)
+	assert.Equal(t, rls.RateLimitResponse_OK, res.OverallCode)
+
+	// refilled with the rate
+	now = now.Add(time.Second / 2)
+	res, err = sut.ShouldRateLimit(ctx, makeRateLimitRequest("db1", "3"))
+	assert.NoError(t, err)
+	assert.Equal(t, rls.RateLimitResponse_OK, res.OverallCode)
+	res, err = sut.ShouldRateLimit(ctx, makeRateLimitRequest("db1", "3"))
+	assert.NoError(t, err)
+	assert.Equal(t, rls.RateLimitResponse_OVER_LIMIT, res.OverallCode)
+
+	// the changed limit is applied immediately
+	res, err = sut.ShouldRateLimit(ctx, makeRateLimitRequest("db1", "10"))
+	assert.NoError(t, err)
+	assert.Equal(t, rls.RateLimitResponse_OK, res.OverallCode)
+	assert.Equal(t, uint32(9), res.Statuses[0].LimitRemaining)
+
+	// the idle buckets are removed
+	now = now.Add(bucketSweepInterval)
+	_, err = sut.ShouldRateLimit(ctx, makeRateLimitRequest("db1", "10"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(sut.buckets))
+
+	// unknown descriptors are not limited
+	req := makeRateLimitRequest("db1", "0")
+	res, err = sut.ShouldRateLimit(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, rls.RateLimitResponse_OK, res.OverallCode)
+	req = makeRateLimitRequest("db1", "1")
+	req.Domain = "other"
+	for i = 0; i < 3; i++ {
+		res, err = sut.ShouldRateLimit(ctx, req)
+		assert.NoError(t, err)
+		assert.Equal(t, rls.RateLimitResponse_OK, res.OverallCode)
+	}
+}
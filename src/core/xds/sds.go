@@ -0,0 +1,182 @@
+package xds
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+	"github.com/gogo/protobuf/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SecretType is the type url of the SDS resources.
+	SecretType = "type.googleapis.com/envoy.api.v2.auth.Secret"
+)
+
+// SDSServer serves the secrets of the nodes.
+// The snapshot cache of go-control-plane doesn't support secrets, so they are cached here next to it.
+type SDSServer interface {
+	discovery.SecretDiscoveryServiceServer
+	// SetSecrets updates the secrets of the node, the streams of the node are notified if the version is changed.
+	SetSecrets(node string, secrets cache.Resources)
+}
+
+type nodeSecrets struct {
+	secrets cache.Resources
+	// closed when the secrets are updated
+	updated chan struct{}
+}
+
+type sdsServer struct {
+	nodes  map[string]*nodeSecrets
+	hash   cache.NodeHash
+	nonce  int64
+	mutex  sync.Mutex
+	logger *logrus.Logger
+}
+
+// NewSDSServer creates a SDS server.
+func NewSDSServer(hash cache.NodeHash, logger *logrus.Logger) SDSServer {
+	return &sdsServer{
+		nodes:  map[string]*nodeSecrets{},
+		hash:   hash,
+		logger: logger,
+	}
+}
+
+func (s *sdsServer) SetSecrets(node string, secrets cache.Resources) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current, ok := s.nodes[node]
+	if ok {
+		if current.secrets.Version == secrets.Version {
+			return
+		}
+		close(current.updated)
+	}
+	s.nodes[node] = &nodeSecrets{secrets: secrets, updated: make(chan struct{})}
+}
+
+// watch returns the current secrets of the node and the channel which is closed when they are updated.
+func (s *sdsServer) watch(node string) (cache.Resources, <-chan struct{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current, ok := s.nodes[node]
+	if !ok {
+		current = &nodeSecrets{updated: make(chan struct{})}
+		s.nodes[node] = current
+	}
+	return current.secrets, current.updated
+}
+
+func (s *sdsServer) FetchSecrets(ctx context.Context, req *v2.DiscoveryRequest) (*v2.DiscoveryResponse, error) {
+	node := s.hash.ID(req.Node)
+	secrets, _ := s.watch(node)
+	if len(secrets.Version) == 0 {
+		return nil, fmt.Errorf("missing secrets for %q", node)
+	}
+	if secrets.Version == req.VersionInfo {
+		return nil, errors.New("skip fetch: version up to date")
+	}
+	return s.makeResponse(secrets, req)
+}
+
+func (s *sdsServer) StreamSecrets(stream discovery.SecretDiscoveryService_StreamSecretsServer) error {
+	reqCh := make(chan *v2.DiscoveryRequest)
+	errCh := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			select {
+			case reqCh <- req:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	var req *v2.DiscoveryRequest
+	var updated <-chan struct{}
+	var nonce string
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case err := <-errCh:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case r := <-reqCh:
+			// ignore the requests for the stale responses
+			if len(r.ResponseNonce) > 0 && r.ResponseNonce != nonce {
+				continue
+			}
+			if r.ErrorDetail != nil {
+				s.logger.Errorf("secrets are rejected by %s: %s", s.hash.ID(r.Node), r.ErrorDetail.Message)
+			}
+			req = r
+		case <-updated:
+		}
+
+		var secrets cache.Resources
+		secrets, updated = s.watch(s.hash.ID(req.Node))
+		if len(secrets.Version) == 0 || secrets.Version == req.VersionInfo {
+			continue
+		}
+		resp, err := s.makeResponse(secrets, req)
+		if err != nil {
+			return err
+		}
+		if err = stream.Send(resp); err != nil {
+			return err
+		}
+		nonce = resp.Nonce
+	}
+}
+
+// makeResponse makes a response which contains the requested secrets. All secrets are returned if no names are requested.
+func (s *sdsServer) makeResponse(secrets cache.Resources, req *v2.DiscoveryRequest) (*v2.DiscoveryResponse, error) {
+	names := req.ResourceNames
+	if len(names) == 0 {
+		for name := range secrets.Items {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	resources := []types.Any{}
+	for _, name := range names {
+		secret, ok := secrets.Items[name]
+		if !ok {
+			continue
+		}
+		any, err := types.MarshalAny(secret)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, *any)
+	}
+
+	return &v2.DiscoveryResponse{
+		VersionInfo: secrets.Version,
+		Resources:   resources,
+		TypeUrl:     SecretType,
+		Nonce:       strconv.FormatInt(atomic.AddInt64(&s.nonce, 1), 10),
+	}, nil
+}
@@ -0,0 +1,147 @@
+package xds
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+	"github.com/gogo/protobuf/types"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+type mockedSecretStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	reqCh chan *v2.DiscoveryRequest
+	resCh chan *v2.DiscoveryResponse
+}
+
+func (m *mockedSecretStream) Context() context.Context {
+	return m.ctx
+}
+
+func (m *mockedSecretStream) Send(res *v2.DiscoveryResponse) error {
+	m.resCh <- res
+	return nil
+}
+
+func (m *mockedSecretStream) Recv() (*v2.DiscoveryRequest, error) {
+	req, more := <-m.reqCh
+	if !more {
+		return nil, io.EOF
+	}
+	return req, nil
+}
+
+func makeTestSecrets(version string, names ...string) cache.Resources {
+	items := map[string]cache.Resource{}
+	for _, name := range names {
+		items[name] = &auth.Secret{
+			Name: name,
+			Type: &auth.Secret_TlsCertificate{TlsCertificate: &auth.TlsCertificate{
+				CertificateChain: inlineDataSource([]byte("cert-" + version)),
+			}},
+		}
+	}
+	return cache.Resources{Version: version, Items: items}
+}
+
+func secretsOf(t *testing.T, res *v2.DiscoveryResponse) []*auth.Secret {
+	secrets := []*auth.Secret{}
+	for _, any := range res.Resources {
+		secret := &auth.Secret{}
+		assert.NoError(t, types.UnmarshalAny(&any, secret))
+		secrets = append(secrets, secret)
+	}
+	return secrets
+}
+
+func receive(t *testing.T, stream *mockedSecretStream) *v2.DiscoveryResponse {
+	select {
+	case res := <-stream.resCh:
+		return res
+	case <-time.After(3 * time.Second):
+		assert.Fail(t, "no response")
+		return nil
+	}
+}
+
+func TestFetchSecrets(t *testing.T) {
+	sut := NewSDSServer(Hasher{}, logrus.New())
+	node := &core.Node{Id: "app1"}
+
+	// not ready
+	_, err := sut.FetchSecrets(context.Background(), &v2.DiscoveryRequest{Node: node})
+	assert.Error(t, err)
+
+	sut.SetSecrets("app1", makeTestSecrets("v1", KeyPairSecret, TrustBundleSecret))
+	res, err := sut.FetchSecrets(context.Background(), &v2.DiscoveryRequest{Node: node, ResourceNames: []string{KeyPairSecret}})
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", res.VersionInfo)
+	assert.Equal(t, SecretType, res.TypeUrl)
+	secrets := secretsOf(t, res)
+	assert.Equal(t, 1, len(secrets))
+	assert.Equal(t, KeyPairSecret, secrets[0].Name)
+
+	// all secrets
+	res, err = sut.FetchSecrets(context.Background(), &v2.DiscoveryRequest{Node: node})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(res.Resources))
+
+	// up to date
+	_, err = sut.FetchSecrets(context.Background(), &v2.DiscoveryRequest{Node: node, VersionInfo: "v1"})
+	assert.Error(t, err)
+}
+
+func TestStreamSecrets(t *testing.T) {
+	sut := NewSDSServer(Hasher{}, logrus.New())
+	node := &core.Node{Id: "app1"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &mockedSecretStream{
+		ctx:   ctx,
+		reqCh: make(chan *v2.DiscoveryRequest),
+		resCh: make(chan *v2.DiscoveryResponse, 1),
+	}
+	done := make(chan error)
+	go func() {
+		done <- sut.StreamSecrets(stream)
+	}()
+
+	// the response is sent once the secrets are set
+	stream.reqCh <- &v2.DiscoveryRequest{Node: node, ResourceNames: []string{KeyPairSecret}}
+	sut.SetSecrets("app1", makeTestSecrets("v1", KeyPairSecret))
+	res := receive(t, stream)
+	assert.Equal(t, "v1", res.VersionInfo)
+	secrets := secretsOf(t, res)
+	assert.Equal(t, []byte("cert-v1"), secrets[0].GetTlsCertificate().CertificateChain.GetInlineBytes())
+
+	// ack
+	stream.reqCh <- &v2.DiscoveryRequest{Node: node, ResourceNames: []string{KeyPairSecret}, VersionInfo: "v1", ResponseNonce: res.Nonce}
+	// the same version is ignored
+	sut.SetSecrets("app1", makeTestSecrets("v1", KeyPairSecret))
+	// rotated
+	sut.SetSecrets("app1", makeTestSecrets("v2", KeyPairSecret))
+	res = receive(t, stream)
+	assert.Equal(t, "v2", res.VersionInfo)
+	secrets = secretsOf(t, res)
+	assert.Equal(t, []byte("cert-v2"), secrets[0].GetTlsCertificate().CertificateChain.GetInlineBytes())
+
+	// other nodes are not notified
+	sut.SetSecrets("app2", makeTestSecrets("v3", KeyPairSecret))
+	select {
+	case res = <-stream.resCh:
+		assert.Fail(t, "unexpected response", "%+v", res)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(stream.reqCh)
+	assert.NoError(t, <-done)
+}
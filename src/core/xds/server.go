@@ -8,10 +8,12 @@ import (
 
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	rls "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v2"
 	"github.com/envoyproxy/go-control-plane/pkg/cache"
 	xds "github.com/envoyproxy/go-control-plane/pkg/server"
 	"github.com/pkg/errors"
 	mcore "github.com/rerorero/meshem/src/core"
+	"github.com/rerorero/meshem/src/core/ca"
 	"github.com/rerorero/meshem/src/model"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
@@ -25,18 +27,26 @@ type XDSServer interface {
 type xdss struct {
 	inventory     mcore.InventoryService
 	snapshotCache cache.SnapshotCache
+	sdsServer     SDSServer
+	rlsServer     RateLimitServer
 	snapshotGen   SnapshotGen
 	conf          model.XDSConf
 	ctx           context.Context
 	logger        *logrus.Logger
 }
 
-// NewXDSServer creates a xds server.
-func NewXDSServer(inventory mcore.InventoryService, vb mcore.VersionGenerator, conf model.MeshemConf, ctx context.Context, logger *logrus.Logger) XDSServer {
+// NewXDSServer creates a xds server. authority is nil if mutual TLS is disabled.
+func NewXDSServer(inventory mcore.InventoryService, vb mcore.VersionGenerator, conf model.MeshemConf, authority ca.CertificateAuthority, ctx context.Context, logger *logrus.Logger) XDSServer {
+	var mtls *MTLS
+	if conf.MTLS != nil && authority != nil {
+		mtls = &MTLS{Conf: *conf.MTLS, CA: authority}
+	}
 	return &xdss{
 		inventory:     inventory,
 		snapshotCache: cache.NewSnapshotCache(conf.XDS.IsADSMode, Hasher{}, &snapshotLogger{logger}),
-		snapshotGen:   NewSnapshotGen(inventory, logger, vb, conf.Envoy),
+		sdsServer:     NewSDSServer(Hasher{}, logger),
+		rlsServer:     NewRateLimitServer(logger),
+		snapshotGen:   NewSnapshotGen(inventory, logger, vb, conf.Envoy, mtls),
 		conf:          conf.XDS,
 		ctx:           ctx,
 		logger:        logger,
@@ -69,6 +79,8 @@ func (s *xdss) RunXDS() (*grpc.Server, error) {
 	v2.RegisterClusterDiscoveryServiceServer(grpcServer, server)
 	v2.RegisterRouteDiscoveryServiceServer(grpcServer, server)
 	v2.RegisterListenerDiscoveryServiceServer(grpcServer, server)
+	discovery.RegisterSecretDiscoveryServiceServer(grpcServer, s.sdsServer)
+	rls.RegisterRateLimitServiceServer(grpcServer, s.rlsServer)
 	s.logger.Infof("xDS server listening on %d", s.conf.Port)
 
 	go func() {
@@ -87,6 +99,15 @@ func (s *xdss) RunSnapshotCollector() {
 		for {
 			select {
 			case <-ticker.C:
+				// expired faults and drained hosts are removed before the snapshots are made so that they are not rendered
+				now := time.Now()
+				if err := s.inventory.RemoveExpiredFaults(now); err != nil {
+					s.logger.Error(err)
+				}
+				gracePeriod := time.Duration(s.conf.DrainGracePeriodMS) * time.Millisecond
+				if err := s.inventory.RemoveDrainedHosts(now, gracePeriod); err != nil {
+					s.logger.Error(err)
+				}
 				s.saveSnapshots()
 			case <-s.ctx.Done():
 				ticker.Stop()
@@ -113,13 +134,44 @@ func (s *xdss) saveSnapshots() error {
 			continue
 		}
 		for host, snapshot := range snapshots {
-			s.logger.Infof("set snapshot %s: %+v", host.Name, *snapshot)
-			err = s.snapshotCache.SetSnapshot(host.Name, *snapshot)
+			err = s.setSnapshot(host.Name, snapshot)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	allgw, err := s.inventory.GetGatewayNames()
+	if err != nil {
+		return err
+	}
+
+	for _, gw := range allgw {
+		snapshots, err := s.snapshotGen.MakeSnapshotsOfGateway(gw)
+		if err != nil {
+			s.logger.Errorf("failed to generate snapshot: gateway=%s", gw)
+			s.logger.Error(err)
+			continue
+		}
+		for node, snapshot := range snapshots {
+			err = s.setSnapshot(node, snapshot)
 			if err != nil {
-				return errors.Wrapf(err, "snapshot failed: %s=%+v of", host.Name, snapshot)
+				return err
 			}
 		}
 	}
 
 	return nil
 }
+
+func (s *xdss) setSnapshot(node string, snapshot *Snapshot) error {
+	s.logger.Infof("set snapshot %s: %+v", node, snapshot.Snapshot)
+	err := s.snapshotCache.SetSnapshot(node, snapshot.Snapshot)
+	if err != nil {
+		return errors.Wrapf(err, "snapshot failed: %s=%+v of", node, snapshot.Snapshot)
+	}
+	if len(snapshot.Secrets.Version) > 0 {
+		s.sdsServer.SetSecrets(node, snapshot.Secrets)
+	}
+	return nil
+}
@@ -2,9 +2,14 @@ package xds
 
 import (
 	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/cluster"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
@@ -14,6 +19,7 @@ import (
 	tcp "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/tcp_proxy/v2"
 	"github.com/envoyproxy/go-control-plane/pkg/cache"
 	"github.com/envoyproxy/go-control-plane/pkg/util"
+	"github.com/gogo/protobuf/types"
 	"github.com/pkg/errors"
 	mcore "github.com/rerorero/meshem/src/core"
 	"github.com/rerorero/meshem/src/model"
@@ -21,7 +27,14 @@ import (
 )
 
 type SnapshotGen interface {
-	MakeSnapshotsOfService(serviceName string) (snapshots map[*model.Host]*cache.Snapshot, err error)
+	MakeSnapshotsOfService(serviceName string) (snapshots map[*model.Host]*Snapshot, err error)
+	MakeSnapshotsOfGateway(gatewayName string) (snapshots map[string]*Snapshot, err error)
+}
+
+// Snapshot is the cached resources of a node. Secrets is empty if mutual TLS is disabled.
+type Snapshot struct {
+	cache.Snapshot
+	Secrets cache.Resources
 }
 
 type snapGen struct {
@@ -29,25 +42,44 @@ type snapGen struct {
 	logger     *logrus.Logger
 	versionGen mcore.VersionGenerator
 	envoyConf  model.EnvoyConf
+	mtls       *MTLS
 }
 
 const (
 	// XdsCluster is the cluster name for the control server (used by non-ADS set-up)
 	XdsCluster = "xds_cluster"
+	// GRPCHTTP1BridgeFilter is the name of the filter which bridges HTTP/1.1 clients to gRPC servers.
+	GRPCHTTP1BridgeFilter = "envoy.grpc_http1_bridge"
+	// FaultFilter is the name of the HTTP filter which injects faults.
+	FaultFilter = "envoy.fault"
+	// RetriableStatusCodesHeader is the request header to specify the status codes to retry.
+	RetriableStatusCodesHeader = "x-envoy-retriable-status-codes"
+)
+
+var (
+	redirectResponseCodes = map[uint32]route.RedirectAction_RedirectResponseCode{
+		0:   route.RedirectAction_MOVED_PERMANENTLY,
+		301: route.RedirectAction_MOVED_PERMANENTLY,
+		302: route.RedirectAction_FOUND,
+		303: route.RedirectAction_SEE_OTHER,
+		307: route.RedirectAction_TEMPORARY_REDIRECT,
+		308: route.RedirectAction_PERMANENT_REDIRECT,
+	}
 )
 
-// NewSnapshotGen creates snapshot generator instance.
-func NewSnapshotGen(is mcore.InventoryService, logger *logrus.Logger, vg mcore.VersionGenerator, envoyConf model.EnvoyConf) SnapshotGen {
+// NewSnapshotGen creates snapshot generator instance. mtls is nil if mutual TLS is disabled.
+func NewSnapshotGen(is mcore.InventoryService, logger *logrus.Logger, vg mcore.VersionGenerator, envoyConf model.EnvoyConf, mtls *MTLS) SnapshotGen {
 	return &snapGen{
 		inventory:  is,
 		logger:     logger,
 		versionGen: vg,
 		envoyConf:  envoyConf,
+		mtls:       mtls,
 	}
 }
 
 // FindSnapshotByName finds a snapshot by hostname from a snapshot map
-func FindSnapshotByName(snapshots map[*model.Host]*cache.Snapshot, hostname string) (*cache.Snapshot, bool) {
+func FindSnapshotByName(snapshots map[*model.Host]*Snapshot, hostname string) (*Snapshot, bool) {
 	for h, s := range snapshots {
 		if h.Name == hostname {
 			return s, true
@@ -56,7 +88,7 @@ func FindSnapshotByName(snapshots map[*model.Host]*cache.Snapshot, hostname stri
 	return nil, false
 }
 
-func (gen *snapGen) MakeSnapshotsOfService(serviceName string) (map[*model.Host]*cache.Snapshot, error) {
+func (gen *snapGen) MakeSnapshotsOfService(serviceName string) (map[*model.Host]*Snapshot, error) {
 	// get service
 	service, ok, err := gen.inventory.GetService(serviceName)
 	if err != nil {
@@ -66,23 +98,34 @@ func (gen *snapGen) MakeSnapshotsOfService(serviceName string) (map[*model.Host]
 		return nil, fmt.Errorf("service=%s not found", serviceName)
 	}
 
-	// get service dependencies
+	// get service dependencies and the services which their routes refer to
 	dependencies := map[*model.Service][]model.Host{}
+	fetched := map[string]bool{}
 	var i int
 	for i = 0; i < len(service.DependentServices); i++ {
-		name := service.DependentServices[i].Name
-		depHosts, err := gen.inventory.GetHostsOfService(name)
-		if err != nil {
-			return nil, err
+		// the service may be depended on through its multiple ports
+		if fetched[service.DependentServices[i].Name] {
+			continue
 		}
-		depService, ok, err := gen.inventory.GetService(name)
+		depService, depHosts, err := gen.getUpstreamService(service.DependentServices[i].Name)
 		if err != nil {
 			return nil, err
 		}
-		if !ok {
-			return nil, fmt.Errorf("depndencies of %s not found", name)
-		}
 		dependencies[&depService] = depHosts
+		fetched[depService.Name] = true
+	}
+	for dep := range dependencies {
+		for _, name := range dep.RouteTargetServiceNames() {
+			if fetched[name] {
+				continue
+			}
+			targetService, targetHosts, err := gen.getUpstreamService(name)
+			if err != nil {
+				return nil, err
+			}
+			dependencies[&targetService] = targetHosts
+			fetched[name] = true
+		}
 	}
 
 	hosts, err := gen.inventory.GetHostsOfService(service.Name)
@@ -90,7 +133,7 @@ func (gen *snapGen) MakeSnapshotsOfService(serviceName string) (map[*model.Host]
 		return nil, err
 	}
 
-	snapshots := map[*model.Host]*cache.Snapshot{}
+	snapshots := map[*model.Host]*Snapshot{}
 	for i = 0; i < len(hosts); i++ {
 		snapshot, err := gen.makeHostSnapshot(&service, &hosts[i], dependencies)
 		if err != nil {
@@ -98,120 +141,259 @@ func (gen *snapGen) MakeSnapshotsOfService(serviceName string) (map[*model.Host]
 		}
 		err = snapshot.Consistent()
 		if err != nil {
-			return nil, errors.Wrapf(err, "snapshot incosistency: %+v", snapshot)
+			return nil, errors.Wrapf(err, "snapshot incosistency: %+v", snapshot.Snapshot)
 		}
 		snapshots[&hosts[i]] = snapshot
 	}
 	return snapshots, nil
 }
 
-func (gen *snapGen) makeHostSnapshot(service *model.Service, host *model.Host, dependencies map[*model.Service][]model.Host) (*cache.Snapshot, error) {
+func (gen *snapGen) getUpstreamService(name string) (model.Service, []model.Host, error) {
+	hosts, err := gen.inventory.GetHostsOfService(name)
+	if err != nil {
+		return model.Service{}, nil, err
+	}
+	service, ok, err := gen.inventory.GetService(name)
+	if err != nil {
+		return service, nil, err
+	}
+	if !ok {
+		return service, nil, fmt.Errorf("depndencies of %s not found", name)
+	}
+	return service, hosts, nil
+}
+
+func (gen *snapGen) makeHostSnapshot(service *model.Service, host *model.Host, dependencies map[*model.Service][]model.Host) (*Snapshot, error) {
 	clusters := []cache.Resource{}
 	endpoints := []cache.Resource{}
 	routes := []cache.Resource{}
 	listeners := []cache.Resource{}
-	defaultTimeout := time.Duration(gen.envoyConf.ClusterTimeoutMS) * time.Millisecond
 
 	// version of the data to be cached
-	version := gen.latestNodeVersion(service, dependencies)
-
-	// ingress
+	version := string(gen.latestNodeVersion(service.Version, dependencies))
 	ingressClusterName := "ingress"
-	clusters = append(clusters, MakeEDSCluster(ingressClusterName, defaultTimeout))
-	endpoints = append(endpoints, MakeEndpoint(ingressClusterName, []model.Address{host.SubstanceAddr}))
 
-	listenerName := fmt.Sprintf("listener-%s-%s", ingressClusterName, host.IngressAddr.ListenerSuffix())
-	switch service.Protocol {
-	case model.ProtocolHTTP:
-		ingressRouteName := "route-" + ingressClusterName
-		routes = append(routes, MakeRoute(ingressRouteName, ingressClusterName, service.TraceSpan))
-		l, err := MakeHTTPListener(&httpListenerParam{
-			listenerName: listenerName,
-			address:      &host.IngressAddr,
-			route:        ingressRouteName,
-			statPrefix:   ingressClusterName,
-			logfileDir:   gen.envoyConf.AccessLogDir,
-			logfileName:  ingressClusterName + ".log",
-			health:       NewDisabledHTTPHealthCheck(),
-			isIngress:    true,
-			traceEnabled: len(service.TraceSpan) > 0,
-		})
-		if err != nil {
-			return nil, err
-		}
-		listeners = append(listeners, l)
-	case model.ProtocolTCP:
-		l, err := MakeTCPListener(listenerName, host.IngressAddr, ingressClusterName, ingressClusterName, gen.envoyConf.AccessLogDir, ingressClusterName+".log")
+	// ingress of the default port and the named ports
+	c, e, r, l, err := gen.makeIngressResources(service, host, ingressClusterName, host.IngressAddr, host.SubstanceAddr)
+	if err != nil {
+		return nil, err
+	}
+	clusters, endpoints, routes, listeners = append(clusters, c...), append(endpoints, e...), append(routes, r...), append(listeners, l...)
+	var i int
+	for i = 0; i < len(service.Ports); i++ {
+		port := &service.Ports[i]
+		portService := service.PortService(port)
+		ingressAddr := model.Address{Hostname: host.IngressAddr.Hostname, Port: port.IngressPort}
+		substanceAddr := model.Address{Hostname: host.SubstanceAddr.Hostname, Port: port.SubstancePort}
+		c, e, r, l, err := gen.makeIngressResources(&portService, host, PortClusterName(ingressClusterName, port.Name), ingressAddr, substanceAddr)
 		if err != nil {
 			return nil, err
 		}
-		listeners = append(listeners, l)
-	default:
-		return nil, fmt.Errorf("%s provides unsupported protocol=%s", service.Name, service.Protocol)
+		clusters, endpoints, routes, listeners = append(clusters, c...), append(endpoints, e...), append(routes, r...), append(listeners, l...)
+	}
+
+	// upstream clusters of the dependent services and the route targets
+	upstreams := map[string]*model.Service{}
+	upstreamHosts := map[string][]model.Host{}
+	for upsvc, uphosts := range dependencies {
+		upstreams[upsvc.Name] = gen.populatedSubsets(upsvc, uphosts)
+		upstreamHosts[upsvc.Name] = uphosts
+		c, e := gen.makeUpstreamResources(host, upsvc, uphosts, gen.connectTimeout(egressTimeouts(service, upsvc)))
+		clusters = append(clusters, c...)
+		endpoints = append(endpoints, e...)
 	}
 
 	// egress(dependent services)
-	for depsvc, dephosts := range dependencies {
+	for i = 0; i < len(service.DependentServices); i++ {
+		ref := &service.DependentServices[i]
 		// integrity checking
-		ok, ref := service.FindDependentServiceName(depsvc.Name)
+		depsvc, ok := upstreams[ref.Name]
 		if !ok {
-			return nil, fmt.Errorf("service and its dependencies state are not matched, %s is not found in dependencies", depsvc.Name)
+			return nil, fmt.Errorf("service and its dependencies state are not matched, %s is not found in dependencies", ref.Name)
 		}
 
-		egressClusterName := "egress-" + depsvc.Name
-		clusters = append(clusters, MakeEDSCluster(egressClusterName, defaultTimeout))
-
-		depAddresses := make([]model.Address, len(dephosts))
-		var i int
-		for i = 0; i < len(dephosts); i++ {
-			depAddresses[i] = dephosts[i].IngressAddr
+		egressClusterName := EgressClusterName(depsvc.Name)
+		protocol := depsvc.Protocol
+		timeouts := depsvc.Timeouts.Override(ref.Timeouts)
+		if len(ref.Port) > 0 {
+			ok, port := depsvc.FindPort(ref.Port)
+			if !ok {
+				return nil, fmt.Errorf("port=%s is not defined in service=%s", ref.Port, depsvc.Name)
+			}
+			c, e := gen.makePortUpstreamResources(host, depsvc, port, upstreamHosts[depsvc.Name], gen.connectTimeout(timeouts))
+			clusters = append(clusters, c...)
+			endpoints = append(endpoints, e...)
+			egressClusterName = PortClusterName(egressClusterName, port.Name)
+			protocol = port.Protocol
 		}
-		endpoints = append(endpoints, MakeEndpoint(egressClusterName, depAddresses))
-
-		addrstr := fmt.Sprintf("%s:%d", host.EgressHost, ref.EgressPort)
+		addrstr := net.JoinHostPort(host.EgressHost, strconv.FormatUint(uint64(ref.EgressPort), 10))
 		addr, err := model.ParseAddress(addrstr)
 		if err != nil {
-			return nil, errors.Wrapf(err, "invalid address of the egress endpoint, svc=%s, host=%s, depend=%s, addr=%s", service.Name, host.Name, depsvc.Name, addrstr)
+			return nil, errors.Wrapf(err, "invalid address of the egress endpoint, svc=%s, host=%s, depend=%s, addr=%s", service.Name, host.Name, ref.Ref(), addrstr)
 		}
 
 		listenerName := fmt.Sprintf("listener-%s-%s", egressClusterName, addr.ListenerSuffix())
-		switch depsvc.Protocol {
-		case model.ProtocolHTTP:
+		idleTimeout := idleTimeoutOf(timeouts)
+		switch protocol {
+		case model.ProtocolHTTP, model.ProtocolGRPC:
 			egressRouteName := "route-" + egressClusterName
-			routes = append(routes, MakeRoute(egressRouteName, egressClusterName, depsvc.TraceSpan))
+			r, err := MakeEgressRoute(egressRouteName, ref, upstreams)
+			if err != nil {
+				return nil, err
+			}
+			// the variables are of the calling host
+			routes = append(routes, applyHeaderPolicy(r, ref.Headers, model.NewHeaderVariables(service, host)))
 			l, err := MakeHTTPListener(&httpListenerParam{
 				listenerName: listenerName,
+				protocol:     protocol,
 				address:      addr,
 				route:        egressRouteName,
 				statPrefix:   egressClusterName,
 				logfileDir:   gen.envoyConf.AccessLogDir,
 				logfileName:  egressClusterName + ".log",
 				health:       NewDisabledHTTPHealthCheck(),
+				fault:        activeFault(ref.Fault, time.Now()),
+				stripHeaders: requestHeadersToRemove(ref.Headers),
 				isIngress:    false,
 				traceEnabled: len(depsvc.TraceSpan) > 0,
+				idleTimeout:  idleTimeout,
 			})
 			if err != nil {
 				return nil, err
 			}
 			listeners = append(listeners, l)
 		case model.ProtocolTCP:
-			l, err := MakeTCPListener(listenerName, *addr, egressClusterName, egressClusterName, gen.envoyConf.AccessLogDir, egressClusterName+".log")
+			l, err := MakeTCPListener(listenerName, *addr, egressClusterName, egressClusterName, gen.envoyConf.AccessLogDir, egressClusterName+".log", idleTimeout)
 			if err != nil {
 				return nil, err
 			}
 			listeners = append(listeners, l)
 		default:
-			return nil, fmt.Errorf("%s provides unsupported protocol=%s", service.Name, service.Protocol)
+			return nil, fmt.Errorf("%s provides unsupported protocol=%s", ref.Ref(), protocol)
+		}
+	}
+
+	// secrets are versioned by the certificate of the host, only they are updated when it's rotated
+	var secrets cache.Resources
+	if gen.mtls != nil {
+		keyPair, err := gen.mtls.CA.Issue(host.Name, gen.mtls.Conf.SpiffeID(service.Name))
+		if err != nil {
+			return nil, err
 		}
+		secrets = gen.mtls.makeSecrets(fmt.Sprintf("%s-%s", version, keyPair.Serial), keyPair, upstreams)
+	}
+
+	return &Snapshot{
+		Snapshot: cache.NewSnapshot(version, endpoints, clusters, routes, listeners),
+		Secrets:  secrets,
+	}, nil
+}
+
+// makeIngressResources creates the resources which proxy the traffic from the ingress address to the substance of the host.
+func (gen *snapGen) makeIngressResources(service *model.Service, host *model.Host, clusterName string, ingressAddr model.Address, substanceAddr model.Address) (clusters, endpoints, routes, listeners []cache.Resource, err error) {
+	cluster := applyProtocolOptions(MakeEDSCluster(clusterName, gen.connectTimeout(service.Timeouts)), service.Protocol)
+	clusters = append(clusters, applyActiveHealthCheck(cluster, service.HealthCheck, service.Protocol))
+	endpoints = append(endpoints, MakeEndpoint(clusterName, []model.Address{substanceAddr}))
+
+	listenerName := fmt.Sprintf("listener-%s-%s", clusterName, ingressAddr.ListenerSuffix())
+	var listener *v2.Listener
+	switch service.Protocol {
+	case model.ProtocolHTTP, model.ProtocolGRPC:
+		routeName := "route-" + clusterName
+		r := MakeRoute(routeName, clusterName, service.TraceSpan, service.Timeouts)
+		routes = append(routes, applyHeaderPolicy(r, service.Headers, model.NewHeaderVariables(service, host)))
+		listener, err = MakeHTTPListener(&httpListenerParam{
+			listenerName: listenerName,
+			protocol:     service.Protocol,
+			address:      &ingressAddr,
+			route:        routeName,
+			statPrefix:   clusterName,
+			logfileDir:   gen.envoyConf.AccessLogDir,
+			logfileName:  clusterName + ".log",
+			health:       NewHTTPHealthCheck(service.HealthCheckFilter),
+			rateLimit:    service.RateLimit,
+			stripHeaders: requestHeadersToRemove(service.Headers),
+			isIngress:    true,
+			traceEnabled: len(service.TraceSpan) > 0,
+			idleTimeout:  idleTimeoutOf(service.Timeouts),
+		})
+	case model.ProtocolTCP:
+		listener, err = MakeTCPListener(listenerName, ingressAddr, clusterName, clusterName, gen.envoyConf.AccessLogDir, clusterName+".log", idleTimeoutOf(service.Timeouts))
+		if err == nil && service.RateLimit != nil {
+			err = applyTCPRateLimit(listener, host.Name, clusterName, service.RateLimit)
+		}
+	default:
+		err = fmt.Errorf("%s provides unsupported protocol=%s", service.Name, service.Protocol)
+	}
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if gen.mtls != nil {
+		listener = gen.mtls.applyDownstreamTLS(listener, service.Protocol)
 	}
+	listeners = append(listeners, listener)
+	return clusters, endpoints, routes, listeners, nil
+}
 
-	snapshot := cache.NewSnapshot(string(version), endpoints, clusters, routes, listeners)
-	return &snapshot, nil
+// makeUpstreamResources creates the clusters and the endpoints of the upstream service from the caller's point of view.
+func (gen *snapGen) makeUpstreamResources(caller *model.Host, upsvc *model.Service, uphosts []model.Host, connectTimeout time.Duration) (clusters []cache.Resource, endpoints []cache.Resource) {
+	egressClusterName := EgressClusterName(upsvc.Name)
+	if upsvc.IsExternal() {
+		// the endpoints are resolved by envoy
+		return []cache.Resource{gen.makeEgressCluster(egressClusterName, connectTimeout, upsvc)}, nil
+	}
+	uphosts = labeledHosts(upsvc, uphosts)
+	if len(upsvc.Subsets) == 0 {
+		clusters = append(clusters, gen.makeEgressCluster(egressClusterName, connectTimeout, upsvc))
+		endpoints = append(endpoints, MakeLocalityEndpoint(egressClusterName, caller, uphosts, nil))
+		return clusters, endpoints
+	}
+	// one cluster per subset
+	for _, subset := range upsvc.Subsets {
+		subsetName := subset.Name
+		subsetClusterName := SubsetClusterName(egressClusterName, subsetName)
+		clusters = append(clusters, gen.makeEgressCluster(subsetClusterName, connectTimeout, upsvc))
+		endpoints = append(endpoints, MakeLocalityEndpoint(subsetClusterName, caller, uphosts, func(h *model.Host) bool {
+			return h.Subset == subsetName
+		}))
+	}
+	return clusters, endpoints
+}
+
+// populatedSubsets returns the upstream service whose subsets without any hosts to route are weighted zero,
+// so that their share of the requests is not sent to the empty clusters. It's returned as is if none of the subsets have the hosts.
+func (gen *snapGen) populatedSubsets(upsvc *model.Service, uphosts []model.Host) *model.Service {
+	if len(upsvc.Subsets) == 0 {
+		return upsvc
+	}
+	populated := map[string]bool{}
+	for _, h := range uphosts {
+		if !h.IsInMaintenance() {
+			populated[h.Subset] = true
+		}
+	}
+	subsets := make([]model.SubsetWeight, len(upsvc.Subsets))
+	var total uint32
+	var i int
+	for i = 0; i < len(upsvc.Subsets); i++ {
+		subsets[i] = upsvc.Subsets[i]
+		if !populated[subsets[i].Name] && subsets[i].Weight > 0 {
+			gen.logger.Warnf("subset=%s of service=%s has no hosts, its weight is ignored", subsets[i].Name, upsvc.Name)
+			subsets[i].Weight = 0
+		}
+		total += subsets[i].Weight
+	}
+	if total == 0 {
+		return upsvc
+	}
+	copied := *upsvc
+	copied.Subsets = subsets
+	return &copied
 }
 
 // latestNodeVersion determines the version of the cache data of the node. It selects the latest from the all related service version.
-func (gen *snapGen) latestNodeVersion(service *model.Service, depndencies map[*model.Service][]model.Host) model.Version {
-	latest := service.Version
+func (gen *snapGen) latestNodeVersion(base model.Version, depndencies map[*model.Service][]model.Host) model.Version {
+	latest := base
 	for dep := range depndencies {
 		if gen.versionGen.Compare(latest, dep.Version) > 0 {
 			latest = dep.Version
@@ -220,6 +402,58 @@ func (gen *snapGen) latestNodeVersion(service *model.Service, depndencies map[*m
 	return latest
 }
 
+// connectTimeout returns the connect timeout of the cluster. The global setting is used if it's not overridden.
+func (gen *snapGen) connectTimeout(timeouts *model.Timeouts) time.Duration {
+	if timeouts != nil && timeouts.ConnectTimeoutMS > 0 {
+		return time.Duration(timeouts.ConnectTimeoutMS) * time.Millisecond
+	}
+	return time.Duration(gen.envoyConf.ClusterTimeoutMS) * time.Millisecond
+}
+
+// egressTimeouts returns the timeouts of the upstream service overridden by the dependency of the service.
+func egressTimeouts(service *model.Service, upstream *model.Service) *model.Timeouts {
+	var i int
+	for i = 0; i < len(service.DependentServices); i++ {
+		if service.DependentServices[i].Name == upstream.Name && len(service.DependentServices[i].Port) == 0 {
+			return upstream.Timeouts.Override(service.DependentServices[i].Timeouts)
+		}
+	}
+	return upstream.Timeouts.Override(nil)
+}
+
+func requestTimeoutOf(timeouts *model.Timeouts) *time.Duration {
+	if timeouts == nil {
+		return nil
+	}
+	return millisToDuration(timeouts.RequestTimeoutMS)
+}
+
+func idleTimeoutOf(timeouts *model.Timeouts) *time.Duration {
+	if timeouts == nil {
+		return nil
+	}
+	return millisToDuration(timeouts.IdleTimeoutMS)
+}
+
+// millisToDuration returns nil if ms is zero so that envoy uses its default.
+func millisToDuration(ms uint32) *time.Duration {
+	if ms == 0 {
+		return nil
+	}
+	d := time.Duration(ms) * time.Millisecond
+	return &d
+}
+
+// EgressClusterName returns the name of the cluster which consists of the hosts of the upstream service.
+func EgressClusterName(serviceName string) string {
+	return "egress-" + serviceName
+}
+
+// SubsetClusterName returns the name of the cluster which consists of the hosts in the subset.
+func SubsetClusterName(clusterName string, subset string) string {
+	return fmt.Sprintf("%s~%s", clusterName, subset)
+}
+
 // MakeEDSCluster creates a EDS cluster.
 func MakeEDSCluster(clusterName string, timeout time.Duration) *v2.Cluster {
 	edsSource := &core.ConfigSource{
@@ -241,26 +475,81 @@ func MakeEDSCluster(clusterName string, timeout time.Duration) *v2.Cluster {
 	}
 }
 
+// makeEgressCluster creates an EDS cluster with the policies of the upstream service.
+// A DNS cluster is created instead if the upstream is an external service.
+func (gen *snapGen) makeEgressCluster(clusterName string, timeout time.Duration, upstream *model.Service) *v2.Cluster {
+	var c *v2.Cluster
+	if upstream.IsExternal() {
+		c = MakeDNSCluster(clusterName, timeout, upstream.External)
+	} else {
+		c = MakeEDSCluster(clusterName, timeout)
+	}
+	c = applyProtocolOptions(c, upstream.Protocol)
+	c = applyLoadBalancer(c, upstream.LoadBalancer)
+	c = applyResiliencePolicy(c, upstream.Resilience)
+	c = applyActiveHealthCheck(c, upstream.HealthCheck, upstream.Protocol)
+	switch {
+	case upstream.IsExternal():
+		c = applyExternalTLS(c, upstream.External, upstream.Protocol)
+	case gen.mtls != nil:
+		c = gen.mtls.applyUpstreamTLS(c, upstream)
+	}
+	return c
+}
+
+// applyProtocolOptions makes the cluster speak HTTP/2 to the upstream if the protocol requires.
+func applyProtocolOptions(c *v2.Cluster, protocol string) *v2.Cluster {
+	if protocol == model.ProtocolGRPC {
+		c.Http2ProtocolOptions = &core.Http2ProtocolOptions{}
+	}
+	return c
+}
+
+// applyResiliencePolicy sets the circuit breaker and the outlier detection of the policy to the cluster.
+func applyResiliencePolicy(c *v2.Cluster, policy *model.ResiliencePolicy) *v2.Cluster {
+	if policy == nil {
+		return c
+	}
+	if cb := policy.CircuitBreaker; cb != nil {
+		c.CircuitBreakers = &cluster.CircuitBreakers{
+			Thresholds: []*cluster.CircuitBreakers_Thresholds{{
+				Priority:           core.RoutingPriority_DEFAULT,
+				MaxConnections:     uint32ValueOf(cb.MaxConnections),
+				MaxPendingRequests: uint32ValueOf(cb.MaxPendingRequests),
+				MaxRequests:        uint32ValueOf(cb.MaxRequests),
+				MaxRetries:         uint32ValueOf(cb.MaxRetries),
+			}},
+		}
+	}
+	if od := policy.OutlierDetection; od != nil {
+		c.OutlierDetection = &cluster.OutlierDetection{
+			Consecutive_5Xx:    uint32ValueOf(od.Consecutive5xx),
+			MaxEjectionPercent: uint32ValueOf(od.MaxEjectionPercent),
+		}
+		if od.IntervalMS > 0 {
+			c.OutlierDetection.Interval = types.DurationProto(time.Duration(od.IntervalMS) * time.Millisecond)
+		}
+		if od.BaseEjectionTimeMS > 0 {
+			c.OutlierDetection.BaseEjectionTime = types.DurationProto(time.Duration(od.BaseEjectionTimeMS) * time.Millisecond)
+		}
+	}
+	return c
+}
+
+// uint32ValueOf returns nil if v is zero so that envoy uses its default.
+func uint32ValueOf(v uint32) *types.UInt32Value {
+	if v == 0 {
+		return nil
+	}
+	return &types.UInt32Value{Value: v}
+}
+
 // MakeEndpoint creates a endpoint on a given address.
 func MakeEndpoint(clusterName string, addresses []model.Address) *v2.ClusterLoadAssignment {
 	endpoints := make([]endpoint.LbEndpoint, len(addresses))
 	var i int
 	for i = 0; i < len(addresses); i++ {
-		endpoints[i] = endpoint.LbEndpoint{
-			Endpoint: &endpoint.Endpoint{
-				Address: &core.Address{
-					Address: &core.Address_SocketAddress{
-						SocketAddress: &core.SocketAddress{
-							Protocol: core.TCP,
-							Address:  addresses[i].Hostname,
-							PortSpecifier: &core.SocketAddress_PortValue{
-								PortValue: addresses[i].Port,
-							},
-						},
-					},
-				},
-			},
-		}
+		endpoints[i] = makeLbEndpoint(addresses[i])
 	}
 
 	return &v2.ClusterLoadAssignment{
@@ -271,48 +560,355 @@ func MakeEndpoint(clusterName string, addresses []model.Address) *v2.ClusterLoad
 	}
 }
 
+// MakeLocalityEndpoint creates an EDS resource of the ingresses of the hosts which satisfy pred, grouped by their locality.
+// The labels of the hosts are set to the metadata of the endpoints.
+// The hosts in maintenance are excluded and the draining hosts are marked so that they receive no new requests.
+// The priorities of the groups are relative to the locality of the caller, so that the caller prefers its own zone
+// and fails over to the others when the local hosts are unhealthy.
+func MakeLocalityEndpoint(clusterName string, caller *model.Host, hosts []model.Host, pred func(*model.Host) bool) *v2.ClusterLoadAssignment {
+	groups := []*endpoint.LocalityLbEndpoints{}
+	groupIndex := map[string]int{}
+	var i int
+	for i = 0; i < len(hosts); i++ {
+		h := &hosts[i]
+		// the hosts in maintenance receive no traffic
+		if h.IsInMaintenance() || (pred != nil && !pred(h)) {
+			continue
+		}
+		key := h.Region + "/" + h.Zone
+		index, ok := groupIndex[key]
+		if !ok {
+			var locality *core.Locality
+			if len(h.Region) > 0 {
+				locality = &core.Locality{Region: h.Region, Zone: h.Zone}
+			}
+			index = len(groups)
+			groupIndex[key] = index
+			groups = append(groups, &endpoint.LocalityLbEndpoints{
+				Locality: locality,
+				Priority: caller.LocalityPriority(h),
+			})
+		}
+		lbEndpoint := makeLbEndpoint(h.IngressAddr)
+		lbEndpoint.Metadata = makeLabelMetadata(h.Labels)
+		if h.Weight > 0 {
+			lbEndpoint.LoadBalancingWeight = &types.UInt32Value{Value: h.Weight}
+		}
+		if h.IsDraining() {
+			lbEndpoint.HealthStatus = core.HealthStatus_DRAINING
+		}
+		groups[index].LbEndpoints = append(groups[index].LbEndpoints, lbEndpoint)
+	}
+
+	// priorities must range from 0 without skipping
+	levels := []uint32{}
+	seen := map[uint32]bool{}
+	for _, g := range groups {
+		if !seen[g.Priority] {
+			seen[g.Priority] = true
+			levels = append(levels, g.Priority)
+		}
+	}
+	sort.Slice(levels, func(i, j int) bool { return levels[i] < levels[j] })
+	compacted := map[uint32]uint32{}
+	for i = 0; i < len(levels); i++ {
+		compacted[levels[i]] = uint32(i)
+	}
+
+	endpoints := make([]endpoint.LocalityLbEndpoints, len(groups))
+	for i = 0; i < len(groups); i++ {
+		groups[i].Priority = compacted[groups[i].Priority]
+		endpoints[i] = *groups[i]
+	}
+	sort.SliceStable(endpoints, func(i, j int) bool {
+		return endpoints[i].Priority < endpoints[j].Priority
+	})
+
+	return &v2.ClusterLoadAssignment{
+		ClusterName: clusterName,
+		Endpoints:   endpoints,
+	}
+}
+
+func makeLbEndpoint(addr model.Address) endpoint.LbEndpoint {
+	if addr.IsPipe() {
+		return endpoint.LbEndpoint{
+			Endpoint: &endpoint.Endpoint{
+				Address: &core.Address{
+					Address: &core.Address_Pipe{
+						Pipe: &core.Pipe{Path: addr.Path},
+					},
+				},
+			},
+		}
+	}
+	return endpoint.LbEndpoint{
+		Endpoint: &endpoint.Endpoint{
+			Address: &core.Address{
+				Address: &core.Address_SocketAddress{
+					SocketAddress: &core.SocketAddress{
+						Protocol: core.TCP,
+						Address:  addr.Hostname,
+						PortSpecifier: &core.SocketAddress_PortValue{
+							PortValue: addr.Port,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
 // MakeRoute creates an HTTP route that routes to a given cluster.
-func MakeRoute(routeName, clusterName string, traceSpan string) *v2.RouteConfiguration {
+func MakeRoute(routeName, clusterName string, traceSpan string, timeouts *model.Timeouts) *v2.RouteConfiguration {
+	return makeRouteConfiguration(routeName, []route.Route{
+		makeDefaultRoute(&route.RouteAction{
+			ClusterSpecifier: &route.RouteAction_Cluster{
+				Cluster: clusterName,
+			},
+			Timeout: requestTimeoutOf(timeouts),
+		}, traceSpan),
+	})
+}
+
+// MakeEgressRoute creates an HTTP route to the dependent service. The routes of the service precede the default route.
+// upstreams must contain the dependent service and all of the services which its routes refer to.
+func MakeEgressRoute(routeName string, dependency *model.DependentService, upstreams map[string]*model.Service) (*v2.RouteConfiguration, error) {
+	upstream, ok := upstreams[dependency.Name]
+	if !ok {
+		return nil, fmt.Errorf("dependent service=%s is not found", dependency.Name)
+	}
+
 	var decorater *route.Decorator
-	if len(traceSpan) > 0 {
-		decorater = &route.Decorator{Operation: traceSpan}
+	if len(upstream.TraceSpan) > 0 {
+		decorater = &route.Decorator{Operation: upstream.TraceSpan}
+	}
+
+	routes := []route.Route{}
+	var i int
+	if len(dependency.Port) > 0 {
+		// the route rules and the subsets of the upstream are defined on its default port
+		routes = append(routes, makeDefaultRoute(&route.RouteAction{
+			ClusterSpecifier: &route.RouteAction_Cluster{
+				Cluster: PortClusterName(EgressClusterName(upstream.Name), dependency.Port),
+			},
+			HashPolicy: makeHashPolicy(upstream.LoadBalancer),
+		}, upstream.TraceSpan))
+	} else {
+		for i = 0; i < len(upstream.Routes); i++ {
+			r, err := makeRouteRule(&upstream.Routes[i], upstream.Name, upstreams)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to make route rule[%d] of %s", i, upstream.Name)
+			}
+			r.Decorator = decorater
+			routes = append(routes, r)
+		}
+		routes = append(routes, makeDefaultRoute(upstreamRouteAction(upstream, ""), upstream.TraceSpan))
+	}
+
+	// apply the policies of the dependency
+	requestTimeout := requestTimeoutOf(upstream.Timeouts.Override(dependency.Timeouts))
+	for i = 0; i < len(routes); i++ {
+		action, ok := routes[i].Action.(*route.Route_Route)
+		if !ok {
+			// redirect
+			continue
+		}
+		action.Route.Timeout = requestTimeout
+		if dependency.Retry != nil {
+			action.Route.RetryPolicy = makeRetryPolicy(dependency.Retry)
+			if len(dependency.Retry.RetriableStatusCodes) > 0 {
+				routes[i].RequestHeadersToAdd = append(routes[i].RequestHeadersToAdd, makeRetriableStatusCodesHeader(dependency.Retry.RetriableStatusCodes))
+			}
+		}
 	}
 
+	return makeRouteConfiguration(routeName, routes), nil
+}
+
+func makeRetryPolicy(policy *model.RetryPolicy) *route.RouteAction_RetryPolicy {
+	retry := &route.RouteAction_RetryPolicy{
+		RetryOn:    strings.Join(policy.RetryOn, ","),
+		NumRetries: uint32ValueOf(policy.NumRetries),
+	}
+	if policy.PerTryTimeoutMS > 0 {
+		perTryTimeout := time.Duration(policy.PerTryTimeoutMS) * time.Millisecond
+		retry.PerTryTimeout = &perTryTimeout
+	}
+	return retry
+}
+
+// makeRetriableStatusCodesHeader passes the retriable status codes to the router by the header
+// since RetryPolicy of the API version we use doesn't have the field for them.
+func makeRetriableStatusCodesHeader(codes []uint32) *core.HeaderValueOption {
+	strs := make([]string, len(codes))
+	var i int
+	for i = 0; i < len(codes); i++ {
+		strs[i] = strconv.FormatUint(uint64(codes[i]), 10)
+	}
+	return &core.HeaderValueOption{
+		Header: &core.HeaderValue{
+			Key:   RetriableStatusCodesHeader,
+			Value: strings.Join(strs, ","),
+		},
+		Append: &types.BoolValue{Value: false},
+	}
+}
+
+func makeRouteConfiguration(routeName string, routes []route.Route) *v2.RouteConfiguration {
 	return &v2.RouteConfiguration{
 		Name: routeName,
 		VirtualHosts: []route.VirtualHost{{
 			Name:    routeName,
 			Domains: []string{"*"},
-			Routes: []route.Route{{
-				Match: route.RouteMatch{
-					PathSpecifier: &route.RouteMatch_Prefix{
-						Prefix: "/",
-					},
-				},
-				Action: &route.Route_Route{
-					Route: &route.RouteAction{
-						ClusterSpecifier: &route.RouteAction_Cluster{
-							Cluster: clusterName,
-						},
-					},
-				},
-				Decorator: decorater,
-			}},
+			Routes:  routes,
 		}},
 	}
 }
 
+func makeDefaultRoute(action *route.RouteAction, traceSpan string) route.Route {
+	var decorater *route.Decorator
+	if len(traceSpan) > 0 {
+		decorater = &route.Decorator{Operation: traceSpan}
+	}
+
+	return route.Route{
+		Match: route.RouteMatch{
+			PathSpecifier: &route.RouteMatch_Prefix{
+				Prefix: "/",
+			},
+		},
+		Action: &route.Route_Route{
+			Route: action,
+		},
+		Decorator: decorater,
+	}
+}
+
+// makeRouteRule creates a route of the rule, self is the service to which the rule routes if the rule doesn't specify it.
+func makeRouteRule(rule *model.RouteRule, self string, upstreams map[string]*model.Service) (route.Route, error) {
+	r := route.Route{}
+
+	// match
+	switch {
+	case len(rule.Prefix) > 0:
+		r.Match.PathSpecifier = &route.RouteMatch_Prefix{Prefix: rule.Prefix}
+	case len(rule.Path) > 0:
+		r.Match.PathSpecifier = &route.RouteMatch_Path{Path: rule.Path}
+	case len(rule.Regex) > 0:
+		r.Match.PathSpecifier = &route.RouteMatch_Regex{Regex: rule.Regex}
+	}
+	for _, h := range rule.Headers {
+		matcher := &route.HeaderMatcher{Name: h.Name}
+		switch {
+		case len(h.Value) == 0:
+			matcher.HeaderMatchSpecifier = &route.HeaderMatcher_PresentMatch{PresentMatch: true}
+		case h.Regex:
+			matcher.HeaderMatchSpecifier = &route.HeaderMatcher_RegexMatch{RegexMatch: h.Value}
+		default:
+			matcher.HeaderMatchSpecifier = &route.HeaderMatcher_ExactMatch{ExactMatch: h.Value}
+		}
+		r.Match.Headers = append(r.Match.Headers, matcher)
+	}
+
+	// redirect
+	if rule.Redirect != nil {
+		code, ok := redirectResponseCodes[rule.Redirect.ResponseCode]
+		if !ok {
+			return r, fmt.Errorf("unsupported redirect response code: %d", rule.Redirect.ResponseCode)
+		}
+		redirect := &route.RedirectAction{
+			HostRedirect: rule.Redirect.Host,
+			ResponseCode: code,
+		}
+		if len(rule.Redirect.Path) > 0 {
+			redirect.PathRewriteSpecifier = &route.RedirectAction_PathRedirect{PathRedirect: rule.Redirect.Path}
+		}
+		r.Action = &route.Route_Redirect{Redirect: redirect}
+		return r, nil
+	}
+
+	// route
+	targetName := rule.TargetService(self)
+	target, ok := upstreams[targetName]
+	if !ok {
+		return r, fmt.Errorf("route target service=%s is not found", targetName)
+	}
+	if len(rule.Subset) > 0 {
+		if ok, _ := target.FindSubset(rule.Subset); !ok {
+			return r, fmt.Errorf("route target subset=%s is not defined in %s", rule.Subset, targetName)
+		}
+	}
+	action := upstreamRouteAction(target, rule.Subset)
+	action.PrefixRewrite = rule.PrefixRewrite
+	r.Action = &route.Route_Route{Route: action}
+	return r, nil
+}
+
+// upstreamRouteAction creates a route action to the upstream service. The traffic is split by the weights if the service has subsets.
+// The hash policy of the upstream is also applied since it's configured on the routes.
+func upstreamRouteAction(upstream *model.Service, subset string) *route.RouteAction {
+	action := clusterRouteAction(upstream, subset)
+	action.HashPolicy = makeHashPolicy(upstream.LoadBalancer)
+	return action
+}
+
+func clusterRouteAction(upstream *model.Service, subset string) *route.RouteAction {
+	clusterName := EgressClusterName(upstream.Name)
+	if len(subset) > 0 {
+		return &route.RouteAction{
+			ClusterSpecifier: &route.RouteAction_Cluster{
+				Cluster: SubsetClusterName(clusterName, subset),
+			},
+		}
+	}
+	if len(upstream.Subsets) == 0 {
+		return &route.RouteAction{
+			ClusterSpecifier: &route.RouteAction_Cluster{
+				Cluster: clusterName,
+			},
+		}
+	}
+
+	weights := []*route.WeightedCluster_ClusterWeight{}
+	var total uint32
+	var i int
+	for i = 0; i < len(upstream.Subsets); i++ {
+		if upstream.Subsets[i].Weight == 0 {
+			continue
+		}
+		weights = append(weights, &route.WeightedCluster_ClusterWeight{
+			Name:   SubsetClusterName(clusterName, upstream.Subsets[i].Name),
+			Weight: &types.UInt32Value{Value: upstream.Subsets[i].Weight},
+		})
+		total += upstream.Subsets[i].Weight
+	}
+	return &route.RouteAction{
+		ClusterSpecifier: &route.RouteAction_WeightedClusters{
+			WeightedClusters: &route.WeightedCluster{
+				Clusters:    weights,
+				TotalWeight: &types.UInt32Value{Value: total},
+			},
+		},
+	}
+}
+
 type httpListenerParam struct {
 	listenerName string
+	protocol     string
 	address      *model.Address
 	route        string
 	statPrefix   string
 	logfileDir   string
 	logfileName  string
 	health       *HTTPHealthCheck
+	rateLimit    *model.RateLimit
+	fault        *model.FaultPolicy
+	stripHeaders []string
 	isIngress    bool
 	traceEnabled bool
 	// TODO: more trace settings
+	idleTimeout *time.Duration
 }
 
 // MakeHTTPListener creates a listener using either ADS or RDS for the route.
@@ -326,10 +922,8 @@ func MakeHTTPListener(p *httpListenerParam) (*v2.Listener, error) {
 		return nil, errors.Wrapf(err, "listnere FileAccessLog generation failed(%+v)", *p)
 	}
 
-	// HTTP filter configuration
-	httpFilters := []*hcm.HttpFilter{{
-		Name: cache.Router,
-	}}
+	// HTTP filter configuration, the router must be the last one
+	httpFilters := []*hcm.HttpFilter{}
 	if p.health.Enabled {
 		filter, err := p.health.createEnvoyHTTPFilter()
 		if err != nil {
@@ -337,6 +931,38 @@ func MakeHTTPListener(p *httpListenerParam) (*v2.Listener, error) {
 		}
 		httpFilters = append(httpFilters, filter)
 	}
+	if p.rateLimit != nil {
+		filter, err := makeHTTPRateLimitFilter(p.rateLimit)
+		if err != nil {
+			return nil, err
+		}
+		httpFilters = append(httpFilters, filter)
+	}
+	if len(p.stripHeaders) > 0 {
+		filter, err := makeHeaderRemovalFilter(p.stripHeaders)
+		if err != nil {
+			return nil, err
+		}
+		httpFilters = append(httpFilters, filter)
+	}
+	if p.fault != nil {
+		filter, err := makeFaultFilter(p.fault)
+		if err != nil {
+			return nil, err
+		}
+		httpFilters = append(httpFilters, filter)
+	}
+	codec := hcm.AUTO
+	if p.protocol == model.ProtocolGRPC {
+		codec = hcm.HTTP2
+		// the bridge filter also collects the stats of gRPC status of HTTP/2 requests
+		httpFilters = append(httpFilters, &hcm.HttpFilter{
+			Name: GRPCHTTP1BridgeFilter,
+		})
+	}
+	httpFilters = append(httpFilters, &hcm.HttpFilter{
+		Name: cache.Router,
+	})
 
 	// tracing
 	var tracing *hcm.HttpConnectionManager_Tracing
@@ -351,9 +977,10 @@ func MakeHTTPListener(p *httpListenerParam) (*v2.Listener, error) {
 
 	// HTTP connection manager configuration
 	manager := &hcm.HttpConnectionManager{
-		CodecType:  hcm.AUTO,
-		StatPrefix: p.statPrefix,
-		Tracing:    tracing,
+		CodecType:   codec,
+		StatPrefix:  p.statPrefix,
+		Tracing:     tracing,
+		IdleTimeout: p.idleTimeout,
 		RouteSpecifier: &hcm.HttpConnectionManager_Rds{
 			Rds: &hcm.Rds{
 				ConfigSource: core.ConfigSource{
@@ -402,19 +1029,20 @@ func MakeHTTPListener(p *httpListenerParam) (*v2.Listener, error) {
 }
 
 // MakeTCPListener creates a TCP listener for a cluster.
-func MakeTCPListener(listenerName string, address model.Address, clusterName string, statPrefix string, logfileDir string, logfileName string) (*v2.Listener, error) {
+func MakeTCPListener(listenerName string, address model.Address, clusterName string, statPrefix string, logfileDir string, logfileName string, idleTimeout *time.Duration) (*v2.Listener, error) {
 	// access log service configuration
 	alsConfig := &accesslog.FileAccessLog{
 		Path: logfileDir + "/" + logfileName,
 	}
 	alsConfigPbst, err := util.MessageToStruct(alsConfig)
 	if err != nil {
-		return nil, errors.Wrapf(err, "listnere FileAccessLog generation failed(name=%s, cluste=%s, addr=%+v, log=%s:%s)", listenerName, address, clusterName, logfileDir, logfileName)
+		return nil, errors.Wrapf(err, "listnere FileAccessLog generation failed(name=%s, addr=%+v, cluster=%s, log=%s:%s)", listenerName, address, clusterName, logfileDir, logfileName)
 	}
 	// TCP filter configuration
 	config := &tcp.TcpProxy{
-		StatPrefix: statPrefix,
-		Cluster:    clusterName,
+		StatPrefix:  statPrefix,
+		Cluster:     clusterName,
+		IdleTimeout: idleTimeout,
 		AccessLog: []*accesslog.AccessLog{{
 			Name:   "envoy.file_access_log",
 			Config: alsConfigPbst,
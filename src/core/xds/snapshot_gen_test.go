@@ -3,10 +3,21 @@ package xds
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	httpfault "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/fault/v2"
+	hc "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/health_check/v2"
+	lua "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/lua/v2"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	rl "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/rate_limit/v2"
+	tcp "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/tcp_proxy/v2"
+	"github.com/envoyproxy/go-control-plane/pkg/cache"
+	"github.com/envoyproxy/go-control-plane/pkg/util"
 	mcore "github.com/rerorero/meshem/src/core"
+	"github.com/rerorero/meshem/src/core/ca"
 	"github.com/rerorero/meshem/src/model"
 	"github.com/rerorero/meshem/src/repository"
 	"github.com/sirupsen/logrus"
@@ -25,7 +36,7 @@ func TestMakeSnapshot(t *testing.T) {
 		ClusterTimeoutMS: 2000,
 		AccessLogDir:     "/var/log/test",
 	}
-	sut := NewSnapshotGen(inventory, logrus.New(), gen, conf)
+	sut := NewSnapshotGen(inventory, logrus.New(), gen, conf, nil)
 
 	// register service
 	svcA := &model.Service{
@@ -108,3 +119,1586 @@ func TestMakeSnapshot(t *testing.T) {
 	}
 	assert.ElementsMatch(t, []string{"192.168.1.1:8080", "192.168.1.2:8080"}, egressBAddress)
 }
+
+func TestMakeSnapshotSubsets(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := mcore.NewCurrentTimeGenerator()
+	inventory := mcore.NewInventoryService(repo, nil, gen, logrus.New())
+	conf := model.EnvoyConf{
+		ClusterTimeoutMS: 2000,
+		AccessLogDir:     "/var/log/test",
+	}
+	sut := NewSnapshotGen(inventory, logrus.New(), gen, conf, nil)
+
+	front := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Hosts: []model.Host{{
+			Name:          "front1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+		DependentServices: []model.DependentService{{Name: "app", EgressPort: 9001}},
+	}
+	app := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Hosts: []model.Host{
+			{
+				Name:          "app1",
+				IngressAddr:   model.Address{Hostname: "192.168.1.1", Port: 80},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+				EgressHost:    "127.0.0.1",
+				Subset:        "v1",
+			},
+			{
+				Name:          "app2",
+				IngressAddr:   model.Address{Hostname: "192.168.1.2", Port: 80},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+				EgressHost:    "127.0.0.1",
+				Subset:        "v1",
+			},
+			{
+				Name:          "app3",
+				IngressAddr:   model.Address{Hostname: "192.168.1.3", Port: 80},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+				EgressHost:    "127.0.0.1",
+				Subset:        "v2",
+			},
+		},
+		Subsets: []model.SubsetWeight{
+			{Name: "v1", Weight: 90},
+			{Name: "v2", Weight: 10},
+		},
+	}
+	_, err := inventory.IdempotentService("app", app)
+	assert.NoError(t, err)
+	_, err = inventory.IdempotentService("front", front)
+	assert.NoError(t, err)
+
+	shots, err := sut.MakeSnapshotsOfService("front")
+	assert.NoError(t, err)
+	actual, ok := FindSnapshotByName(shots, "front1")
+	assert.True(t, ok)
+
+	// clusters
+	clusterNames := []string{}
+	for _, item := range actual.Clusters.Items {
+		clusterNames = append(clusterNames, item.(*v2.Cluster).Name)
+	}
+	assert.ElementsMatch(t, []string{"ingress", "egress-app~v1", "egress-app~v2"}, clusterNames)
+
+	// endpoints
+	endpoints := map[string][]string{}
+	for _, item := range actual.Endpoints.Items {
+		cla := item.(*v2.ClusterLoadAssignment)
+		for _, e := range cla.Endpoints[0].LbEndpoints {
+			endpoints[cla.ClusterName] = append(endpoints[cla.ClusterName], addr2str(e.Endpoint.Address))
+		}
+	}
+	assert.ElementsMatch(t, []string{"192.168.1.1:80", "192.168.1.2:80"}, endpoints["egress-app~v1"])
+	assert.ElementsMatch(t, []string{"192.168.1.3:80"}, endpoints["egress-app~v2"])
+
+	// routes
+	var egressRoute *v2.RouteConfiguration
+	for _, item := range actual.Routes.Items {
+		r := item.(*v2.RouteConfiguration)
+		if r.Name == "route-egress-app" {
+			egressRoute = r
+		}
+	}
+	assert.NotNil(t, egressRoute)
+	weighted := egressRoute.VirtualHosts[0].Routes[0].GetRoute().GetWeightedClusters()
+	assert.NotNil(t, weighted)
+	assert.Equal(t, uint32(100), weighted.TotalWeight.Value)
+	weights := map[string]uint32{}
+	for _, c := range weighted.Clusters {
+		weights[c.Name] = c.Weight.Value
+	}
+	assert.Equal(t, map[string]uint32{"egress-app~v1": 90, "egress-app~v2": 10}, weights)
+
+	// the subset without hosts is removed from the weighted clusters
+	app.Hosts[2].Subset = "v1"
+	_, err = inventory.IdempotentService("app", app)
+	assert.NoError(t, err)
+	shots, err = sut.MakeSnapshotsOfService("front")
+	assert.NoError(t, err)
+	actual, ok = FindSnapshotByName(shots, "front1")
+	assert.True(t, ok)
+	egressRoute = nil
+	for _, item := range actual.Routes.Items {
+		r := item.(*v2.RouteConfiguration)
+		if r.Name == "route-egress-app" {
+			egressRoute = r
+		}
+	}
+	assert.NotNil(t, egressRoute)
+	weighted = egressRoute.VirtualHosts[0].Routes[0].GetRoute().GetWeightedClusters()
+	assert.NotNil(t, weighted)
+	assert.Equal(t, uint32(90), weighted.TotalWeight.Value)
+	assert.Len(t, weighted.Clusters, 1)
+	assert.Equal(t, "egress-app~v1", weighted.Clusters[0].Name)
+}
+
+func TestMakeEgressRoute(t *testing.T) {
+	app := &model.Service{
+		Name:      "app",
+		Protocol:  model.ProtocolHTTP,
+		TraceSpan: "app",
+		Subsets:   []model.SubsetWeight{{Name: "v1", Weight: 80}, {Name: "v2", Weight: 20}},
+		Routes: []model.RouteRule{
+			{Prefix: "/v2/", Subset: "v2", PrefixRewrite: "/"},
+			{Path: "/search", Service: "search", Headers: []model.HeaderMatch{{Name: "x-beta", Value: "1"}}},
+			{Regex: "/legacy/.*", Redirect: &model.RouteRedirect{Host: "legacy.example.com", ResponseCode: 307}},
+		},
+	}
+	search := &model.Service{Name: "search", Protocol: model.ProtocolHTTP}
+	upstreams := map[string]*model.Service{"app": app, "search": search}
+
+	dependency := &model.DependentService{Name: "app", EgressPort: 9001}
+	actual, err := MakeEgressRoute("route-egress-app", dependency, upstreams)
+	assert.NoError(t, err)
+	assert.Equal(t, "route-egress-app", actual.Name)
+	routes := actual.VirtualHosts[0].Routes
+	assert.Equal(t, 4, len(routes))
+
+	// subset
+	assert.Equal(t, "/v2/", routes[0].Match.GetPrefix())
+	assert.Equal(t, "egress-app~v2", routes[0].GetRoute().GetCluster())
+	assert.Equal(t, "/", routes[0].GetRoute().PrefixRewrite)
+	assert.Equal(t, "app", routes[0].Decorator.Operation)
+	// other service
+	assert.Equal(t, "/search", routes[1].Match.GetPath())
+	assert.Equal(t, "egress-search", routes[1].GetRoute().GetCluster())
+	assert.Equal(t, "x-beta", routes[1].Match.Headers[0].Name)
+	assert.Equal(t, "1", routes[1].Match.Headers[0].GetExactMatch())
+	// redirect
+	assert.Equal(t, "/legacy/.*", routes[2].Match.GetRegex())
+	assert.Equal(t, "legacy.example.com", routes[2].GetRedirect().HostRedirect)
+	// default
+	assert.Equal(t, "/", routes[3].Match.GetPrefix())
+	assert.Equal(t, 2, len(routes[3].GetRoute().GetWeightedClusters().Clusters))
+
+	// unknown target
+	_, err = MakeEgressRoute("route-egress-app", dependency, map[string]*model.Service{"app": app})
+	assert.Error(t, err)
+}
+
+func TestMakeSnapshotRouteTargets(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := mcore.NewCurrentTimeGenerator()
+	inventory := mcore.NewInventoryService(repo, nil, gen, logrus.New())
+	sut := NewSnapshotGen(inventory, logrus.New(), gen, model.EnvoyConf{ClusterTimeoutMS: 2000, AccessLogDir: "/var/log/test"}, nil)
+
+	search := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Hosts: []model.Host{{
+			Name:          "search1",
+			IngressAddr:   model.Address{Hostname: "192.168.2.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+	}
+	app := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Hosts: []model.Host{{
+			Name:          "app1",
+			IngressAddr:   model.Address{Hostname: "192.168.1.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+		Routes: []model.RouteRule{{Prefix: "/search", Service: "search"}},
+	}
+	front := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Hosts: []model.Host{{
+			Name:          "front1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+		DependentServices: []model.DependentService{{Name: "app", EgressPort: 9001}},
+	}
+	_, err := inventory.IdempotentService("search", search)
+	assert.NoError(t, err)
+	_, err = inventory.IdempotentService("app", app)
+	assert.NoError(t, err)
+	_, err = inventory.IdempotentService("front", front)
+	assert.NoError(t, err)
+
+	shots, err := sut.MakeSnapshotsOfService("front")
+	assert.NoError(t, err)
+	actual, ok := FindSnapshotByName(shots, "front1")
+	assert.True(t, ok)
+
+	// the cluster of search service is generated but its listener is not
+	clusterNames := []string{}
+	for _, item := range actual.Clusters.Items {
+		clusterNames = append(clusterNames, item.(*v2.Cluster).Name)
+	}
+	assert.ElementsMatch(t, []string{"ingress", "egress-app", "egress-search"}, clusterNames)
+	assert.Equal(t, 2, len(actual.Listeners.Items))
+	assert.Equal(t, 3, len(actual.Endpoints.Items))
+}
+
+func TestMakeEgressRouteRetry(t *testing.T) {
+	app := &model.Service{
+		Name:     "app",
+		Protocol: model.ProtocolHTTP,
+		Routes: []model.RouteRule{
+			{Prefix: "/old", Redirect: &model.RouteRedirect{Path: "/new"}},
+		},
+	}
+	upstreams := map[string]*model.Service{"app": app}
+
+	// without retry policy
+	dependency := &model.DependentService{Name: "app", EgressPort: 9001}
+	actual, err := MakeEgressRoute("route-egress-app", dependency, upstreams)
+	assert.NoError(t, err)
+	assert.Nil(t, actual.VirtualHosts[0].Routes[1].GetRoute().RetryPolicy)
+
+	// with retry policy
+	dependency.Retry = &model.RetryPolicy{
+		RetryOn:              []string{model.RetryOnGatewayError, model.RetryOnRetriableStatusCodes},
+		NumRetries:           3,
+		PerTryTimeoutMS:      250,
+		RetriableStatusCodes: []uint32{409, 429},
+	}
+	actual, err = MakeEgressRoute("route-egress-app", dependency, upstreams)
+	assert.NoError(t, err)
+	routes := actual.VirtualHosts[0].Routes
+	assert.Equal(t, 2, len(routes))
+	// redirect is not retried
+	assert.NotNil(t, routes[0].GetRedirect())
+	assert.Empty(t, routes[0].RequestHeadersToAdd)
+	retry := routes[1].GetRoute().RetryPolicy
+	assert.Equal(t, "gateway-error,retriable-status-codes", retry.RetryOn)
+	assert.Equal(t, uint32(3), retry.NumRetries.Value)
+	assert.Equal(t, 250*time.Millisecond, *retry.PerTryTimeout)
+	assert.Equal(t, 1, len(routes[1].RequestHeadersToAdd))
+	assert.Equal(t, RetriableStatusCodesHeader, routes[1].RequestHeadersToAdd[0].Header.Key)
+	assert.Equal(t, "409,429", routes[1].RequestHeadersToAdd[0].Header.Value)
+}
+
+func TestMakeSnapshotTimeouts(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := mcore.NewCurrentTimeGenerator()
+	inventory := mcore.NewInventoryService(repo, nil, gen, logrus.New())
+	conf := model.EnvoyConf{
+		ClusterTimeoutMS: 2000,
+		AccessLogDir:     "/var/log/test",
+	}
+	sut := NewSnapshotGen(inventory, logrus.New(), gen, conf, nil)
+
+	app := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Hosts: []model.Host{{
+			Name:          "app1",
+			IngressAddr:   model.Address{Hostname: "192.168.1.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+		Timeouts: &model.Timeouts{ConnectTimeoutMS: 500, RequestTimeoutMS: 60000, IdleTimeoutMS: 300000},
+	}
+	db := model.IdempotentServiceParam{
+		Protocol: model.ProtocolTCP,
+		Hosts: []model.Host{{
+			Name:          "db1",
+			IngressAddr:   model.Address{Hostname: "192.168.2.1", Port: 5432},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 15432},
+			EgressHost:    "127.0.0.1",
+		}},
+	}
+	front := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Hosts: []model.Host{{
+			Name:          "front1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+		DependentServices: []model.DependentService{
+			{Name: "app", EgressPort: 9001, Timeouts: &model.Timeouts{RequestTimeoutMS: 200}},
+			{Name: "db", EgressPort: 9002, Timeouts: &model.Timeouts{IdleTimeoutMS: 10000}},
+		},
+	}
+	_, err := inventory.IdempotentService("app", app)
+	assert.NoError(t, err)
+	_, err = inventory.IdempotentService("db", db)
+	assert.NoError(t, err)
+	_, err = inventory.IdempotentService("front", front)
+	assert.NoError(t, err)
+
+	// caller side
+	shots, err := sut.MakeSnapshotsOfService("front")
+	assert.NoError(t, err)
+	actual, ok := FindSnapshotByName(shots, "front1")
+	assert.True(t, ok)
+
+	connectTimeouts := map[string]time.Duration{}
+	for _, item := range actual.Clusters.Items {
+		c := item.(*v2.Cluster)
+		connectTimeouts[c.Name] = c.ConnectTimeout
+	}
+	assert.Equal(t, map[string]time.Duration{
+		"ingress":    2000 * time.Millisecond,
+		"egress-app": 500 * time.Millisecond,
+		"egress-db":  2000 * time.Millisecond,
+	}, connectTimeouts)
+
+	for _, item := range actual.Routes.Items {
+		r := item.(*v2.RouteConfiguration)
+		action := r.VirtualHosts[0].Routes[0].GetRoute()
+		switch r.Name {
+		case "route-ingress":
+			assert.Nil(t, action.Timeout)
+		case "route-egress-app":
+			assert.Equal(t, 200*time.Millisecond, *action.Timeout)
+		default:
+			assert.Failf(t, "unknown route name: %s", r.Name)
+		}
+	}
+
+	for _, item := range actual.Listeners.Items {
+		l := item.(*v2.Listener)
+		filter := l.FilterChains[0].Filters[0]
+		switch l.Name {
+		case "listener-ingress-19216801-80":
+			manager := &hcm.HttpConnectionManager{}
+			assert.NoError(t, util.StructToMessage(filter.Config, manager))
+			assert.Nil(t, manager.IdleTimeout)
+		case "listener-egress-app-127001-9001":
+			manager := &hcm.HttpConnectionManager{}
+			assert.NoError(t, util.StructToMessage(filter.Config, manager))
+			assert.Equal(t, 300000*time.Millisecond, *manager.IdleTimeout)
+		case "listener-egress-db-127001-9002":
+			proxy := &tcp.TcpProxy{}
+			assert.NoError(t, util.StructToMessage(filter.Config, proxy))
+			assert.Equal(t, 10000*time.Millisecond, *proxy.IdleTimeout)
+		default:
+			assert.Failf(t, "unknown listener name: %s", l.Name)
+		}
+	}
+
+	// callee side
+	shots, err = sut.MakeSnapshotsOfService("app")
+	assert.NoError(t, err)
+	actual, ok = FindSnapshotByName(shots, "app1")
+	assert.True(t, ok)
+	assert.Equal(t, 500*time.Millisecond, actual.Clusters.Items["ingress"].(*v2.Cluster).ConnectTimeout)
+	ingressRoute := actual.Routes.Items["route-ingress"].(*v2.RouteConfiguration)
+	assert.Equal(t, 60000*time.Millisecond, *ingressRoute.VirtualHosts[0].Routes[0].GetRoute().Timeout)
+}
+
+func TestMakeSnapshotResilience(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := mcore.NewCurrentTimeGenerator()
+	inventory := mcore.NewInventoryService(repo, nil, gen, logrus.New())
+	conf := model.EnvoyConf{
+		ClusterTimeoutMS: 2000,
+		AccessLogDir:     "/var/log/test",
+	}
+	sut := NewSnapshotGen(inventory, logrus.New(), gen, conf, nil)
+
+	app := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Hosts: []model.Host{
+			{
+				Name:          "app1",
+				IngressAddr:   model.Address{Hostname: "192.168.1.1", Port: 80},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+				EgressHost:    "127.0.0.1",
+				Subset:        "v1",
+			},
+			{
+				Name:          "app2",
+				IngressAddr:   model.Address{Hostname: "192.168.1.2", Port: 80},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+				EgressHost:    "127.0.0.1",
+				Subset:        "v2",
+			},
+		},
+		Subsets: []model.SubsetWeight{
+			{Name: "v1", Weight: 50},
+			{Name: "v2", Weight: 50},
+		},
+		Resilience: &model.ResiliencePolicy{
+			CircuitBreaker: &model.CircuitBreaker{MaxConnections: 100, MaxPendingRequests: 10, MaxRequests: 200, MaxRetries: 5},
+			OutlierDetection: &model.OutlierDetection{
+				Consecutive5xx:     3,
+				IntervalMS:         5000,
+				BaseEjectionTimeMS: 30000,
+				MaxEjectionPercent: 50,
+			},
+		},
+	}
+	front := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Hosts: []model.Host{{
+			Name:          "front1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+		DependentServices: []model.DependentService{{Name: "app", EgressPort: 9001}},
+		Resilience: &model.ResiliencePolicy{
+			CircuitBreaker: &model.CircuitBreaker{MaxConnections: 1},
+		},
+	}
+	_, err := inventory.IdempotentService("app", app)
+	assert.NoError(t, err)
+	_, err = inventory.IdempotentService("front", front)
+	assert.NoError(t, err)
+
+	shots, err := sut.MakeSnapshotsOfService("front")
+	assert.NoError(t, err)
+	actual, ok := FindSnapshotByName(shots, "front1")
+	assert.True(t, ok)
+
+	assert.Equal(t, 3, len(actual.Clusters.Items))
+	for name, item := range actual.Clusters.Items {
+		c := item.(*v2.Cluster)
+		if name == "ingress" {
+			// the policy of the service itself is not applied to the ingress
+			assert.Nil(t, c.CircuitBreakers)
+			assert.Nil(t, c.OutlierDetection)
+			continue
+		}
+		thresholds := c.CircuitBreakers.Thresholds[0]
+		assert.Equal(t, uint32(100), thresholds.MaxConnections.Value)
+		assert.Equal(t, uint32(10), thresholds.MaxPendingRequests.Value)
+		assert.Equal(t, uint32(200), thresholds.MaxRequests.Value)
+		assert.Equal(t, uint32(5), thresholds.MaxRetries.Value)
+		assert.Equal(t, uint32(3), c.OutlierDetection.Consecutive_5Xx.Value)
+		assert.Equal(t, int64(5), c.OutlierDetection.Interval.Seconds)
+		assert.Equal(t, int64(30), c.OutlierDetection.BaseEjectionTime.Seconds)
+		assert.Equal(t, uint32(50), c.OutlierDetection.MaxEjectionPercent.Value)
+	}
+
+	// zero values are left to the defaults
+	c := applyResiliencePolicy(MakeEDSCluster("egress-app", time.Second), &model.ResiliencePolicy{
+		CircuitBreaker:   &model.CircuitBreaker{MaxRequests: 10},
+		OutlierDetection: &model.OutlierDetection{},
+	})
+	assert.Nil(t, c.CircuitBreakers.Thresholds[0].MaxConnections)
+	assert.Equal(t, uint32(10), c.CircuitBreakers.Thresholds[0].MaxRequests.Value)
+	assert.Nil(t, c.OutlierDetection.Consecutive_5Xx)
+	assert.Nil(t, c.OutlierDetection.Interval)
+}
+
+func TestMakeSnapshotActiveHealthCheck(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := mcore.NewCurrentTimeGenerator()
+	inventory := mcore.NewInventoryService(repo, nil, gen, logrus.New())
+	conf := model.EnvoyConf{
+		ClusterTimeoutMS: 2000,
+		AccessLogDir:     "/var/log/test",
+	}
+	sut := NewSnapshotGen(inventory, logrus.New(), gen, conf, nil)
+
+	db := model.IdempotentServiceParam{
+		Protocol: model.ProtocolTCP,
+		Hosts: []model.Host{{
+			Name:          "db1",
+			IngressAddr:   model.Address{Hostname: "192.168.2.1", Port: 5432},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 15432},
+			EgressHost:    "127.0.0.1",
+		}},
+		HealthCheck: &model.ActiveHealthCheck{IntervalMS: 10000, TimeoutMS: 1000, HealthyThreshold: 1, UnhealthyThreshold: 3},
+	}
+	front := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Hosts: []model.Host{{
+			Name:          "front1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+		DependentServices: []model.DependentService{{Name: "db", EgressPort: 9001}},
+		HealthCheck:       &model.ActiveHealthCheck{Path: "/health", IntervalMS: 5000, TimeoutMS: 500, HealthyThreshold: 2, UnhealthyThreshold: 2},
+	}
+	_, err := inventory.IdempotentService("db", db)
+	assert.NoError(t, err)
+	_, err = inventory.IdempotentService("front", front)
+	assert.NoError(t, err)
+
+	shots, err := sut.MakeSnapshotsOfService("front")
+	assert.NoError(t, err)
+	actual, ok := FindSnapshotByName(shots, "front1")
+	assert.True(t, ok)
+
+	// ingress
+	ingress := actual.Clusters.Items["ingress"].(*v2.Cluster)
+	assert.Equal(t, 1, len(ingress.HealthChecks))
+	check := ingress.HealthChecks[0]
+	assert.Equal(t, 5*time.Second, *check.Interval)
+	assert.Equal(t, 500*time.Millisecond, *check.Timeout)
+	assert.Equal(t, uint32(2), check.HealthyThreshold.Value)
+	assert.Equal(t, uint32(2), check.UnhealthyThreshold.Value)
+	assert.Equal(t, "/health", check.GetHttpHealthCheck().Path)
+
+	// egress
+	egress := actual.Clusters.Items["egress-db"].(*v2.Cluster)
+	assert.Equal(t, 1, len(egress.HealthChecks))
+	check = egress.HealthChecks[0]
+	assert.Equal(t, 10*time.Second, *check.Interval)
+	assert.Equal(t, time.Second, *check.Timeout)
+	assert.Equal(t, uint32(1), check.HealthyThreshold.Value)
+	assert.Equal(t, uint32(3), check.UnhealthyThreshold.Value)
+	assert.NotNil(t, check.GetTcpHealthCheck())
+
+	// the health check filter is disabled by default
+	for _, item := range actual.Listeners.Items {
+		l := item.(*v2.Listener)
+		if l.Name != "listener-ingress-19216801-80" {
+			continue
+		}
+		manager := &hcm.HttpConnectionManager{}
+		assert.NoError(t, util.StructToMessage(l.FilterChains[0].Filters[0].Config, manager))
+		assert.Equal(t, 1, len(manager.HttpFilters))
+	}
+}
+
+func TestMakeSnapshotHealthCheckFilter(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := mcore.NewCurrentTimeGenerator()
+	inventory := mcore.NewInventoryService(repo, nil, gen, logrus.New())
+	conf := model.EnvoyConf{
+		ClusterTimeoutMS: 2000,
+		AccessLogDir:     "/var/log/test",
+	}
+	sut := NewSnapshotGen(inventory, logrus.New(), gen, conf, nil)
+
+	front := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Hosts: []model.Host{{
+			Name:          "front1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+		HealthCheckFilter: &model.HealthCheckFilter{Mode: model.HealthCheckFilterPassThrough, Endpoint: "/health", CacheTimeMS: 3000},
+	}
+	_, err := inventory.IdempotentService("front", front)
+	assert.NoError(t, err)
+
+	shots, err := sut.MakeSnapshotsOfService("front")
+	assert.NoError(t, err)
+	actual, ok := FindSnapshotByName(shots, "front1")
+	assert.True(t, ok)
+
+	l := actual.Listeners.Items["listener-ingress-19216801-80"].(*v2.Listener)
+	manager := &hcm.HttpConnectionManager{}
+	assert.NoError(t, util.StructToMessage(l.FilterChains[0].Filters[0].Config, manager))
+	assert.Equal(t, 2, len(manager.HttpFilters))
+	assert.Equal(t, "envoy.health_check", manager.HttpFilters[0].Name)
+	assert.Equal(t, cache.Router, manager.HttpFilters[1].Name)
+	config := &hc.HealthCheck{}
+	assert.NoError(t, util.StructToMessage(manager.HttpFilters[0].Config, config))
+	assert.True(t, config.PassThroughMode.Value)
+	assert.Equal(t, "/health", config.Endpoint)
+	assert.Equal(t, 3*time.Second, *config.CacheTime)
+}
+
+func TestNewHTTPHealthCheck(t *testing.T) {
+	assert.False(t, NewHTTPHealthCheck(nil).Enabled)
+	assert.False(t, NewHTTPHealthCheck(&model.HealthCheckFilter{Mode: model.HealthCheckFilterDisabled}).Enabled)
+
+	// defaults
+	actual := NewHTTPHealthCheck(&model.HealthCheckFilter{Mode: model.HealthCheckFilterPassThrough})
+	assert.Equal(t, NewDefaultPassThroghHTTPHealthCheck(), actual)
+
+	actual = NewHTTPHealthCheck(&model.HealthCheckFilter{Mode: model.HealthCheckFilterEndpoint, Endpoint: "/ping"})
+	assert.True(t, actual.Enabled)
+	assert.False(t, actual.PassThrough)
+	assert.Equal(t, "/ping", actual.Endpoint)
+}
+
+func TestMakeSnapshotProtocols(t *testing.T) {
+	cases := []struct {
+		protocol      string
+		networkFilter string
+		codec         hcm.HttpConnectionManager_CodecType
+		httpFilters   []string
+		http2         bool
+		healthCheck   func(*core.HealthCheck) bool
+	}{
+		{
+			protocol:      model.ProtocolHTTP,
+			networkFilter: cache.HTTPConnectionManager,
+			codec:         hcm.AUTO,
+			httpFilters:   []string{cache.Router},
+			http2:         false,
+			healthCheck:   func(h *core.HealthCheck) bool { return h.GetHttpHealthCheck() == nil },
+		},
+		{
+			protocol:      model.ProtocolGRPC,
+			networkFilter: cache.HTTPConnectionManager,
+			codec:         hcm.HTTP2,
+			httpFilters:   []string{GRPCHTTP1BridgeFilter, cache.Router},
+			http2:         true,
+			healthCheck:   func(h *core.HealthCheck) bool { return h.GetGrpcHealthCheck() != nil },
+		},
+		{
+			protocol:      model.ProtocolTCP,
+			networkFilter: cache.TCPProxy,
+			http2:         false,
+			healthCheck:   func(h *core.HealthCheck) bool { return h.GetTcpHealthCheck() != nil },
+		},
+	}
+
+	for _, c := range cases {
+		repo := repository.NewInventoryHeap()
+		gen := mcore.NewCurrentTimeGenerator()
+		inventory := mcore.NewInventoryService(repo, nil, gen, logrus.New())
+		conf := model.EnvoyConf{
+			ClusterTimeoutMS: 2000,
+			AccessLogDir:     "/var/log/test",
+		}
+		sut := NewSnapshotGen(inventory, logrus.New(), gen, conf, nil)
+
+		app := model.IdempotentServiceParam{
+			Protocol: c.protocol,
+			Hosts: []model.Host{{
+				Name:          "app1",
+				IngressAddr:   model.Address{Hostname: "192.168.1.1", Port: 80},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+				EgressHost:    "127.0.0.1",
+			}},
+			HealthCheck: &model.ActiveHealthCheck{IntervalMS: 5000, TimeoutMS: 1000, HealthyThreshold: 1, UnhealthyThreshold: 2},
+		}
+		front := model.IdempotentServiceParam{
+			Protocol: model.ProtocolHTTP,
+			Hosts: []model.Host{{
+				Name:          "front1",
+				IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+				EgressHost:    "127.0.0.1",
+			}},
+			DependentServices: []model.DependentService{{Name: "app", EgressPort: 9001}},
+		}
+		_, err := inventory.IdempotentService("app", app)
+		assert.NoError(t, err, c.protocol)
+		_, err = inventory.IdempotentService("front", front)
+		assert.NoError(t, err, c.protocol)
+
+		// egress of the caller and ingress of the callee
+		shots, err := sut.MakeSnapshotsOfService("front")
+		assert.NoError(t, err, c.protocol)
+		frontShot, ok := FindSnapshotByName(shots, "front1")
+		assert.True(t, ok, c.protocol)
+		shots, err = sut.MakeSnapshotsOfService("app")
+		assert.NoError(t, err, c.protocol)
+		appShot, ok := FindSnapshotByName(shots, "app1")
+		assert.True(t, ok, c.protocol)
+
+		clusters := []*v2.Cluster{
+			frontShot.Clusters.Items["egress-app"].(*v2.Cluster),
+			appShot.Clusters.Items["ingress"].(*v2.Cluster),
+		}
+		listeners := []*v2.Listener{
+			frontShot.Listeners.Items["listener-egress-app-127001-9001"].(*v2.Listener),
+			appShot.Listeners.Items["listener-ingress-19216811-80"].(*v2.Listener),
+		}
+
+		for _, cluster := range clusters {
+			assert.Equal(t, c.http2, cluster.Http2ProtocolOptions != nil, "%s %s", c.protocol, cluster.Name)
+			assert.True(t, c.healthCheck(cluster.HealthChecks[0]), "%s %s", c.protocol, cluster.Name)
+		}
+		for _, l := range listeners {
+			filter := l.FilterChains[0].Filters[0]
+			assert.Equal(t, c.networkFilter, filter.Name, "%s %s", c.protocol, l.Name)
+			if filter.Name != cache.HTTPConnectionManager {
+				continue
+			}
+			manager := &hcm.HttpConnectionManager{}
+			assert.NoError(t, util.StructToMessage(filter.Config, manager))
+			assert.Equal(t, c.codec, manager.CodecType, "%s %s", c.protocol, l.Name)
+			filterNames := []string{}
+			for _, f := range manager.HttpFilters {
+				filterNames = append(filterNames, f.Name)
+			}
+			assert.Equal(t, c.httpFilters, filterNames, "%s %s", c.protocol, l.Name)
+		}
+	}
+}
+
+type mockedCA struct {
+	serial string
+}
+
+func (m *mockedCA) Issue(hostName string, identity string) (*ca.KeyPair, error) {
+	return &ca.KeyPair{
+		CertPEM:  []byte("cert-" + hostName),
+		KeyPEM:   []byte("key-" + hostName),
+		Identity: identity,
+		Serial:   m.serial,
+	}, nil
+}
+
+func (m *mockedCA) TrustBundle() []byte {
+	return []byte("bundle")
+}
+
+func TestMakeSnapshotMTLS(t *testing.T) {
+	for _, mode := range []string{model.MTLSModeStrict, model.MTLSModePermissive} {
+		repo := repository.NewInventoryHeap()
+		gen := mcore.NewCurrentTimeGenerator()
+		inventory := mcore.NewInventoryService(repo, nil, gen, logrus.New())
+		conf := model.EnvoyConf{
+			ClusterTimeoutMS: 2000,
+			AccessLogDir:     "/var/log/test",
+		}
+		authority := &mockedCA{serial: "1"}
+		mtls := &MTLS{
+			Conf: model.MTLSConf{Mode: mode, TrustDomain: "example.com"},
+			CA:   authority,
+		}
+		sut := NewSnapshotGen(inventory, logrus.New(), gen, conf, mtls)
+
+		app := model.IdempotentServiceParam{
+			Protocol: model.ProtocolGRPC,
+			Hosts: []model.Host{{
+				Name:          "app1",
+				IngressAddr:   model.Address{Hostname: "192.168.1.1", Port: 80},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+				EgressHost:    "127.0.0.1",
+			}},
+		}
+		front := model.IdempotentServiceParam{
+			Protocol: model.ProtocolHTTP,
+			Hosts: []model.Host{{
+				Name:          "front1",
+				IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+				SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+				EgressHost:    "127.0.0.1",
+			}},
+			DependentServices: []model.DependentService{{Name: "app", EgressPort: 9001}},
+		}
+		_, err := inventory.IdempotentService("app", app)
+		assert.NoError(t, err)
+		_, err = inventory.IdempotentService("front", front)
+		assert.NoError(t, err)
+
+		shots, err := sut.MakeSnapshotsOfService("front")
+		assert.NoError(t, err)
+		actual, ok := FindSnapshotByName(shots, "front1")
+		assert.True(t, ok)
+
+		// the local app is plaintext
+		assert.Nil(t, actual.Clusters.Items["ingress"].(*v2.Cluster).TlsContext)
+		assert.Nil(t, actual.Listeners.Items["listener-egress-app-127001-9001"].(*v2.Listener).FilterChains[0].TlsContext)
+
+		// egress
+		upstreamTLS := actual.Clusters.Items["egress-app"].(*v2.Cluster).TlsContext.CommonTlsContext
+		assert.Equal(t, KeyPairSecret, upstreamTLS.TlsCertificateSdsSecretConfigs[0].Name)
+		assert.Equal(t, []string{XdsCluster}, upstreamTLS.TlsCertificateSdsSecretConfigs[0].SdsConfig.GetApiConfigSource().ClusterNames)
+		assert.Equal(t, "trust-bundle-app", upstreamTLS.GetValidationContextSdsSecretConfig().Name)
+		assert.Equal(t, []string{"h2"}, upstreamTLS.AlpnProtocols)
+
+		// secrets
+		assert.Equal(t, 3, len(actual.Secrets.Items))
+		keyPair := actual.Secrets.Items[KeyPairSecret].(*auth.Secret).GetTlsCertificate()
+		assert.Equal(t, []byte("cert-front1"), keyPair.CertificateChain.GetInlineBytes())
+		assert.Equal(t, []byte("key-front1"), keyPair.PrivateKey.GetInlineBytes())
+		bundle := actual.Secrets.Items[TrustBundleSecret].(*auth.Secret).GetValidationContext()
+		assert.Equal(t, []byte("bundle"), bundle.TrustedCa.GetInlineBytes())
+		assert.Empty(t, bundle.VerifySubjectAltName)
+		validation := actual.Secrets.Items["trust-bundle-app"].(*auth.Secret).GetValidationContext()
+		assert.Equal(t, []byte("bundle"), validation.TrustedCa.GetInlineBytes())
+		assert.Equal(t, []string{"spiffe://example.com/service/app"}, validation.VerifySubjectAltName)
+
+		// ingress
+		ingress := actual.Listeners.Items["listener-ingress-19216801-80"].(*v2.Listener)
+		if mode == model.MTLSModeStrict {
+			assert.Equal(t, 1, len(ingress.FilterChains))
+			assert.Empty(t, ingress.ListenerFilters)
+		} else {
+			assert.Equal(t, 2, len(ingress.FilterChains))
+			assert.Equal(t, "tls", ingress.FilterChains[0].FilterChainMatch.TransportProtocol)
+			assert.Nil(t, ingress.FilterChains[1].TlsContext)
+			assert.Nil(t, ingress.FilterChains[1].FilterChainMatch)
+			assert.Equal(t, TLSInspector, ingress.ListenerFilters[0].Name)
+		}
+		downstreamTLS := ingress.FilterChains[0].TlsContext
+		assert.True(t, downstreamTLS.RequireClientCertificate.Value)
+		assert.Equal(t, KeyPairSecret, downstreamTLS.CommonTlsContext.TlsCertificateSdsSecretConfigs[0].Name)
+		assert.Equal(t, TrustBundleSecret, downstreamTLS.CommonTlsContext.GetValidationContextSdsSecretConfig().Name)
+
+		// rotation updates only the secrets
+		authority.serial = "2"
+		shots, err = sut.MakeSnapshotsOfService("front")
+		assert.NoError(t, err)
+		rotated, ok := FindSnapshotByName(shots, "front1")
+		assert.True(t, ok)
+		assert.Equal(t, actual.Clusters.Version, rotated.Clusters.Version)
+		assert.Equal(t, actual.Listeners.Version, rotated.Listeners.Version)
+		assert.NotEqual(t, actual.Secrets.Version, rotated.Secrets.Version)
+	}
+}
+
+func TestMakeSnapshotFault(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := mcore.NewCurrentTimeGenerator()
+	inventory := mcore.NewInventoryService(repo, nil, gen, logrus.New())
+	conf := model.EnvoyConf{
+		ClusterTimeoutMS: 2000,
+		AccessLogDir:     "/var/log/test",
+	}
+	sut := NewSnapshotGen(inventory, logrus.New(), gen, conf, nil)
+
+	app := model.IdempotentServiceParam{Protocol: model.ProtocolHTTP}
+	front := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Hosts: []model.Host{{
+			Name:          "front1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+		DependentServices: []model.DependentService{
+			{
+				Name:       "app",
+				EgressPort: 9001,
+				Fault: &model.FaultPolicy{
+					Delay: &model.FaultDelay{Percentage: 50, DurationMS: 1500},
+					Abort: &model.FaultAbort{Percentage: 10, HTTPStatus: 503},
+				},
+			},
+		},
+	}
+	_, err := inventory.IdempotentService("app", app)
+	assert.NoError(t, err)
+	_, err = inventory.IdempotentService("front", front)
+	assert.NoError(t, err)
+
+	shots, err := sut.MakeSnapshotsOfService("front")
+	assert.NoError(t, err)
+	actual, ok := FindSnapshotByName(shots, "front1")
+	assert.True(t, ok)
+
+	// egress
+	l := actual.Listeners.Items["listener-egress-app-127001-9001"].(*v2.Listener)
+	manager := &hcm.HttpConnectionManager{}
+	assert.NoError(t, util.StructToMessage(l.FilterChains[0].Filters[0].Config, manager))
+	assert.Equal(t, 2, len(manager.HttpFilters))
+	assert.Equal(t, FaultFilter, manager.HttpFilters[0].Name)
+	assert.Equal(t, cache.Router, manager.HttpFilters[1].Name)
+	config := &httpfault.HTTPFault{}
+	assert.NoError(t, util.StructToMessage(manager.HttpFilters[0].Config, config))
+	assert.Equal(t, 1500*time.Millisecond, *config.Delay.GetFixedDelay())
+	assert.Equal(t, uint32(50), config.Delay.Percentage.Numerator)
+	assert.Equal(t, uint32(503), config.Abort.GetHttpStatus())
+	assert.Equal(t, uint32(10), config.Abort.Percentage.Numerator)
+
+	// ingress is not affected
+	l = actual.Listeners.Items["listener-ingress-19216801-80"].(*v2.Listener)
+	manager = &hcm.HttpConnectionManager{}
+	assert.NoError(t, util.StructToMessage(l.FilterChains[0].Filters[0].Config, manager))
+	assert.Equal(t, 1, len(manager.HttpFilters))
+
+	// expired
+	expiresAt := time.Now().Add(-time.Second)
+	front.DependentServices = []model.DependentService{{Name: "app", EgressPort: 9001, Fault: &model.FaultPolicy{
+		Abort:     &model.FaultAbort{Percentage: 10, HTTPStatus: 503},
+		ExpiresAt: &expiresAt,
+	}}}
+	_, err = inventory.IdempotentService("front", front)
+	assert.NoError(t, err)
+	shots, err = sut.MakeSnapshotsOfService("front")
+	assert.NoError(t, err)
+	actual, ok = FindSnapshotByName(shots, "front1")
+	assert.True(t, ok)
+	l = actual.Listeners.Items["listener-egress-app-127001-9001"].(*v2.Listener)
+	manager = &hcm.HttpConnectionManager{}
+	assert.NoError(t, util.StructToMessage(l.FilterChains[0].Filters[0].Config, manager))
+	assert.Equal(t, 1, len(manager.HttpFilters))
+}
+
+func TestMakeSnapshotRateLimit(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := mcore.NewCurrentTimeGenerator()
+	inventory := mcore.NewInventoryService(repo, nil, gen, logrus.New())
+	conf := model.EnvoyConf{
+		ClusterTimeoutMS: 2000,
+		AccessLogDir:     "/var/log/test",
+	}
+	sut := NewSnapshotGen(inventory, logrus.New(), gen, conf, nil)
+
+	db := model.IdempotentServiceParam{
+		Protocol: model.ProtocolTCP,
+		Hosts: []model.Host{{
+			Name:          "db1",
+			IngressAddr:   model.Address{Hostname: "192.168.1.1", Port: 3306},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 13306},
+			EgressHost:    "127.0.0.1",
+		}},
+	}
+	front := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Hosts: []model.Host{{
+			Name:          "front1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+		DependentServices: []model.DependentService{{Name: "db", EgressPort: 9001}},
+		RateLimit:         &model.RateLimit{RequestsPerSecond: 100, Burst: 150},
+		HealthCheckFilter: &model.HealthCheckFilter{Mode: model.HealthCheckFilterPassThrough},
+	}
+	_, err := inventory.IdempotentService("db", db)
+	assert.NoError(t, err)
+	_, err = inventory.IdempotentService("front", front)
+	assert.NoError(t, err)
+
+	shots, err := sut.MakeSnapshotsOfService("front")
+	assert.NoError(t, err)
+	actual, ok := FindSnapshotByName(shots, "front1")
+	assert.True(t, ok)
+	l := actual.Listeners.Items["listener-ingress-19216801-80"].(*v2.Listener)
+	manager := &hcm.HttpConnectionManager{}
+	assert.NoError(t, util.StructToMessage(l.FilterChains[0].Filters[0].Config, manager))
+	filterNames := []string{}
+	for _, f := range manager.HttpFilters {
+		filterNames = append(filterNames, f.Name)
+	}
+	assert.Equal(t, []string{"envoy.health_check", LuaFilter, cache.Router}, filterNames)
+	script := &lua.Lua{}
+	assert.NoError(t, util.StructToMessage(manager.HttpFilters[1].Config, script))
+	assert.NoError(t, script.Validate())
+	assert.Contains(t, script.InlineCode, "local max_tokens = 150\n")
+	assert.Contains(t, script.InlineCode, "local tokens_per_fill = 100\n")
+	assert.Contains(t, script.InlineCode, `[":status"] = "429"`)
+
+	// the egress to the rate limited service is not limited
+	l = actual.Listeners.Items["listener-egress-db-127001-9001"].(*v2.Listener)
+	assert.Equal(t, 1, len(l.FilterChains[0].Filters))
+
+	// TCP connections are limited by the rate limit service
+	db.RateLimit = &model.RateLimit{ConnectionsPerSecond: 10}
+	_, err = inventory.IdempotentService("db", db)
+	assert.NoError(t, err)
+	shots, err = sut.MakeSnapshotsOfService("db")
+	assert.NoError(t, err)
+	actual, ok = FindSnapshotByName(shots, "db1")
+	assert.True(t, ok)
+	l = actual.Listeners.Items["listener-ingress-19216811-3306"].(*v2.Listener)
+	assert.Equal(t, 2, len(l.FilterChains[0].Filters))
+	assert.Equal(t, NetworkRateLimitFilter, l.FilterChains[0].Filters[0].Name)
+	assert.Equal(t, cache.TCPProxy, l.FilterChains[0].Filters[1].Name)
+	limit := &rl.RateLimit{}
+	assert.NoError(t, util.StructToMessage(l.FilterChains[0].Filters[0].Config, limit))
+	assert.NoError(t, limit.Validate())
+	assert.Equal(t, RateLimitDomain, limit.Domain)
+	entries := map[string]string{}
+	for _, e := range limit.Descriptors[0].Entries {
+		entries[e.Key] = e.Value
+	}
+	assert.Equal(t, map[string]string{
+		"host":                   "db1",
+		"listener":               "listener-ingress-19216811-3306",
+		"connections_per_second": "10",
+	}, entries)
+}
+
+func TestMakeLocalityEndpoint(t *testing.T) {
+	hosts := []model.Host{
+		{Name: "app1", IngressAddr: model.Address{Hostname: "192.168.1.1", Port: 80}, Region: "r1", Zone: "z1"},
+		{Name: "app2", IngressAddr: model.Address{Hostname: "192.168.1.2", Port: 80}, Region: "r1", Zone: "z2"},
+		{Name: "app3", IngressAddr: model.Address{Hostname: "192.168.1.3", Port: 80}, Region: "r2", Zone: "z3"},
+		{Name: "app4", IngressAddr: model.Address{Hostname: "192.168.1.4", Port: 80}, Region: "r1", Zone: "z1"},
+		{Name: "app5", IngressAddr: model.Address{Hostname: "192.168.1.5", Port: 80}, Region: "r1", Zone: "z2", Subset: "v2"},
+	}
+
+	type group struct {
+		priority  uint32
+		locality  *core.Locality
+		addresses []string
+	}
+	groupsOf := func(cla *v2.ClusterLoadAssignment) []group {
+		groups := []group{}
+		for _, e := range cla.Endpoints {
+			addrs := []string{}
+			for _, lb := range e.LbEndpoints {
+				addrs = append(addrs, addr2str(lb.Endpoint.Address))
+			}
+			groups = append(groups, group{e.Priority, e.Locality, addrs})
+		}
+		return groups
+	}
+
+	// caller in r1/z1
+	actual := MakeLocalityEndpoint("egress-app", &model.Host{Name: "front1", Region: "r1", Zone: "z1"}, hosts, nil)
+	assert.Equal(t, "egress-app", actual.ClusterName)
+	assert.Equal(t, []group{
+		{0, &core.Locality{Region: "r1", Zone: "z1"}, []string{"192.168.1.1:80", "192.168.1.4:80"}},
+		{1, &core.Locality{Region: "r1", Zone: "z2"}, []string{"192.168.1.2:80", "192.168.1.5:80"}},
+		{2, &core.Locality{Region: "r2", Zone: "z3"}, []string{"192.168.1.3:80"}},
+	}, groupsOf(actual))
+
+	// caller in r2, no hosts in the same zone
+	actual = MakeLocalityEndpoint("egress-app", &model.Host{Name: "front2", Region: "r2", Zone: "z4"}, hosts, nil)
+	assert.Equal(t, []group{
+		{0, &core.Locality{Region: "r2", Zone: "z3"}, []string{"192.168.1.3:80"}},
+		{1, &core.Locality{Region: "r1", Zone: "z1"}, []string{"192.168.1.1:80", "192.168.1.4:80"}},
+		{1, &core.Locality{Region: "r1", Zone: "z2"}, []string{"192.168.1.2:80", "192.168.1.5:80"}},
+	}, groupsOf(actual))
+
+	// the caller without locality prefers nothing
+	actual = MakeLocalityEndpoint("egress-app", &model.Host{Name: "front3"}, hosts, func(h *model.Host) bool {
+		return h.Subset == "v2"
+	})
+	assert.Equal(t, []group{
+		{0, &core.Locality{Region: "r1", Zone: "z2"}, []string{"192.168.1.5:80"}},
+	}, groupsOf(actual))
+
+	// weights
+	weighted := []model.Host{
+		{Name: "app1", IngressAddr: model.Address{Hostname: "192.168.1.1", Port: 80}, Weight: 10},
+		{Name: "app2", IngressAddr: model.Address{Hostname: "192.168.1.2", Port: 80}},
+	}
+	actual = MakeLocalityEndpoint("egress-app", &model.Host{Name: "front1"}, weighted, nil)
+	assert.Equal(t, uint32(10), actual.Endpoints[0].LbEndpoints[0].LoadBalancingWeight.Value)
+	assert.Nil(t, actual.Endpoints[0].LbEndpoints[1].LoadBalancingWeight)
+
+	// states
+	states := []model.Host{
+		{Name: "app1", IngressAddr: model.Address{Hostname: "192.168.1.1", Port: 80}},
+		{Name: "app2", IngressAddr: model.Address{Hostname: "192.168.1.2", Port: 80}, State: model.HostStateDraining},
+		{Name: "app3", IngressAddr: model.Address{Hostname: "192.168.1.3", Port: 80}, State: model.HostStateMaintenance},
+	}
+	actual = MakeLocalityEndpoint("egress-app", &model.Host{Name: "front1"}, states, nil)
+	assert.Equal(t, []group{
+		{0, nil, []string{"192.168.1.1:80", "192.168.1.2:80"}},
+	}, groupsOf(actual))
+	assert.Equal(t, core.HealthStatus_UNKNOWN, actual.Endpoints[0].LbEndpoints[0].HealthStatus)
+	assert.Equal(t, core.HealthStatus_DRAINING, actual.Endpoints[0].LbEndpoints[1].HealthStatus)
+}
+
+func TestMakeSnapshotLoadBalancer(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := mcore.NewCurrentTimeGenerator()
+	inventory := mcore.NewInventoryService(repo, nil, gen, logrus.New())
+	conf := model.EnvoyConf{
+		ClusterTimeoutMS: 2000,
+		AccessLogDir:     "/var/log/test",
+	}
+	sut := NewSnapshotGen(inventory, logrus.New(), gen, conf, nil)
+
+	cache := model.IdempotentServiceParam{
+		Protocol:     model.ProtocolHTTP,
+		Subsets:      []model.SubsetWeight{{Name: "v1", Weight: 1}},
+		LoadBalancer: &model.LoadBalancer{Policy: model.LBRingHash, HashKey: &model.HashKey{Header: "x-user-id"}},
+	}
+	api := model.IdempotentServiceParam{
+		Protocol:     model.ProtocolHTTP,
+		LoadBalancer: &model.LoadBalancer{Policy: model.LBLeastRequest},
+		Routes:       []model.RouteRule{{Prefix: "/cache", Service: "cache"}},
+	}
+	front := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Hosts: []model.Host{{
+			Name:          "front1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+		DependentServices: []model.DependentService{
+			{Name: "cache", EgressPort: 9001},
+			{Name: "api", EgressPort: 9002},
+		},
+	}
+	_, err := inventory.IdempotentService("cache", cache)
+	assert.NoError(t, err)
+	_, err = inventory.IdempotentService("api", api)
+	assert.NoError(t, err)
+	_, err = inventory.IdempotentService("front", front)
+	assert.NoError(t, err)
+
+	shots, err := sut.MakeSnapshotsOfService("front")
+	assert.NoError(t, err)
+	actual, ok := FindSnapshotByName(shots, "front1")
+	assert.True(t, ok)
+
+	// clusters
+	assert.Equal(t, v2.Cluster_RING_HASH, actual.Clusters.Items["egress-cache~v1"].(*v2.Cluster).LbPolicy)
+	assert.Equal(t, v2.Cluster_LEAST_REQUEST, actual.Clusters.Items["egress-api"].(*v2.Cluster).LbPolicy)
+	assert.Equal(t, v2.Cluster_ROUND_ROBIN, actual.Clusters.Items["ingress"].(*v2.Cluster).LbPolicy)
+
+	// routes
+	cacheRoutes := actual.Routes.Items["route-egress-cache"].(*v2.RouteConfiguration).VirtualHosts[0].Routes
+	hash := cacheRoutes[0].GetRoute().HashPolicy
+	assert.Equal(t, 1, len(hash))
+	assert.Equal(t, "x-user-id", hash[0].GetHeader().HeaderName)
+	apiRoutes := actual.Routes.Items["route-egress-api"].(*v2.RouteConfiguration).VirtualHosts[0].Routes
+	assert.Equal(t, 2, len(apiRoutes))
+	// the route to the cache service hashes by the policy of the cache service
+	assert.Equal(t, "x-user-id", apiRoutes[0].GetRoute().HashPolicy[0].GetHeader().HeaderName)
+	assert.Empty(t, apiRoutes[1].GetRoute().HashPolicy)
+}
+
+func TestMakeHashPolicy(t *testing.T) {
+	assert.Nil(t, makeHashPolicy(nil))
+	assert.Nil(t, makeHashPolicy(&model.LoadBalancer{Policy: model.LBRoundRobin}))
+	actual := makeHashPolicy(&model.LoadBalancer{Policy: model.LBMaglev, HashKey: &model.HashKey{Cookie: "session"}})
+	assert.Equal(t, "session", actual[0].GetCookie().Name)
+	actual = makeHashPolicy(&model.LoadBalancer{Policy: model.LBRingHash, HashKey: &model.HashKey{SourceIP: true}})
+	assert.True(t, actual[0].GetConnectionProperties().SourceIp)
+}
+
+func TestMakeSnapshotExternal(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := mcore.NewCurrentTimeGenerator()
+	inventory := mcore.NewInventoryService(repo, nil, gen, logrus.New())
+	conf := model.EnvoyConf{
+		ClusterTimeoutMS: 2000,
+		AccessLogDir:     "/var/log/test",
+	}
+	mtls := &MTLS{
+		Conf: model.MTLSConf{Mode: model.MTLSModeStrict, TrustDomain: "example.com"},
+		CA:   &mockedCA{serial: "1"},
+	}
+	sut := NewSnapshotGen(inventory, logrus.New(), gen, conf, mtls)
+
+	payment := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		External: &model.ExternalService{
+			Hostname: "api.payment.example.com",
+			Port:     443,
+			TLS:      &model.ExternalTLS{CAFile: "/etc/ssl/certs/ca-certificates.crt"},
+		},
+	}
+	db := model.IdempotentServiceParam{
+		Protocol: model.ProtocolTCP,
+		External: &model.ExternalService{Hostname: "db.example.com", Port: 5432, Resolution: model.ResolutionLogicalDNS},
+	}
+	front := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Hosts: []model.Host{{
+			Name:          "front1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+		DependentServices: []model.DependentService{
+			{Name: "payment", EgressPort: 9001},
+			{Name: "db", EgressPort: 9002},
+		},
+	}
+	_, err := inventory.IdempotentService("payment", payment)
+	assert.NoError(t, err)
+	_, err = inventory.IdempotentService("db", db)
+	assert.NoError(t, err)
+	_, err = inventory.IdempotentService("front", front)
+	assert.NoError(t, err)
+
+	// the external services have no snapshots
+	shots, err := sut.MakeSnapshotsOfService("payment")
+	assert.NoError(t, err)
+	assert.Empty(t, shots)
+
+	shots, err = sut.MakeSnapshotsOfService("front")
+	assert.NoError(t, err)
+	actual, ok := FindSnapshotByName(shots, "front1")
+	assert.True(t, ok)
+
+	// clusters
+	paymentCluster := actual.Clusters.Items["egress-payment"].(*v2.Cluster)
+	assert.Equal(t, v2.Cluster_STRICT_DNS, paymentCluster.Type)
+	assert.Equal(t, "api.payment.example.com:443", addr2str(paymentCluster.LoadAssignment.Endpoints[0].LbEndpoints[0].Endpoint.Address))
+	assert.Equal(t, "api.payment.example.com", paymentCluster.TlsContext.Sni)
+	validation := paymentCluster.TlsContext.CommonTlsContext.GetValidationContext()
+	assert.Equal(t, "/etc/ssl/certs/ca-certificates.crt", validation.TrustedCa.GetFilename())
+	assert.Equal(t, []string{"api.payment.example.com"}, validation.VerifySubjectAltName)
+	assert.Empty(t, paymentCluster.TlsContext.CommonTlsContext.TlsCertificateSdsSecretConfigs)
+	dbCluster := actual.Clusters.Items["egress-db"].(*v2.Cluster)
+	assert.Equal(t, v2.Cluster_LOGICAL_DNS, dbCluster.Type)
+	assert.Nil(t, dbCluster.TlsContext)
+
+	// no endpoints are discovered
+	_, ok = actual.Endpoints.Items["egress-payment"]
+	assert.False(t, ok)
+	_, ok = actual.Endpoints.Items["egress-db"]
+	assert.False(t, ok)
+
+	// listeners
+	_, ok = actual.Listeners.Items["listener-egress-payment-127001-9001"]
+	assert.True(t, ok)
+	_, ok = actual.Listeners.Items["listener-egress-db-127001-9002"]
+	assert.True(t, ok)
+
+	// no trust bundles for the external services
+	assert.Equal(t, 2, len(actual.Secrets.Items))
+	assert.NoError(t, actual.Consistent())
+
+	// the server is verified unless it's skipped explicitly
+	insecure := &model.ExternalService{Hostname: "api.payment.example.com", Port: 443, TLS: &model.ExternalTLS{InsecureSkipVerify: true}}
+	c := applyExternalTLS(&v2.Cluster{Name: "egress-insecure"}, insecure, model.ProtocolHTTP)
+	assert.Equal(t, "api.payment.example.com", c.TlsContext.Sni)
+	assert.Nil(t, c.TlsContext.CommonTlsContext.ValidationContextType)
+}
+
+func TestMakeSnapshotIPv6(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := mcore.NewCurrentTimeGenerator()
+	inventory := mcore.NewInventoryService(repo, nil, gen, logrus.New())
+	conf := model.EnvoyConf{
+		ClusterTimeoutMS: 2000,
+		AccessLogDir:     "/var/log/test",
+	}
+	sut := NewSnapshotGen(inventory, logrus.New(), gen, conf, nil)
+
+	_, err := inventory.RegisterService("front", model.ProtocolHTTP)
+	assert.NoError(t, err)
+	_, err = inventory.RegisterService("back", model.ProtocolTCP)
+	assert.NoError(t, err)
+	assert.NoError(t, inventory.AddServiceDependency("front", "back", 10001))
+
+	_, err = inventory.RegisterHost("front", "front1", "[2001:db8::1]:80", "[::1]:8001", "::1")
+	assert.NoError(t, err)
+	_, err = inventory.RegisterHost("back", "back1", "[2001:db8::2]:8080", "[::1]:9001", "::1")
+	assert.NoError(t, err)
+	_, err = inventory.RegisterHost("back", "back2", "192.168.1.2:8080", "127.0.0.1:9001", "127.0.0.1")
+	assert.NoError(t, err)
+
+	shots, err := sut.MakeSnapshotsOfService("front")
+	assert.NoError(t, err)
+	actual, ok := FindSnapshotByName(shots, "front1")
+	assert.True(t, ok)
+
+	ingress := actual.Listeners.Items["listener-ingress-2001_db8__1-80"].(*v2.Listener)
+	assert.Equal(t, "2001:db8::1", ingress.Address.GetSocketAddress().GetAddress())
+	egress := actual.Listeners.Items["listener-egress-back-__1-10001"].(*v2.Listener)
+	assert.Equal(t, "::1", egress.Address.GetSocketAddress().GetAddress())
+	assert.Equal(t, uint32(10001), egress.Address.GetSocketAddress().GetPortValue())
+
+	ingressEndpoint := actual.Endpoints.Items["ingress"].(*v2.ClusterLoadAssignment)
+	assert.Equal(t, "::1:8001", addr2str(ingressEndpoint.Endpoints[0].LbEndpoints[0].Endpoint.Address))
+	backEndpoint := actual.Endpoints.Items["egress-back"].(*v2.ClusterLoadAssignment)
+	backAddress := []string{}
+	for _, e := range backEndpoint.Endpoints[0].LbEndpoints {
+		backAddress = append(backAddress, addr2str(e.Endpoint.Address))
+	}
+	assert.ElementsMatch(t, []string{"2001:db8::2:8080", "192.168.1.2:8080"}, backAddress)
+	assert.NoError(t, actual.Consistent())
+}
+
+func TestMakeSnapshotUnixSocket(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := mcore.NewCurrentTimeGenerator()
+	inventory := mcore.NewInventoryService(repo, nil, gen, logrus.New())
+	conf := model.EnvoyConf{
+		ClusterTimeoutMS: 2000,
+		AccessLogDir:     "/var/log/test",
+	}
+	sut := NewSnapshotGen(inventory, logrus.New(), gen, conf, nil)
+
+	_, err := inventory.RegisterService("app", model.ProtocolHTTP)
+	assert.NoError(t, err)
+	_, err = inventory.RegisterHost("app", "app1", "192.168.0.1:80", "unix:///run/app.sock", "127.0.0.1")
+	assert.NoError(t, err)
+
+	shots, err := sut.MakeSnapshotsOfService("app")
+	assert.NoError(t, err)
+	actual, ok := FindSnapshotByName(shots, "app1")
+	assert.True(t, ok)
+
+	ingress := actual.Endpoints.Items["ingress"].(*v2.ClusterLoadAssignment)
+	addr := ingress.Endpoints[0].LbEndpoints[0].Endpoint.Address
+	assert.Nil(t, addr.GetSocketAddress())
+	assert.Equal(t, "/run/app.sock", addr.GetPipe().Path)
+	_, ok = actual.Listeners.Items["listener-ingress-19216801-80"]
+	assert.True(t, ok)
+	assert.NoError(t, actual.Consistent())
+}
+
+func TestMakeSnapshotPorts(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := mcore.NewCurrentTimeGenerator()
+	inventory := mcore.NewInventoryService(repo, nil, gen, logrus.New())
+	conf := model.EnvoyConf{
+		ClusterTimeoutMS: 2000,
+		AccessLogDir:     "/var/log/test",
+	}
+	sut := NewSnapshotGen(inventory, logrus.New(), gen, conf, nil)
+
+	app := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Ports: []model.ServicePort{
+			{Name: "grpc", Protocol: model.ProtocolGRPC, IngressPort: 8081, SubstancePort: 9091},
+			{Name: "admin", Protocol: model.ProtocolTCP, IngressPort: 8082, SubstancePort: 9092},
+		},
+		Subsets: []model.SubsetWeight{{Name: "v1", Weight: 1}},
+		Hosts: []model.Host{{
+			Name:          "app1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+			Subset:        "v1",
+		}},
+	}
+	front := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Hosts: []model.Host{{
+			Name:          "front1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.2", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+		DependentServices: []model.DependentService{
+			{Name: "app", EgressPort: 9001},
+			{Name: "app", Port: "grpc", EgressPort: 9002, Timeouts: &model.Timeouts{RequestTimeoutMS: 300}},
+			{Name: "app", Port: "admin", EgressPort: 9003},
+		},
+	}
+	_, err := inventory.IdempotentService("app", app)
+	assert.NoError(t, err)
+	_, err = inventory.IdempotentService("front", front)
+	assert.NoError(t, err)
+
+	// one ingress listener and cluster pair per port
+	shots, err := sut.MakeSnapshotsOfService("app")
+	assert.NoError(t, err)
+	actual, ok := FindSnapshotByName(shots, "app1")
+	assert.True(t, ok)
+	assert.Equal(t, 3, len(actual.Listeners.Items))
+	grpcListener := actual.Listeners.Items["listener-ingress:grpc-19216801-8081"].(*v2.Listener)
+	assert.Equal(t, "192.168.0.1:8081", addr2str(&grpcListener.Address))
+	assert.Equal(t, util.HTTPConnectionManager, grpcListener.FilterChains[0].Filters[0].Name)
+	adminListener := actual.Listeners.Items["listener-ingress:admin-19216801-8082"].(*v2.Listener)
+	assert.Equal(t, util.TCPProxy, adminListener.FilterChains[0].Filters[0].Name)
+	_, ok = actual.Listeners.Items["listener-ingress-19216801-80"]
+	assert.True(t, ok)
+	grpcCluster := actual.Clusters.Items["ingress:grpc"].(*v2.Cluster)
+	assert.NotNil(t, grpcCluster.Http2ProtocolOptions)
+	grpcEndpoint := actual.Endpoints.Items["ingress:grpc"].(*v2.ClusterLoadAssignment)
+	assert.Equal(t, "127.0.0.1:9091", addr2str(grpcEndpoint.Endpoints[0].LbEndpoints[0].Endpoint.Address))
+	_, ok = actual.Routes.Items["route-ingress:grpc"]
+	assert.True(t, ok)
+	_, ok = actual.Routes.Items["route-ingress:admin"]
+	assert.False(t, ok)
+	assert.NoError(t, actual.Consistent())
+
+	// one egress listener per port
+	shots, err = sut.MakeSnapshotsOfService("front")
+	assert.NoError(t, err)
+	actual, ok = FindSnapshotByName(shots, "front1")
+	assert.True(t, ok)
+	assert.ElementsMatch(t, []string{
+		"listener-ingress-19216802-80",
+		"listener-egress-app-127001-9001",
+		"listener-egress-app:grpc-127001-9002",
+		"listener-egress-app:admin-127001-9003",
+	}, keysOf(actual.Listeners.Items))
+	assert.ElementsMatch(t, []string{"ingress", "egress-app~v1", "egress-app:grpc", "egress-app:admin"}, keysOf(actual.Clusters.Items))
+	grpcCluster = actual.Clusters.Items["egress-app:grpc"].(*v2.Cluster)
+	assert.NotNil(t, grpcCluster.Http2ProtocolOptions)
+	grpcEndpoint = actual.Endpoints.Items["egress-app:grpc"].(*v2.ClusterLoadAssignment)
+	assert.Equal(t, "192.168.0.1:8081", addr2str(grpcEndpoint.Endpoints[0].LbEndpoints[0].Endpoint.Address))
+	adminEndpoint := actual.Endpoints.Items["egress-app:admin"].(*v2.ClusterLoadAssignment)
+	assert.Equal(t, "192.168.0.1:8082", addr2str(adminEndpoint.Endpoints[0].LbEndpoints[0].Endpoint.Address))
+
+	// the route of the port goes to the port cluster regardless of the subsets
+	grpcRoute := actual.Routes.Items["route-egress-app:grpc"].(*v2.RouteConfiguration)
+	assert.Equal(t, 1, len(grpcRoute.VirtualHosts[0].Routes))
+	action := grpcRoute.VirtualHosts[0].Routes[0].GetRoute()
+	assert.Equal(t, "egress-app:grpc", action.GetCluster())
+	assert.Equal(t, 300*time.Millisecond, *action.Timeout)
+	_, ok = actual.Routes.Items["route-egress-app:admin"]
+	assert.False(t, ok)
+	assert.NoError(t, actual.Consistent())
+}
+
+func TestMakeSnapshotPortPolicies(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := mcore.NewCurrentTimeGenerator()
+	inventory := mcore.NewInventoryService(repo, nil, gen, logrus.New())
+	conf := model.EnvoyConf{
+		ClusterTimeoutMS: 2000,
+		AccessLogDir:     "/var/log/test",
+	}
+	sut := NewSnapshotGen(inventory, logrus.New(), gen, conf, nil)
+
+	app := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Ports: []model.ServicePort{
+			{Name: "grpc", Protocol: model.ProtocolGRPC, IngressPort: 8081, SubstancePort: 9091},
+		},
+		RateLimit:         &model.RateLimit{RequestsPerSecond: 100},
+		HealthCheck:       &model.ActiveHealthCheck{IntervalMS: 1000, TimeoutMS: 100, HealthyThreshold: 1, UnhealthyThreshold: 1},
+		HealthCheckFilter: &model.HealthCheckFilter{Mode: model.HealthCheckFilterPassThrough},
+		Hosts: []model.Host{{
+			Name:          "app1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+	}
+	_, err := inventory.IdempotentService("app", app)
+	assert.NoError(t, err)
+
+	// the named port is protected as well as the default port
+	shots, err := sut.MakeSnapshotsOfService("app")
+	assert.NoError(t, err)
+	actual, ok := FindSnapshotByName(shots, "app1")
+	assert.True(t, ok)
+	l := actual.Listeners.Items["listener-ingress:grpc-19216801-8081"].(*v2.Listener)
+	manager := &hcm.HttpConnectionManager{}
+	assert.NoError(t, util.StructToMessage(l.FilterChains[0].Filters[0].Config, manager))
+	filterNames := []string{}
+	for _, f := range manager.HttpFilters {
+		filterNames = append(filterNames, f.Name)
+	}
+	assert.Equal(t, []string{"envoy.health_check", LuaFilter, GRPCHTTP1BridgeFilter, cache.Router}, filterNames)
+	script := &lua.Lua{}
+	assert.NoError(t, util.StructToMessage(manager.HttpFilters[1].Config, script))
+	assert.Contains(t, script.InlineCode, "local tokens_per_fill = 100\n")
+	grpcCluster := actual.Clusters.Items["ingress:grpc"].(*v2.Cluster)
+	assert.Equal(t, 1, len(grpcCluster.HealthChecks))
+	assert.NotNil(t, grpcCluster.HealthChecks[0].GetGrpcHealthCheck())
+	assert.NoError(t, actual.Consistent())
+}
+
+func TestMakeSnapshotLabels(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := mcore.NewCurrentTimeGenerator()
+	inventory := mcore.NewInventoryService(repo, nil, gen, logrus.New())
+	conf := model.EnvoyConf{
+		ClusterTimeoutMS: 2000,
+		AccessLogDir:     "/var/log/test",
+	}
+	sut := NewSnapshotGen(inventory, logrus.New(), gen, conf, nil)
+
+	app := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Labels:   map[string]string{"team": "payments", "tier": "web"},
+		Hosts: []model.Host{{
+			Name:          "app1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+			Labels:        map[string]string{"tier": "batch"},
+		}},
+	}
+	front := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Hosts: []model.Host{{
+			Name:          "front1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.2", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+		DependentServices: []model.DependentService{{Name: "app", EgressPort: 9001}},
+	}
+	_, err := inventory.IdempotentService("app", app)
+	assert.NoError(t, err)
+	_, err = inventory.IdempotentService("front", front)
+	assert.NoError(t, err)
+
+	shots, err := sut.MakeSnapshotsOfService("front")
+	assert.NoError(t, err)
+	actual, ok := FindSnapshotByName(shots, "front1")
+	assert.True(t, ok)
+	egress := actual.Endpoints.Items["egress-app"].(*v2.ClusterLoadAssignment)
+	metadata := egress.Endpoints[0].LbEndpoints[0].Metadata.FilterMetadata[LabelMetadataNamespace]
+	assert.Equal(t, 2, len(metadata.Fields))
+	assert.Equal(t, "payments", metadata.Fields["team"].GetStringValue())
+	assert.Equal(t, "batch", metadata.Fields["tier"].GetStringValue())
+
+	// no metadata without labels
+	ingress := actual.Endpoints.Items["ingress"].(*v2.ClusterLoadAssignment)
+	assert.Nil(t, ingress.Endpoints[0].LbEndpoints[0].Metadata)
+	assert.NoError(t, actual.Consistent())
+}
+
+func TestMakeSnapshotHeaders(t *testing.T) {
+	repo := repository.NewInventoryHeap()
+	gen := mcore.NewCurrentTimeGenerator()
+	inventory := mcore.NewInventoryService(repo, nil, gen, logrus.New())
+	conf := model.EnvoyConf{
+		ClusterTimeoutMS: 2000,
+		AccessLogDir:     "/var/log/test",
+	}
+	sut := NewSnapshotGen(inventory, logrus.New(), gen, conf, nil)
+
+	app := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Headers: &model.HeaderPolicy{
+			ResponseHeadersToAdd:    []model.HeaderValue{{Name: "x-served-by", Value: "${host}"}},
+			RequestHeadersToRemove:  []string{"x-internal-user", "x-internal-role"},
+			ResponseHeadersToRemove: []string{"x-internal-debug"},
+		},
+		Hosts: []model.Host{{
+			Name:          "app1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.1", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+	}
+	front := model.IdempotentServiceParam{
+		Protocol: model.ProtocolHTTP,
+		Hosts: []model.Host{{
+			Name:          "front1",
+			IngressAddr:   model.Address{Hostname: "192.168.0.2", Port: 80},
+			SubstanceAddr: model.Address{Hostname: "127.0.0.1", Port: 8080},
+			EgressHost:    "127.0.0.1",
+		}},
+		DependentServices: []model.DependentService{{
+			Name:       "app",
+			EgressPort: 9001,
+			Headers: &model.HeaderPolicy{
+				RequestHeadersToAdd: []model.HeaderValue{
+					{Name: "x-caller-service", Value: "${service}"},
+					{Name: "x-caller", Value: "${host}/${namespace} 100%", Append: true},
+				},
+			},
+		}},
+	}
+	_, err := inventory.IdempotentService("app", app)
+	assert.NoError(t, err)
+	_, err = inventory.IdempotentService("front", front)
+	assert.NoError(t, err)
+
+	// ingress virtual host
+	shots, err := sut.MakeSnapshotsOfService("app")
+	assert.NoError(t, err)
+	actual, ok := FindSnapshotByName(shots, "app1")
+	assert.True(t, ok)
+	vh := actual.Routes.Items["route-ingress"].(*v2.RouteConfiguration).VirtualHosts[0]
+	assert.Equal(t, 1, len(vh.ResponseHeadersToAdd))
+	assert.Equal(t, "x-served-by", vh.ResponseHeadersToAdd[0].Header.Key)
+	assert.Equal(t, "app1", vh.ResponseHeadersToAdd[0].Header.Value)
+	assert.False(t, vh.ResponseHeadersToAdd[0].Append.Value)
+	assert.Equal(t, []string{"x-internal-debug"}, vh.ResponseHeadersToRemove)
+	assert.Empty(t, vh.RequestHeadersToAdd)
+	// the request headers are removed by the ingress listener
+	l := actual.Listeners.Items["listener-ingress-19216801-80"].(*v2.Listener)
+	manager := &hcm.HttpConnectionManager{}
+	assert.NoError(t, util.StructToMessage(l.FilterChains[0].Filters[0].Config, manager))
+	assert.Equal(t, 2, len(manager.HttpFilters))
+	assert.Equal(t, LuaFilter, manager.HttpFilters[0].Name)
+	script := &lua.Lua{}
+	assert.NoError(t, util.StructToMessage(manager.HttpFilters[0].Config, script))
+	assert.NoError(t, script.Validate())
+	assert.Contains(t, script.InlineCode, `local headers = {"x-internal-user", "x-internal-role"}`)
+
+	// egress virtual host of the dependency
+	shots, err = sut.MakeSnapshotsOfService("front")
+	assert.NoError(t, err)
+	actual, ok = FindSnapshotByName(shots, "front1")
+	assert.True(t, ok)
+	vh = actual.Routes.Items["route-egress-app"].(*v2.RouteConfiguration).VirtualHosts[0]
+	assert.Equal(t, 2, len(vh.RequestHeadersToAdd))
+	assert.Equal(t, "x-caller-service", vh.RequestHeadersToAdd[0].Header.Key)
+	assert.Equal(t, "front", vh.RequestHeadersToAdd[0].Header.Value)
+	assert.Equal(t, "front1/default 100%%", vh.RequestHeadersToAdd[1].Header.Value)
+	assert.True(t, vh.RequestHeadersToAdd[1].Append.Value)
+	assert.Empty(t, vh.ResponseHeadersToRemove)
+	l = actual.Listeners.Items["listener-egress-app-127001-9001"].(*v2.Listener)
+	manager = &hcm.HttpConnectionManager{}
+	assert.NoError(t, util.StructToMessage(l.FilterChains[0].Filters[0].Config, manager))
+	assert.Equal(t, 1, len(manager.HttpFilters))
+	// the ingress of the caller is not affected
+	vh = actual.Routes.Items["route-ingress"].(*v2.RouteConfiguration).VirtualHosts[0]
+	assert.Empty(t, vh.RequestHeadersToAdd)
+	assert.NoError(t, actual.Consistent())
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/rerorero/meshem/src/core"
+	"github.com/rerorero/meshem/src/core/ca"
 	"github.com/rerorero/meshem/src/core/ctlapi"
 	"github.com/rerorero/meshem/src/core/xds"
 	"github.com/rerorero/meshem/src/repository"
@@ -61,7 +62,16 @@ func main() {
 	// inventory
 	versionGen := core.NewCurrentTimeGenerator()
 	inventoryService := core.NewInventoryService(inventoryRepo, discoveryRepo, versionGen, logger)
-	xdsServer := xds.NewXDSServer(inventoryService, versionGen, *conf, ctx, logger)
+
+	// certificate authority for mutual TLS
+	var authority ca.CertificateAuthority
+	if conf.MTLS != nil {
+		authority, err = ca.NewCertificateAuthority(conf.MTLS, logger)
+		if err != nil {
+			ExitError(errors.Wrap(err, "failed to initialize CA"))
+		}
+	}
+	xdsServer := xds.NewXDSServer(inventoryService, versionGen, *conf, authority, ctx, logger)
 
 	// start control api server
 	apiServer := ctlapi.NewServer(inventoryService, conf.CtlAPI, logger)
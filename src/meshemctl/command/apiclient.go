@@ -9,8 +9,18 @@ import (
 
 	"github.com/rerorero/meshem/src/core/ctlapi"
 	"github.com/rerorero/meshem/src/model"
+	"github.com/spf13/cobra"
 )
 
+var (
+	namespace string
+)
+
+// addNamespaceFlag adds the flag of the namespace from which the names in the arguments are resolved.
+func addNamespaceFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVarP(&namespace, "namespace", "n", "", "Namespace of the services and hosts, the default namespace is used if it's omitted")
+}
+
 // NewAPIClient creates a new APIClient inscance.
 func NewAPIClient() (*ctlapi.APIClient, error) {
 	endpoint := os.Getenv("MESHEM_CTLAPI_ENDPOINT")
@@ -28,5 +38,10 @@ func NewAPIClient() (*ctlapi.APIClient, error) {
 	}
 	timeoutDuration := time.Duration(t) * time.Second
 
-	return ctlapi.NewClient(endpoint, timeoutDuration)
+	client, err := ctlapi.NewClient(endpoint, timeoutDuration)
+	if err != nil {
+		return nil, err
+	}
+	client.SetNamespace(namespace)
+	return client, nil
 }
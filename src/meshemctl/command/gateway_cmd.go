@@ -0,0 +1,95 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/rerorero/meshem/src/model"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// NewGatewayCommand returns the command object for 'gateway'.
+func NewGatewayCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gateway <subcommand>",
+		Short: "Gateway related commands",
+	}
+	cmd.AddCommand(newApplyGatewayCommand())
+	cmd.AddCommand(newDeleteGatewayCommand())
+	return cmd
+}
+
+func newApplyGatewayCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apply <gatewayname> -f <filename>",
+		Short: "Apply a configuration to a gateway by filename",
+		Run:   applyGateway,
+	}
+	cmd.Flags().StringVarP(&filePath, "filepath", "f", "", "(required) File path that defines the gateway")
+	return cmd
+}
+
+func newDeleteGatewayCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete <gatewayname>",
+		Short: "Delete a gateway",
+		Run:   deleteGateway,
+	}
+}
+
+func applyGateway(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		ExitWithError(errors.New("command needs an argument as gateway name"))
+	}
+	gatewayName := args[0]
+	if len(filePath) == 0 {
+		ExitWithError(fmt.Errorf("command needs --filepath argument"))
+	}
+
+	buf, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		ExitWithError(errors.Wrapf(err, "could not read resource file(%s)", filePath))
+	}
+
+	var param model.GatewayParam
+	err = yaml.Unmarshal(buf, &param)
+	if err != nil {
+		ExitWithError(errors.Wrapf(err, "failed to parse resource file(%s)", filePath))
+	}
+
+	client, err := NewAPIClient()
+	if err != nil {
+		ExitWithError(err)
+	}
+
+	resp, _, err := client.PutGateway(gatewayName, param)
+	if err != nil {
+		ExitWithError(err)
+	}
+
+	fmt.Printf("OK (Changed=%t)\n", resp.Changed)
+}
+
+func deleteGateway(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		ExitWithError(errors.New("command needs an argument as gateway name"))
+	}
+
+	client, err := NewAPIClient()
+	if err != nil {
+		ExitWithError(err)
+	}
+
+	status, err := client.DeleteGateway(args[0])
+	if err != nil {
+		ExitWithError(err)
+	}
+	if status != http.StatusNoContent {
+		ExitWithError(fmt.Errorf("failed to delete the gateway(%s): status=%d", args[0], status))
+	}
+
+	fmt.Println("OK")
+}
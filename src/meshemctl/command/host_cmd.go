@@ -0,0 +1,93 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/rerorero/meshem/src/core/ctlapi"
+	"github.com/spf13/cobra"
+)
+
+// NewHostCommand returns the command object for 'host'.
+func NewHostCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "host <subcommand>",
+		Short: "Host related commands",
+	}
+	cmd.AddCommand(newHostStateCommand())
+	cmd.AddCommand(newListHostCommand())
+	addNamespaceFlag(cmd)
+	return cmd
+}
+
+func newHostStateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "state <servicename> <hostname> <active|draining|maintenance>",
+		Short: "Change the state of a host",
+		Run:   setHostState,
+	}
+}
+
+func setHostState(cmd *cobra.Command, args []string) {
+	if len(args) != 3 {
+		ExitWithError(errors.New("command needs arguments as service name, host name and state"))
+	}
+	serviceName, hostName, state := args[0], args[1], args[2]
+
+	client, err := NewAPIClient()
+	if err != nil {
+		ExitWithError(err)
+	}
+
+	resp, status, err := client.PutHostState(serviceName, hostName, ctlapi.PutHostStateReq{State: state})
+	if err != nil {
+		ExitWithError(err)
+	}
+	if status != http.StatusOK {
+		ExitWithError(fmt.Errorf("failed to change the state of the host(%s): status=%d", hostName, status))
+	}
+	byte, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		ExitWithError(errors.Wrapf(err, "failed to parse the response as JSON: %+v", resp))
+	}
+
+	fmt.Println(string(byte))
+}
+
+func newListHostCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list <servicename> [-l <selector>]",
+		Short: "List the hosts of a service which match the label selector such as 'team=payments,tier!=batch'",
+		Run:   listHosts,
+	}
+	cmd.Flags().StringVarP(&labelSelector, "selector", "l", "", "Label selector to filter the hosts")
+	return cmd
+}
+
+func listHosts(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		ExitWithError(errors.New("command needs an argument as service name"))
+	}
+	serviceName := args[0]
+
+	client, err := NewAPIClient()
+	if err != nil {
+		ExitWithError(err)
+	}
+
+	resp, status, err := client.GetHosts(serviceName, labelSelector)
+	if err != nil {
+		ExitWithError(err)
+	}
+	if status != http.StatusOK {
+		ExitWithError(fmt.Errorf("failed to list the hosts of the service(%s): status=%d", serviceName, status))
+	}
+	byte, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		ExitWithError(errors.Wrapf(err, "failed to parse the response as JSON: %+v", resp))
+	}
+
+	fmt.Println(string(byte))
+}
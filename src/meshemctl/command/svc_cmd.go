@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 
 	"github.com/pkg/errors"
 	"github.com/rerorero/meshem/src/model"
@@ -12,7 +13,8 @@ import (
 )
 
 var (
-	filePath string
+	filePath      string
+	labelSelector string
 )
 
 // NewServiceCommand returns the command object for 'svc'.
@@ -22,6 +24,8 @@ func NewServiceCommand() *cobra.Command {
 		Short: "Service related commands",
 	}
 	cmd.AddCommand(newApplyServiceCommand())
+	cmd.AddCommand(newListServiceCommand())
+	addNamespaceFlag(cmd)
 	return cmd
 }
 
@@ -68,6 +72,37 @@ func applyService(cmd *cobra.Command, args []string) {
 	fmt.Printf("OK (Changed=%t)\n", resp.Changed)
 }
 
+func newListServiceCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list [-l <selector>]",
+		Short: "List the services which match the label selector such as 'team=payments,tier!=batch', in all namespaces unless --namespace is given",
+		Run:   listServices,
+	}
+	cmd.Flags().StringVarP(&labelSelector, "selector", "l", "", "Label selector to filter the services")
+	return cmd
+}
+
+func listServices(cmd *cobra.Command, args []string) {
+	client, err := NewAPIClient()
+	if err != nil {
+		ExitWithError(err)
+	}
+
+	resp, status, err := client.GetServices(labelSelector)
+	if err != nil {
+		ExitWithError(err)
+	}
+	if status != http.StatusOK {
+		ExitWithError(fmt.Errorf("failed to list the services: status=%d", status))
+	}
+	byte, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		ExitWithError(errors.Wrapf(err, "failed to parse the response as JSON: %+v", resp))
+	}
+
+	fmt.Println(string(byte))
+}
+
 func showService(cmd *cobra.Command, args []string) {
 	if len(args) != 1 {
 		ExitWithError(errors.New("command needs an argument as service name"))
@@ -15,6 +15,8 @@ var (
 func init() {
 	rootCmd.AddCommand(command.NewVersionCommand())
 	rootCmd.AddCommand(command.NewServiceCommand())
+	rootCmd.AddCommand(command.NewHostCommand())
+	rootCmd.AddCommand(command.NewGatewayCommand())
 }
 
 func main() {
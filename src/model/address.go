@@ -2,35 +2,112 @@ package model
 
 import (
 	"fmt"
+	"net"
+	"path"
 	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
 )
 
+// Address is a pair of host and port, Hostname of IPv6 is held without brackets.
 type Address struct {
 	Hostname string `json:"host" yaml:"host"`
 	Port     uint32 `json:"port" yaml:"port"`
+	// Path is the filesystem path of the unix domain socket, Hostname and Port are ignored if it's set.
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
 }
 
+const (
+	// UnixSocketScheme is the prefix of the unix domain socket address string.
+	UnixSocketScheme = "unix://"
+	// maxUnixSocketPathLen is the limit of sun_path excluding the null terminator.
+	maxUnixSocketPathLen = 107
+)
+
 func (addr *Address) String() string {
-	return fmt.Sprintf("%s:%d", addr.Hostname, addr.Port)
+	if addr.IsPipe() {
+		return UnixSocketScheme + addr.Path
+	}
+	return net.JoinHostPort(addr.Hostname, strconv.FormatUint(uint64(addr.Port), 10))
 }
 
+// ParseAddress parses 'host:port' string, IPv6 literal should be bracketed like '[::1]:80'.
+// The unix domain socket is written like 'unix:///run/app.sock'.
 func ParseAddress(s string) (*Address, error) {
-	pair := strings.Split(s, ":")
-	if len(pair) != 2 {
+	if strings.HasPrefix(s, UnixSocketScheme) {
+		path := strings.TrimPrefix(s, UnixSocketScheme)
+		if len(path) == 0 {
+			return nil, fmt.Errorf("Invalid Address: %s", s)
+		}
+		return &Address{Path: path}, nil
+	}
+	host, portstr, err := net.SplitHostPort(s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid Address: %s", s)
+	}
+	if strings.Contains(host, ":") && !isIPv6(host) {
 		return nil, fmt.Errorf("Invalid Address: %s", s)
 	}
-	port, err := strconv.Atoi(pair[1])
+	port, err := strconv.ParseUint(portstr, 10, 16)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Invalid port address: %s", s)
 	}
 
-	return &Address{pair[0], uint32(port)}, nil
+	return &Address{Hostname: host, Port: uint32(port)}, nil
 }
 
+// ListenerSuffix returns a string which identifies the address in listener names.
+// IPv6 address is canonicalized and its colons are replaced with underscores to keep it unique.
 // TODO: replaced with hash?
 func (addr *Address) ListenerSuffix() string {
+	if isIPv6(addr.Hostname) {
+		ip := net.ParseIP(addr.Hostname).String()
+		return fmt.Sprintf("%s-%d", strings.Replace(ip, ":", "_", -1), addr.Port)
+	}
 	return fmt.Sprintf("%s-%d", strings.Replace(addr.Hostname, ".", "", -1), addr.Port)
 }
+
+// IsPipe returns true if the address is an unix domain socket.
+func (addr *Address) IsPipe() bool {
+	return len(addr.Path) > 0
+}
+
+// validatePipe checks that the unix domain socket path can be bound.
+func (addr *Address) validatePipe() error {
+	if !path.IsAbs(addr.Path) {
+		return fmt.Errorf("unix socket path must be absolute: %s", addr.Path)
+	}
+	if len(addr.Path) > maxUnixSocketPathLen {
+		return fmt.Errorf("unix socket path must be less than or equal to %d bytes: %s", maxUnixSocketPathLen, addr.Path)
+	}
+	return nil
+}
+
+// Conflicts returns true if the both addresses can not be listened at the same time.
+func (addr *Address) Conflicts(other *Address) bool {
+	if addr.IsPipe() || other.IsPipe() {
+		return addr.Path == other.Path
+	}
+	if addr.Port != other.Port {
+		return false
+	}
+	return addr.canonicalHost() == other.canonicalHost() || addr.isWildcard() || other.isWildcard()
+}
+
+func (addr *Address) isWildcard() bool {
+	return addr.Hostname == "0.0.0.0" || addr.Hostname == "::"
+}
+
+// canonicalHost returns the hostname, IPv6 literals which are written differently are normalized.
+func (addr *Address) canonicalHost() string {
+	if isIPv6(addr.Hostname) {
+		return net.ParseIP(addr.Hostname).String()
+	}
+	return addr.Hostname
+}
+
+// isIPv6 returns true if the string is an IPv6 literal without brackets.
+func isIPv6(host string) bool {
+	return strings.Contains(host, ":") && net.ParseIP(host) != nil
+}
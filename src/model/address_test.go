@@ -9,7 +9,7 @@ import (
 func TestParseAddress(t *testing.T) {
 	addr, err := ParseAddress("1.2.3.4:5678")
 	assert.NoError(t, err)
-	assert.Equal(t, addr, &Address{"1.2.3.4", 5678})
+	assert.Equal(t, addr, &Address{Hostname: "1.2.3.4", Port: 5678})
 
 	_, err = ParseAddress("1.2.3.4")
 	assert.Error(t, err)
@@ -19,4 +19,58 @@ func TestParseAddress(t *testing.T) {
 
 	_, err = ParseAddress("1.2.3.4:f")
 	assert.Error(t, err)
+
+	_, err = ParseAddress("1.2.3.4:70000")
+	assert.Error(t, err)
+
+	addr, err = ParseAddress("[2001:db8::1]:9000")
+	assert.NoError(t, err)
+	assert.Equal(t, addr, &Address{Hostname: "2001:db8::1", Port: 9000})
+	assert.Equal(t, "[2001:db8::1]:9000", addr.String())
+
+	_, err = ParseAddress("2001:db8::1:9000")
+	assert.Error(t, err)
+
+	_, err = ParseAddress("[2001:db8::1]")
+	assert.Error(t, err)
+
+	_, err = ParseAddress("[2001:db8:::1]:9000")
+	assert.Error(t, err)
+}
+
+func TestParseUnixSocketAddress(t *testing.T) {
+	addr, err := ParseAddress("unix:///run/app.sock")
+	assert.NoError(t, err)
+	assert.Equal(t, &Address{Path: "/run/app.sock"}, addr)
+	assert.True(t, addr.IsPipe())
+	assert.Equal(t, "unix:///run/app.sock", addr.String())
+
+	_, err = ParseAddress("unix://")
+	assert.Error(t, err)
+
+	assert.True(t, addr.Conflicts(&Address{Path: "/run/app.sock"}))
+	assert.False(t, addr.Conflicts(&Address{Path: "/run/other.sock"}))
+	assert.False(t, addr.Conflicts(&Address{Hostname: "0.0.0.0", Port: 0}))
+}
+
+func TestAddressListenerSuffix(t *testing.T) {
+	assert.Equal(t, "19216801-8001", (&Address{Hostname: "192.168.0.1", Port: 8001}).ListenerSuffix())
+	assert.Equal(t, "2001_db8__1-8001", (&Address{Hostname: "2001:db8::1", Port: 8001}).ListenerSuffix())
+	assert.Equal(t, "2001_db8__1-8001", (&Address{Hostname: "2001:DB8:0:0::1", Port: 8001}).ListenerSuffix())
+	assert.NotEqual(t, (&Address{Hostname: "2001:db8::1", Port: 8001}).ListenerSuffix(), (&Address{Hostname: "2001:db:81::", Port: 8001}).ListenerSuffix())
+}
+
+func TestAddressConflicts(t *testing.T) {
+	addr := &Address{Hostname: "192.168.0.1", Port: 8001}
+	assert.True(t, addr.Conflicts(&Address{Hostname: "192.168.0.1", Port: 8001}))
+	assert.True(t, addr.Conflicts(&Address{Hostname: "0.0.0.0", Port: 8001}))
+	assert.False(t, addr.Conflicts(&Address{Hostname: "192.168.0.1", Port: 8002}))
+	assert.False(t, addr.Conflicts(&Address{Hostname: "127.0.0.1", Port: 8001}))
+
+	addr6 := &Address{Hostname: "2001:db8::1", Port: 8001}
+	assert.True(t, addr6.Conflicts(&Address{Hostname: "2001:db8:0::1", Port: 8001}))
+	assert.True(t, addr6.Conflicts(&Address{Hostname: "::", Port: 8001}))
+	assert.True(t, addr.Conflicts(&Address{Hostname: "::", Port: 8001}))
+	assert.False(t, addr6.Conflicts(&Address{Hostname: "2001:db8::2", Port: 8001}))
+	assert.False(t, addr6.Conflicts(&Address{Hostname: "::", Port: 8002}))
 }
@@ -19,6 +19,8 @@ type XDSConf struct {
 	Port                      uint32 `yaml:"port,omitempty"`
 	CacheCollectionIntervalMS int    `yaml:"cache_collection_interval_ms,omitempty"`
 	IsADSMode                 bool   `yaml:"ads_mode,omitempty"`
+	// DrainGracePeriodMS is the period after which the draining hosts are removed.
+	DrainGracePeriodMS int `yaml:"drain_grace_period_ms,omitempty"`
 }
 
 // ConsulConf relates to consul.
@@ -39,6 +41,17 @@ type DiscoveryConf struct {
 	Consul *ConsulConf `yaml:"consul,omitempty"`
 }
 
+// MTLSConf relates to mutual TLS between the sidecars. This is optional
+// The CA is generated on startup if the CA files are not specified.
+type MTLSConf struct {
+	Mode               string `yaml:"mode"`
+	TrustDomain        string `yaml:"trust_domain,omitempty"`
+	CACertFile         string `yaml:"ca_cert_file,omitempty"`
+	CAKeyFile          string `yaml:"ca_key_file,omitempty"`
+	CertTTLMinutes     int    `yaml:"cert_ttl_minutes,omitempty"`
+	RenewBeforeMinutes int    `yaml:"renew_before_minutes,omitempty"`
+}
+
 // MeshemConf is configurations for conductor server.
 type MeshemConf struct {
 	Envoy     EnvoyConf      `yaml:"envoy"`
@@ -46,6 +59,7 @@ type MeshemConf struct {
 	Consul    ConsulConf     `yaml:"consul"`
 	CtlAPI    CtlAPIConf     `yaml:"ctlapi"`
 	Discovery *DiscoveryConf `yaml:"discovery"`
+	MTLS      *MTLSConf      `yaml:"mtls,omitempty"`
 }
 
 const (
@@ -55,6 +69,12 @@ const (
 	DefaultCtrlAPIPort = 8091
 	// DiscoveryTypeConsul is set to use consul discovery service
 	DiscoveryTypeConsul = "consul"
+	// MTLSModeStrict accepts only mutual TLS connections.
+	MTLSModeStrict = "strict"
+	// MTLSModePermissive accepts both mutual TLS and plaintext connections.
+	MTLSModePermissive = "permissive"
+	// DefaultTrustDomain is default trust domain of the SPIFFE identities.
+	DefaultTrustDomain = "meshem.local"
 )
 
 // NewMeshemConfFile parses configuration file.
@@ -88,6 +108,9 @@ func NewMeshemConfYaml(yamlbytes []byte) (*MeshemConf, error) {
 	if conf.XDS.CacheCollectionIntervalMS == 0 {
 		conf.XDS.CacheCollectionIntervalMS = 10000
 	}
+	if conf.XDS.DrainGracePeriodMS == 0 {
+		conf.XDS.DrainGracePeriodMS = 60000
+	}
 	if len(conf.Consul.Datacenter) == 0 {
 		conf.Consul.Datacenter = "dc1"
 	}
@@ -110,5 +133,42 @@ func NewMeshemConfYaml(yamlbytes []byte) (*MeshemConf, error) {
 		}
 	}
 
+	if conf.MTLS != nil {
+		switch conf.MTLS.Mode {
+		case MTLSModeStrict, MTLSModePermissive:
+		default:
+			return nil, fmt.Errorf("invalid mtls mode: %s", conf.MTLS.Mode)
+		}
+		// the ADS stream of the snapshot cache can't deliver the secrets, they are only served by the SDS service
+		if conf.XDS.IsADSMode {
+			return nil, fmt.Errorf("mtls is not supported in ads mode, disable xds.ads_mode to enable mtls")
+		}
+		if len(conf.MTLS.TrustDomain) == 0 {
+			conf.MTLS.TrustDomain = DefaultTrustDomain
+		}
+		if (len(conf.MTLS.CACertFile) == 0) != (len(conf.MTLS.CAKeyFile) == 0) {
+			return nil, fmt.Errorf("both of mtls.ca_cert_file and mtls.ca_key_file should be set")
+		}
+		if conf.MTLS.CertTTLMinutes == 0 {
+			conf.MTLS.CertTTLMinutes = 24 * 60
+		}
+		if conf.MTLS.RenewBeforeMinutes == 0 {
+			conf.MTLS.RenewBeforeMinutes = conf.MTLS.CertTTLMinutes / 3
+		}
+		if conf.MTLS.RenewBeforeMinutes >= conf.MTLS.CertTTLMinutes {
+			return nil, fmt.Errorf("mtls.renew_before_minutes should be less than mtls.cert_ttl_minutes")
+		}
+	}
+
 	return conf, nil
 }
+
+// SpiffeID returns the identity of the hosts of the service.
+func (conf *MTLSConf) SpiffeID(serviceName string) string {
+	return fmt.Sprintf("spiffe://%s/service/%s", conf.TrustDomain, serviceName)
+}
+
+// GatewaySpiffeID returns the identity of the nodes of the gateway.
+func (conf *MTLSConf) GatewaySpiffeID(gatewayName string) string {
+	return fmt.Sprintf("spiffe://%s/gateway/%s", conf.TrustDomain, gatewayName)
+}
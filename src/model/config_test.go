@@ -0,0 +1,48 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMeshemConfYamlMTLS(t *testing.T) {
+	conf, err := NewMeshemConfYaml([]byte(`
+mtls:
+  mode: permissive
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, &MTLSConf{
+		Mode:               MTLSModePermissive,
+		TrustDomain:        DefaultTrustDomain,
+		CertTTLMinutes:     24 * 60,
+		RenewBeforeMinutes: 8 * 60,
+	}, conf.MTLS)
+	assert.Equal(t, "spiffe://meshem.local/service/app", conf.MTLS.SpiffeID("app"))
+
+	// disabled
+	conf, err = NewMeshemConfYaml([]byte(`xds: {port: 1234}`))
+	assert.NoError(t, err)
+	assert.Nil(t, conf.MTLS)
+
+	invalids := []string{
+		`mtls: {mode: unknown}`,
+		`mtls: {mode: strict, ca_cert_file: /etc/ca.pem}`,
+		`mtls: {mode: strict, cert_ttl_minutes: 60, renew_before_minutes: 60}`,
+		"xds: {ads_mode: true}\nmtls: {mode: strict}",
+	}
+	for _, y := range invalids {
+		_, err = NewMeshemConfYaml([]byte(y))
+		assert.Error(t, err, y)
+	}
+}
+
+func TestNewMeshemConfYamlDrainGracePeriod(t *testing.T) {
+	conf, err := NewMeshemConfYaml([]byte(`xds: {port: 1234}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 60000, conf.XDS.DrainGracePeriodMS)
+
+	conf, err = NewMeshemConfYaml([]byte(`xds: {drain_grace_period_ms: 5000}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 5000, conf.XDS.DrainGracePeriodMS)
+}
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExternalService is a service outside of the mesh, its endpoints are resolved by DNS instead of the hosts.
+type ExternalService struct {
+	Hostname string `json:"hostname" yaml:"hostname"`
+	Port     uint32 `json:"port" yaml:"port"`
+	// Resolution is the way envoy resolves the hostname, ResolutionStrictDNS is used if it's empty.
+	Resolution string `json:"resolution,omitempty" yaml:"resolution,omitempty"`
+	// TLS originates TLS to the external service if it's set, the callers send plaintext to their egress.
+	TLS *ExternalTLS `json:"tls,omitempty" yaml:"tls,omitempty"`
+}
+
+// ExternalTLS is the TLS settings to connect to the external service.
+type ExternalTLS struct {
+	// SNI is the server name sent in the handshake, the hostname of the external service is used if it's empty.
+	SNI string `json:"sni,omitempty" yaml:"sni,omitempty"`
+	// CAFile is the path of the CA certificates on the hosts to verify the server, it's required unless InsecureSkipVerify.
+	CAFile string `json:"caFile,omitempty" yaml:"caFile,omitempty"`
+	// InsecureSkipVerify connects to the server without verifying its certificate.
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty" yaml:"insecureSkipVerify,omitempty"`
+}
+
+const (
+	// ResolutionStrictDNS balances the requests across all the resolved addresses.
+	ResolutionStrictDNS = "strictDNS"
+	// ResolutionLogicalDNS connects to the first resolved address, suitable for the large web services.
+	ResolutionLogicalDNS = "logicalDNS"
+)
+
+// Validate checks that the external service is valid.
+func (e *ExternalService) Validate() error {
+	if len(e.Hostname) == 0 {
+		return fmt.Errorf("hostname of the external service is required")
+	}
+	if strings.Contains(e.Hostname, ":") {
+		return fmt.Errorf("hostname of the external service can not contain port number: %s", e.Hostname)
+	}
+	if e.Port == 0 {
+		return fmt.Errorf("invalid port number of the external service(%d)", e.Port)
+	}
+	switch e.Resolution {
+	case "", ResolutionStrictDNS, ResolutionLogicalDNS:
+	default:
+		return fmt.Errorf("invalid resolution: %s", e.Resolution)
+	}
+	if e.TLS != nil {
+		if len(e.TLS.CAFile) == 0 && !e.TLS.InsecureSkipVerify {
+			return fmt.Errorf("caFile is required to verify the external service, set insecureSkipVerify to skip the verification")
+		}
+		if len(e.TLS.CAFile) > 0 && e.TLS.InsecureSkipVerify {
+			return fmt.Errorf("caFile and insecureSkipVerify can not be set at the same time")
+		}
+	}
+	return nil
+}
+
+// SNI returns the server name to originate TLS.
+func (e *ExternalService) SNI() string {
+	if e.TLS != nil && len(e.TLS.SNI) > 0 {
+		return e.TLS.SNI
+	}
+	return e.Hostname
+}
@@ -0,0 +1,172 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// Gateway is a set of standalone envoy nodes which receive the traffic from outside of the mesh
+// and route it to the services.
+type Gateway struct {
+	Name string `json:"name" yaml:"name"`
+	// Nodes are the node names of the envoys, which must be unique among the gateway nodes and the hosts.
+	Nodes     []string          `json:"nodes" yaml:"nodes"`
+	Listeners []GatewayListener `json:"listeners" yaml:"listeners"`
+	Version   Version           `json:"version" yaml:"version"`
+}
+
+// GatewayListener is a public listener of the gateway.
+type GatewayListener struct {
+	Address      Address              `json:"address" yaml:"address"`
+	TLS          *GatewayTLS          `json:"tls,omitempty" yaml:"tls,omitempty"`
+	VirtualHosts []GatewayVirtualHost `json:"virtualHosts" yaml:"virtualHosts"`
+}
+
+// GatewayTLS is the certificate which the listener serves, the files are read on the gateway nodes.
+type GatewayTLS struct {
+	CertFile string `json:"certFile" yaml:"certFile"`
+	KeyFile  string `json:"keyFile" yaml:"keyFile"`
+}
+
+// GatewayVirtualHost routes the requests to the domains by the rules which are evaluated in order.
+// The rules must specify the target services unless they redirect.
+type GatewayVirtualHost struct {
+	Name    string      `json:"name" yaml:"name"`
+	Domains []string    `json:"domains" yaml:"domains"`
+	Routes  []RouteRule `json:"routes" yaml:"routes"`
+}
+
+// GatewayParam is used as a parameter by updating a gateway idempotently.
+type GatewayParam struct {
+	Nodes     []string          `json:"nodes" yaml:"nodes"`
+	Listeners []GatewayListener `json:"listeners" yaml:"listeners"`
+}
+
+var (
+	rGatewayName = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,64}$`)
+)
+
+// NewGateway creates a Gateway object from a GatewayParam.
+func (param *GatewayParam) NewGateway(name string) Gateway {
+	return Gateway{
+		Name:      name,
+		Nodes:     param.Nodes,
+		Listeners: param.Listeners,
+	}
+}
+
+// NewGatewayParam creates a GatewayParam object from a gateway.
+func NewGatewayParam(gw *Gateway) GatewayParam {
+	return GatewayParam{
+		Nodes:     gw.Nodes,
+		Listeners: gw.Listeners,
+	}
+}
+
+// Validate checks Gateway object format.
+func (gw *Gateway) Validate() error {
+	if !rGatewayName.MatchString(gw.Name) {
+		return errors.New("gateway name must consist of alphanumeric characters, underscores and dashes, and less than 64 characters")
+	}
+
+	if len(gw.Nodes) == 0 {
+		return fmt.Errorf("gateway=%s has no nodes", gw.Name)
+	}
+	nodes := map[string]bool{}
+	for _, node := range gw.Nodes {
+		err := validateHostname(node)
+		if err != nil {
+			return errors.Wrapf(err, "invalid node of gateway=%s", gw.Name)
+		}
+		if nodes[node] {
+			return fmt.Errorf("duplicate nodes: %s", node)
+		}
+		nodes[node] = true
+	}
+
+	if len(gw.Listeners) == 0 {
+		return fmt.Errorf("gateway=%s has no listeners", gw.Name)
+	}
+	ports := map[uint32]bool{}
+	var i int
+	for i = 0; i < len(gw.Listeners); i++ {
+		l := &gw.Listeners[i]
+		if l.Address.Port == 0 {
+			return fmt.Errorf("invalid listener port number (gateway=%s, addr=%s)", gw.Name, l.Address.String())
+		}
+		if ports[l.Address.Port] {
+			return fmt.Errorf("duplicate listener port=%d (gateway=%s)", l.Address.Port, gw.Name)
+		}
+		ports[l.Address.Port] = true
+		if l.TLS != nil && (len(l.TLS.CertFile) == 0 || len(l.TLS.KeyFile) == 0) {
+			return fmt.Errorf("both of certFile and keyFile should be set (gateway=%s, port=%d)", gw.Name, l.Address.Port)
+		}
+		err := l.validateVirtualHosts()
+		if err != nil {
+			return errors.Wrapf(err, "invalid listener (gateway=%s, port=%d)", gw.Name, l.Address.Port)
+		}
+	}
+	return nil
+}
+
+func (l *GatewayListener) validateVirtualHosts() error {
+	if len(l.VirtualHosts) == 0 {
+		return errors.New("listener has no virtual hosts")
+	}
+	names := map[string]bool{}
+	domains := map[string]bool{}
+	var i int
+	for i = 0; i < len(l.VirtualHosts); i++ {
+		vh := &l.VirtualHosts[i]
+		if !rGatewayName.MatchString(vh.Name) {
+			return errors.New("virtual host name must consist of alphanumeric characters, underscores and dashes, and less than 64 characters")
+		}
+		if names[vh.Name] {
+			return fmt.Errorf("duplicate virtual host names: %s", vh.Name)
+		}
+		names[vh.Name] = true
+		if len(vh.Domains) == 0 {
+			return fmt.Errorf("virtual host=%s has no domains", vh.Name)
+		}
+		for _, domain := range vh.Domains {
+			if len(domain) == 0 || domains[domain] {
+				return fmt.Errorf("empty or duplicate domain: '%s'", domain)
+			}
+			domains[domain] = true
+		}
+		if len(vh.Routes) == 0 {
+			return fmt.Errorf("virtual host=%s has no routes", vh.Name)
+		}
+		var j int
+		for j = 0; j < len(vh.Routes); j++ {
+			rule := &vh.Routes[j]
+			err := rule.Validate()
+			if err != nil {
+				return errors.Wrapf(err, "invalid route rule[%d] of virtual host=%s", j, vh.Name)
+			}
+			if rule.Redirect == nil && len(rule.Service) == 0 {
+				return fmt.Errorf("route rule[%d] of virtual host=%s has no target service", j, vh.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// TargetServiceNames returns the names of all services to which the gateway routes.
+func (gw *Gateway) TargetServiceNames() (names []string) {
+	seen := map[string]bool{}
+	for _, l := range gw.Listeners {
+		for _, vh := range l.VirtualHosts {
+			for _, rule := range vh.Routes {
+				if rule.Redirect != nil || seen[rule.Service] {
+					continue
+				}
+				seen[rule.Service] = true
+				names = append(names, rule.Service)
+			}
+		}
+	}
+	return names
+}
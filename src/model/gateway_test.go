@@ -0,0 +1,70 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGatewayValidate(t *testing.T) {
+	valid := func() Gateway {
+		return Gateway{
+			Name:  "public",
+			Nodes: []string{"gw1", "gw2"},
+			Listeners: []GatewayListener{
+				{
+					Address: Address{Hostname: "0.0.0.0", Port: 80},
+					VirtualHosts: []GatewayVirtualHost{{
+						Name:    "redirect",
+						Domains: []string{"*"},
+						Routes:  []RouteRule{{Prefix: "/", Redirect: &RouteRedirect{Host: "example.com"}}},
+					}},
+				},
+				{
+					Address: Address{Hostname: "0.0.0.0", Port: 443},
+					TLS:     &GatewayTLS{CertFile: "/etc/cert.pem", KeyFile: "/etc/key.pem"},
+					VirtualHosts: []GatewayVirtualHost{
+						{
+							Name:    "api",
+							Domains: []string{"api.example.com"},
+							Routes:  []RouteRule{{Prefix: "/", Service: "api"}},
+						},
+						{
+							Name:    "front",
+							Domains: []string{"example.com", "www.example.com"},
+							Routes: []RouteRule{
+								{Prefix: "/static", Service: "static"},
+								{Prefix: "/", Service: "front", Subset: "v1"},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+	gw := valid()
+	assert.NoError(t, gw.Validate())
+	assert.Equal(t, []string{"api", "static", "front"}, gw.TargetServiceNames())
+
+	invalids := []func(*Gateway){
+		func(gw *Gateway) { gw.Name = "" },
+		func(gw *Gateway) { gw.Nodes = nil },
+		func(gw *Gateway) { gw.Nodes = []string{"gw1", "gw1"} },
+		func(gw *Gateway) { gw.Listeners = nil },
+		func(gw *Gateway) { gw.Listeners[0].Address.Port = 443 },
+		func(gw *Gateway) { gw.Listeners[0].Address.Port = 0 },
+		func(gw *Gateway) { gw.Listeners[1].TLS.KeyFile = "" },
+		func(gw *Gateway) { gw.Listeners[1].VirtualHosts = nil },
+		func(gw *Gateway) { gw.Listeners[1].VirtualHosts[1].Name = "api" },
+		func(gw *Gateway) { gw.Listeners[1].VirtualHosts[1].Domains = []string{"api.example.com"} },
+		func(gw *Gateway) { gw.Listeners[1].VirtualHosts[1].Domains = nil },
+		func(gw *Gateway) { gw.Listeners[1].VirtualHosts[1].Routes = nil },
+		func(gw *Gateway) { gw.Listeners[1].VirtualHosts[1].Routes[0].Service = "" },
+		func(gw *Gateway) { gw.Listeners[1].VirtualHosts[1].Routes[0].Prefix = "static" },
+	}
+	for i, invalidate := range invalids {
+		gw := valid()
+		invalidate(&gw)
+		assert.Error(t, gw.Validate(), i)
+	}
+}
@@ -0,0 +1,89 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/pkg/errors"
+	"github.com/rerorero/meshem/src/utils"
+)
+
+// HeaderValue is a header added to the requests or the responses.
+// The value can contain the variables such as '${service}' which are expanded for each host.
+type HeaderValue struct {
+	Name  string `json:"name" yaml:"name"`
+	Value string `json:"value" yaml:"value"`
+	// Append appends the value to the existing values of the header instead of overwriting them.
+	Append bool `json:"append,omitempty" yaml:"append,omitempty"`
+}
+
+// HeaderPolicy manipulates the headers of the requests and the responses.
+type HeaderPolicy struct {
+	RequestHeadersToAdd     []HeaderValue `json:"requestHeadersToAdd,omitempty" yaml:"requestHeadersToAdd,omitempty"`
+	RequestHeadersToRemove  []string      `json:"requestHeadersToRemove,omitempty" yaml:"requestHeadersToRemove,omitempty"`
+	ResponseHeadersToAdd    []HeaderValue `json:"responseHeadersToAdd,omitempty" yaml:"responseHeadersToAdd,omitempty"`
+	ResponseHeadersToRemove []string      `json:"responseHeadersToRemove,omitempty" yaml:"responseHeadersToRemove,omitempty"`
+}
+
+// HeaderVariables are the values of the variables in the header values.
+type HeaderVariables map[string]string
+
+const (
+	// HeaderVarService is expanded to the name of the service of the host, that is the calling service on egress.
+	HeaderVarService = "service"
+	// HeaderVarHost is expanded to the name of the host.
+	HeaderVarHost = "host"
+	// HeaderVarNamespace is expanded to the namespace of the service.
+	HeaderVarNamespace = "namespace"
+)
+
+var (
+	rHeaderName     = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,128}$`)
+	rHeaderVariable = regexp.MustCompile(`\$\{([^}]*)\}`)
+	allHeaderVars   = []string{HeaderVarService, HeaderVarHost, HeaderVarNamespace}
+)
+
+// Validate checks the header names and the variables in the values.
+func (p *HeaderPolicy) Validate() error {
+	for _, h := range append(append([]HeaderValue{}, p.RequestHeadersToAdd...), p.ResponseHeadersToAdd...) {
+		err := validateHeaderName(h.Name)
+		if err != nil {
+			return err
+		}
+		for _, match := range rHeaderVariable.FindAllStringSubmatch(h.Value, -1) {
+			if _, ok := utils.ContainsString(allHeaderVars, match[1]); !ok {
+				return fmt.Errorf("unknown variable %s in the value of header=%s", match[0], h.Name)
+			}
+		}
+	}
+	for _, name := range append(append([]string{}, p.RequestHeadersToRemove...), p.ResponseHeadersToRemove...) {
+		err := validateHeaderName(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateHeaderName(name string) error {
+	if !rHeaderName.MatchString(name) {
+		return errors.New("header name must consist of alphanumeric characters, underscores and dashes, and less than 128 characters")
+	}
+	return nil
+}
+
+// NewHeaderVariables returns the variables of the host which belongs to the service.
+func NewHeaderVariables(service *Service, host *Host) HeaderVariables {
+	return HeaderVariables{
+		HeaderVarService:   service.Name,
+		HeaderVarHost:      host.Name,
+		HeaderVarNamespace: service.Namespace(),
+	}
+}
+
+// Expand replaces the variables in the value with their values.
+func (vars HeaderVariables) Expand(value string) string {
+	return rHeaderVariable.ReplaceAllStringFunc(value, func(v string) string {
+		return vars[rHeaderVariable.FindStringSubmatch(v)[1]]
+	})
+}
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderPolicyValidate(t *testing.T) {
+	valid := HeaderPolicy{
+		RequestHeadersToAdd:     []HeaderValue{{Name: "x-caller-service", Value: "${service}"}, {Name: "x-caller", Value: "${host}.${namespace}", Append: true}},
+		ResponseHeadersToAdd:    []HeaderValue{{Name: "x-served-by", Value: "${host}"}, {Name: "x-ratio", Value: "100%"}},
+		RequestHeadersToRemove:  []string{"x-internal-user"},
+		ResponseHeadersToRemove: []string{"x-internal-debug"},
+	}
+	assert.NoError(t, valid.Validate())
+
+	invalids := []HeaderPolicy{
+		{RequestHeadersToAdd: []HeaderValue{{Name: "", Value: "a"}}},
+		{RequestHeadersToAdd: []HeaderValue{{Name: ":authority", Value: "a"}}},
+		{RequestHeadersToAdd: []HeaderValue{{Name: "x-caller", Value: "${unknown}"}}},
+		{ResponseHeadersToAdd: []HeaderValue{{Name: "x-caller", Value: "${service}-${}"}}},
+		{RequestHeadersToRemove: []string{"x-internal\""}},
+		{ResponseHeadersToRemove: []string{"x internal"}},
+	}
+	for _, p := range invalids {
+		assert.Error(t, p.Validate(), "%+v", p)
+	}
+
+	// only HTTP based services
+	svc := NewService("app", ProtocolHTTP)
+	svc.Headers = &valid
+	assert.NoError(t, svc.Validate())
+	svc.Protocol = ProtocolTCP
+	assert.Error(t, svc.Validate())
+	svc.Protocol = ProtocolHTTP
+	svc.DependentServices = []DependentService{{Name: "db", EgressPort: 9001, Headers: &invalids[2]}}
+	assert.Error(t, svc.Validate())
+}
+
+func TestHeaderVariables(t *testing.T) {
+	svc := NewService("api.payments", ProtocolHTTP)
+	host := Host{Name: "api1.payments"}
+	vars := NewHeaderVariables(&svc, &host)
+	assert.Equal(t, "api.payments", vars.Expand("${service}"))
+	assert.Equal(t, "host=api1.payments,ns=payments", vars.Expand("host=${host},ns=${namespace}"))
+	assert.Equal(t, "plain", vars.Expand("plain"))
+}
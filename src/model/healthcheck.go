@@ -0,0 +1,95 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rerorero/meshem/src/utils"
+)
+
+// ActiveHealthCheck periodically checks the hosts of a service. It's an HTTP health check if Path is specified,
+// otherwise a gRPC health check for gRPC services or a TCP connect check for the others.
+type ActiveHealthCheck struct {
+	Path               string `json:"path,omitempty" yaml:"path,omitempty"`
+	IntervalMS         uint32 `json:"intervalMS" yaml:"intervalMS"`
+	TimeoutMS          uint32 `json:"timeoutMS" yaml:"timeoutMS"`
+	HealthyThreshold   uint32 `json:"healthyThreshold" yaml:"healthyThreshold"`
+	UnhealthyThreshold uint32 `json:"unhealthyThreshold" yaml:"unhealthyThreshold"`
+}
+
+// IsHTTP returns true if it's an HTTP health check.
+func (hc *ActiveHealthCheck) IsHTTP() bool {
+	return len(hc.Path) > 0
+}
+
+// Validate checks the health check of the service which provides the protocol.
+func (hc *ActiveHealthCheck) Validate(protocol string) error {
+	if hc.IsHTTP() {
+		if !IsHTTPBasedProtocol(protocol) {
+			return fmt.Errorf("HTTP health check is not supported by %s protocol", protocol)
+		}
+		if !strings.HasPrefix(hc.Path, "/") {
+			return fmt.Errorf("health check path must start with '/': %s", hc.Path)
+		}
+	}
+	if hc.IntervalMS == 0 {
+		return errors.New("health check interval must be greater than 0")
+	}
+	if hc.TimeoutMS == 0 {
+		return errors.New("health check timeout must be greater than 0")
+	}
+	if hc.HealthyThreshold == 0 || hc.UnhealthyThreshold == 0 {
+		return errors.New("health check thresholds must be greater than 0")
+	}
+	return nil
+}
+
+// HealthCheckFilter configures how the ingress proxy of the service responds to health check requests.
+type HealthCheckFilter struct {
+	Mode        string `json:"mode" yaml:"mode"`
+	Endpoint    string `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	CacheTimeMS uint32 `json:"cacheTimeMS,omitempty" yaml:"cacheTimeMS,omitempty"`
+}
+
+const (
+	// HealthCheckFilterDisabled doesn't handle health check requests.
+	HealthCheckFilterDisabled = "disabled"
+	// HealthCheckFilterPassThrough passes health check requests to the service and caches the results.
+	HealthCheckFilterPassThrough = "passThrough"
+	// HealthCheckFilterEndpoint makes the proxy respond to health check requests by itself.
+	HealthCheckFilterEndpoint = "endpoint"
+)
+
+var (
+	allHealthCheckFilterModes = []string{
+		HealthCheckFilterDisabled,
+		HealthCheckFilterPassThrough,
+		HealthCheckFilterEndpoint,
+	}
+)
+
+// IsEnabled returns true if the filter handles health check requests.
+func (f *HealthCheckFilter) IsEnabled() bool {
+	return f != nil && f.Mode != HealthCheckFilterDisabled
+}
+
+// Validate checks the health check filter of the service which provides the protocol.
+func (f *HealthCheckFilter) Validate(protocol string) error {
+	if _, ok := utils.ContainsString(allHealthCheckFilterModes, f.Mode); !ok {
+		return fmt.Errorf("%s is invalid health check filter mode", f.Mode)
+	}
+	if !f.IsEnabled() {
+		return nil
+	}
+	if !IsHTTPBasedProtocol(protocol) {
+		return fmt.Errorf("health check filter is not supported by %s protocol", protocol)
+	}
+	if len(f.Endpoint) > 0 && !strings.HasPrefix(f.Endpoint, "/") {
+		return fmt.Errorf("health check endpoint must start with '/': %s", f.Endpoint)
+	}
+	if f.CacheTimeMS > 0 && f.Mode != HealthCheckFilterPassThrough {
+		return fmt.Errorf("cache time is only available in %s mode", HealthCheckFilterPassThrough)
+	}
+	return nil
+}
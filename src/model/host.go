@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 )
 
 // Host contains information of an user managed host.
@@ -13,17 +14,39 @@ type Host struct {
 	IngressAddr   Address `json:"ingressAddr" yaml:"ingressAddr"`
 	SubstanceAddr Address `json:"substanceAddr" yaml:"substanceAddr"`
 	EgressHost    string  `json:"egressHost" yaml:"egressHost"`
-	// TODO: add an admin port (IngressAddr + admin port(8001))
-	// AdminAddr     Address `json:"adminAddr" yaml:"adminAddr"`
+	// Subset is a label such as a version to split the traffic between the hosts of the service.
+	Subset string `json:"subset,omitempty" yaml:"subset,omitempty"`
+	// AdminAddr is the envoy's admin endpoint. IngressAddr's hostname and DefaultAdminPort are used if it's nil.
+	AdminAddr *Address `json:"adminAddr,omitempty" yaml:"adminAddr,omitempty"`
+	// Region and Zone are the locality of the host, the callers prefer the hosts in the same locality.
+	Region string `json:"region,omitempty" yaml:"region,omitempty"`
+	Zone   string `json:"zone,omitempty" yaml:"zone,omitempty"`
+	// Weight is the load balancing weight of the host relative to the others, all hosts are equal if it's zero.
+	Weight uint32 `json:"weight,omitempty" yaml:"weight,omitempty"`
+	// State is the operational state of the host, the host is active if it's empty.
+	State string `json:"state,omitempty" yaml:"state,omitempty"`
+	// DrainingSince is the time when the host started draining.
+	DrainingSince *time.Time `json:"drainingSince,omitempty" yaml:"drainingSince,omitempty"`
+	// Labels are the metadata of the host, they override the labels of the service.
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
 }
 
 const (
 	// DefaultAdminPort is default value of envoy admin port
 	DefaultAdminPort = 8001
+	// MaxHostWeight is the maximum load balancing weight that envoy accepts.
+	MaxHostWeight = 128
+	// HostStateActive is the state of the host that receives traffic.
+	HostStateActive = "active"
+	// HostStateDraining is the state of the host that finishes the requests in flight before it's removed.
+	HostStateDraining = "draining"
+	// HostStateMaintenance is the state of the host that stays registered but receives no traffic.
+	HostStateMaintenance = "maintenance"
 )
 
 var (
 	rHostName = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,64}$`)
+	rLocality = regexp.MustCompile(`^[A-Za-z0-9_\-\.]{1,64}$`)
 )
 
 // NewHost creates a new Host instance.
@@ -48,22 +71,120 @@ func NewHost(name string, ingresAddress string, substanceAddress string, egressH
 
 // Validate checks that the host is valid.
 func (h *Host) Validate() error {
-	err := validateHostname(h.Name)
+	err := validateQualifiedName(h.Name, validateHostname)
 	if err != nil {
 		return err
 	}
 
-	if h.IngressAddr == h.SubstanceAddr {
-		return fmt.Errorf("duplicate ingress port and egress port (host=%s, addr=%s)", h.Name, h.IngressAddr.String())
+	if h.IngressAddr.IsPipe() {
+		return fmt.Errorf("ingress address can not be an unix socket (host=%s, addr=%s)", h.Name, h.IngressAddr.String())
 	}
+	if h.SubstanceAddr.IsPipe() {
+		err = h.SubstanceAddr.validatePipe()
+		if err != nil {
+			return fmt.Errorf("invalid substance address (host=%s): %s", h.Name, err.Error())
+		}
+	}
+
+	if h.IngressAddr.Conflicts(&h.SubstanceAddr) {
+		return fmt.Errorf("ingress address conflicts with substance address (host=%s, ingress=%s, substance=%s)", h.Name, h.IngressAddr.String(), h.SubstanceAddr.String())
+	}
+
+	if h.AdminAddr != nil {
+		if h.AdminAddr.IsPipe() || h.AdminAddr.Port == 0 {
+			return fmt.Errorf("invalid admin port number (host=%s, addr=%s)", h.Name, h.AdminAddr.String())
+		}
+		if h.AdminAddr.Conflicts(&h.IngressAddr) {
+			return fmt.Errorf("admin address conflicts with ingress address (host=%s, admin=%s, ingress=%s)", h.Name, h.AdminAddr.String(), h.IngressAddr.String())
+		}
+		if h.AdminAddr.Conflicts(&h.SubstanceAddr) {
+			return fmt.Errorf("admin address conflicts with substance address (host=%s, admin=%s, substance=%s)", h.Name, h.AdminAddr.String(), h.SubstanceAddr.String())
+		}
+	}
+
+	if strings.Contains(h.EgressHost, ":") && !isIPv6(h.EgressHost) {
+		return fmt.Errorf("egrsshost can not contain port number and IPv6 address must not be bracketed: host=%s, egress=%s", h.Name, h.EgressHost)
+	}
+
+	if len(h.Subset) > 0 {
+		err = validateSubsetName(h.Subset)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(h.Zone) > 0 && len(h.Region) == 0 {
+		return fmt.Errorf("zone can not be specified without region (host=%s, zone=%s)", h.Name, h.Zone)
+	}
+	for _, locality := range []string{h.Region, h.Zone} {
+		if len(locality) > 0 && !rLocality.MatchString(locality) {
+			return fmt.Errorf("region and zone must consist of alphanumeric characters, underscores, dashes and dots, and less than 64 characters (host=%s, locality=%s)", h.Name, locality)
+		}
+	}
+
+	if h.Weight > MaxHostWeight {
+		return fmt.Errorf("weight must be less than or equal to %d (host=%s, weight=%d)", MaxHostWeight, h.Name, h.Weight)
+	}
+
+	if ValidateHostState(h.State) != nil {
+		return fmt.Errorf("invalid host state (host=%s, state=%s)", h.Name, h.State)
+	}
+
+	err = ValidateLabels(h.Labels)
+	if err != nil {
+		return fmt.Errorf("invalid labels (host=%s): %s", h.Name, err.Error())
+	}
+
+	return nil
+}
 
-	if strings.Contains(h.EgressHost, ":") {
-		return fmt.Errorf("egrsshost can not contain port number: host=%s, egress=%s", h.Name, h.EgressHost)
+// ValidateHostState checks that the state is known, empty means active.
+func ValidateHostState(state string) error {
+	switch state {
+	case "", HostStateActive, HostStateDraining, HostStateMaintenance:
+		return nil
 	}
+	return fmt.Errorf("invalid host state: %s", state)
+}
 
+// SetState changes the state of the host, the draining time is kept if the host is already draining.
+func (h *Host) SetState(state string, now time.Time) error {
+	err := ValidateHostState(state)
+	if err != nil {
+		return err
+	}
+	if state == HostStateDraining {
+		if h.DrainingSince == nil {
+			h.DrainingSince = &now
+		}
+	} else {
+		h.DrainingSince = nil
+	}
+	if state == HostStateActive {
+		state = ""
+	}
+	h.State = state
 	return nil
 }
 
+// IsDraining returns true if the host is draining.
+func (h *Host) IsDraining() bool {
+	return h.State == HostStateDraining
+}
+
+// IsInMaintenance returns true if the host should not receive any traffic.
+func (h *Host) IsInMaintenance() bool {
+	return h.State == HostStateMaintenance
+}
+
+// IsDrained returns true if the host has been draining longer than the grace period.
+func (h *Host) IsDrained(now time.Time, gracePeriod time.Duration) bool {
+	if !h.IsDraining() || h.DrainingSince == nil {
+		return false
+	}
+	return !now.Before(h.DrainingSince.Add(gracePeriod))
+}
+
 // Update updates the host attributes.
 func (h *Host) Update(ingresAddress *string, substanceAddress *string, egressHost *string) (err error) {
 	ingress := h.IngressAddr.String()
@@ -79,13 +200,42 @@ func (h *Host) Update(ingresAddress *string, substanceAddress *string, egressHos
 		egress = *egressHost
 	}
 
-	*h, err = NewHost(h.Name, ingress, substance, egress)
-	return err
+	updated, err := NewHost(h.Name, ingress, substance, egress)
+	if err != nil {
+		return err
+	}
+	updated.Subset = h.Subset
+	updated.AdminAddr = h.AdminAddr
+	updated.Region = h.Region
+	updated.Zone = h.Zone
+	updated.Weight = h.Weight
+	updated.State = h.State
+	updated.DrainingSince = h.DrainingSince
+	updated.Labels = h.Labels
+	*h = updated
+	return nil
+}
+
+// LocalityPriority returns the priority of the target host from the host's point of view, 0 is the highest.
+// The hosts in the same zone come first, then the ones in the same region, and then the others.
+func (h *Host) LocalityPriority(target *Host) uint32 {
+	if len(h.Region) == 0 {
+		return 0
+	}
+	if h.Region != target.Region {
+		return 2
+	}
+	if h.Zone != target.Zone {
+		return 1
+	}
+	return 0
 }
 
 // GetAdminAddr returns envoy's admin endpoint.
 func (h *Host) GetAdminAddr() *Address {
-	// TODO: get from property
+	if h.AdminAddr != nil {
+		return h.AdminAddr
+	}
 	return &Address{
 		Hostname: h.IngressAddr.Hostname,
 		Port:     DefaultAdminPort,
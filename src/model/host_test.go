@@ -1,7 +1,9 @@
 package model
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -12,17 +14,91 @@ func TestHostValidate(t *testing.T) {
 	assert.NoError(t, host.Validate())
 
 	// invalid hostname
-	host.Name = "ivalid.aaa"
+	host.Name = "ivalid.aa.a"
 	assert.Error(t, host.Validate())
 	host.Name = "ivalidddddddddddddddddddddddddddddddddddddddddddddddddddddddddddd"
 	assert.Error(t, host.Validate())
 
+	// invalid subset
+	host.Name = "valid"
+	host.Subset = "v1"
+	assert.NoError(t, host.Validate())
+	host.Subset = "v.1"
+	assert.Error(t, host.Validate())
+
+	// admin address
+	host.Subset = ""
+	assert.Equal(t, &Address{Hostname: "192.168.0.1", Port: DefaultAdminPort}, host.GetAdminAddr())
+	host.AdminAddr = &Address{Hostname: "127.0.0.1", Port: 18001}
+	assert.NoError(t, host.Validate())
+	assert.Equal(t, &Address{Hostname: "127.0.0.1", Port: 18001}, host.GetAdminAddr())
+	host.AdminAddr = &Address{Hostname: "127.0.0.1", Port: 0}
+	assert.Error(t, host.Validate())
+	host.AdminAddr = &Address{Hostname: "192.168.0.1", Port: 1234}
+	assert.Error(t, host.Validate())
+	host.AdminAddr = &Address{Hostname: "0.0.0.0", Port: 5678}
+	assert.Error(t, host.Validate())
+	host.AdminAddr = nil
+
 	// duplicate port
 	host2, err := NewHost("valid-01_32", "192.168.0.1:1234", "192.168.0.1:1234", "127.0.0.1")
 	assert.NoError(t, err)
 	assert.Error(t, host2.Validate())
 }
 
+func TestHostValidateIPv6(t *testing.T) {
+	host, err := NewHost("valid", "[2001:db8::1]:1234", "[::1]:5678", "::1")
+	assert.NoError(t, err)
+	assert.NoError(t, host.Validate())
+	assert.Equal(t, &Address{Hostname: "2001:db8::1", Port: DefaultAdminPort}, host.GetAdminAddr())
+
+	host.EgressHost = "[::1]"
+	assert.Error(t, host.Validate())
+	host.EgressHost = "127.0.0.1:80"
+	assert.Error(t, host.Validate())
+	host.EgressHost = "::1:80:"
+	assert.Error(t, host.Validate())
+
+	// the same address written differently
+	host, err = NewHost("valid", "[::1]:1234", "[0:0:0:0:0:0:0:1]:1234", "::1")
+	assert.NoError(t, err)
+	assert.Error(t, host.Validate())
+	host, err = NewHost("valid", "[::]:1234", "[::1]:1234", "::1")
+	assert.NoError(t, err)
+	assert.Error(t, host.Validate())
+}
+
+func TestHostValidateUnixSocket(t *testing.T) {
+	host, err := NewHost("valid", "192.168.0.1:1234", "unix:///run/app.sock", "127.0.0.1")
+	assert.NoError(t, err)
+	assert.NoError(t, host.Validate())
+	assert.Equal(t, Address{Path: "/run/app.sock"}, host.SubstanceAddr)
+
+	host.SubstanceAddr.Path = "run/app.sock"
+	assert.Error(t, host.Validate())
+	host.SubstanceAddr.Path = "/" + strings.Repeat("a", 107)
+	assert.Error(t, host.Validate())
+
+	// only the substance can be an unix socket
+	host, err = NewHost("valid", "unix:///run/ingress.sock", "unix:///run/app.sock", "127.0.0.1")
+	assert.NoError(t, err)
+	assert.Error(t, host.Validate())
+	host, err = NewHost("valid", "192.168.0.1:1234", "unix:///run/app.sock", "127.0.0.1")
+	assert.NoError(t, err)
+	host.AdminAddr = &Address{Path: "/run/admin.sock"}
+	assert.Error(t, host.Validate())
+
+	// switch between tcp and unix socket
+	host.AdminAddr = nil
+	newSub := "127.0.0.1:8080"
+	assert.NoError(t, host.Update(nil, &newSub, nil))
+	assert.Equal(t, Address{Hostname: "127.0.0.1", Port: 8080}, host.SubstanceAddr)
+	newSub = "unix:///run/app2.sock"
+	assert.NoError(t, host.Update(nil, &newSub, nil))
+	assert.Equal(t, Address{Path: "/run/app2.sock"}, host.SubstanceAddr)
+	assert.NoError(t, host.Validate())
+}
+
 func TestUpdate(t *testing.T) {
 	host, err := NewHost("name", "192.168.0.1:1234", "192.168.0.1:5678", "127.0.0.1")
 	assert.NoError(t, err)
@@ -42,4 +118,97 @@ func TestUpdate(t *testing.T) {
 	expect, err = NewHost("name", newIng, newSub, newEgress)
 	assert.NoError(t, err)
 	assert.Equal(t, expect, host)
+
+	// subset is kept
+	host.Subset = "v1"
+	err = host.Update(&newIng, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", host.Subset)
+
+	// admin address is kept
+	host.AdminAddr = &Address{Hostname: "192.168.0.1", Port: 18001}
+	err = host.Update(nil, &newSub, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &Address{Hostname: "192.168.0.1", Port: 18001}, host.AdminAddr)
+
+	// locality is kept
+	host.Region = "us-east-1"
+	host.Zone = "us-east-1a"
+	err = host.Update(&newIng, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "us-east-1", host.Region)
+	assert.Equal(t, "us-east-1a", host.Zone)
+
+	// weight is kept
+	host.Weight = 10
+	err = host.Update(nil, &newSub, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(10), host.Weight)
+}
+
+func TestHostValidateWeight(t *testing.T) {
+	host, err := NewHost("host1", "192.168.0.1:1234", "127.0.0.1:5678", "127.0.0.1")
+	assert.NoError(t, err)
+	host.Weight = MaxHostWeight
+	assert.NoError(t, host.Validate())
+	host.Weight = MaxHostWeight + 1
+	assert.Error(t, host.Validate())
+}
+
+func TestHostValidateLocality(t *testing.T) {
+	host, err := NewHost("host1", "192.168.0.1:1234", "127.0.0.1:5678", "127.0.0.1")
+	assert.NoError(t, err)
+	host.Region = "us-east-1"
+	assert.NoError(t, host.Validate())
+	host.Zone = "us-east-1a"
+	assert.NoError(t, host.Validate())
+	host.Zone = "invalid/zone"
+	assert.Error(t, host.Validate())
+	host.Region = ""
+	host.Zone = "us-east-1a"
+	assert.Error(t, host.Validate())
+}
+
+func TestLocalityPriority(t *testing.T) {
+	caller := &Host{Name: "caller", Region: "r1", Zone: "z1"}
+	assert.Equal(t, uint32(0), caller.LocalityPriority(&Host{Region: "r1", Zone: "z1"}))
+	assert.Equal(t, uint32(1), caller.LocalityPriority(&Host{Region: "r1", Zone: "z2"}))
+	assert.Equal(t, uint32(2), caller.LocalityPriority(&Host{Region: "r2", Zone: "z1"}))
+	assert.Equal(t, uint32(2), caller.LocalityPriority(&Host{}))
+
+	// the caller which doesn't know its locality
+	caller = &Host{Name: "caller"}
+	assert.Equal(t, uint32(0), caller.LocalityPriority(&Host{Region: "r1", Zone: "z1"}))
+	assert.Equal(t, uint32(0), caller.LocalityPriority(&Host{}))
+}
+
+func TestHostState(t *testing.T) {
+	host, err := NewHost("host1", "192.168.0.1:1234", "127.0.0.1:5678", "127.0.0.1")
+	assert.NoError(t, err)
+	assert.False(t, host.IsDraining())
+	assert.False(t, host.IsInMaintenance())
+
+	now := time.Now()
+	assert.NoError(t, host.SetState(HostStateDraining, now))
+	assert.True(t, host.IsDraining())
+	assert.Equal(t, now, *host.DrainingSince)
+	assert.NoError(t, host.Validate())
+	assert.False(t, host.IsDrained(now, time.Minute))
+	assert.True(t, host.IsDrained(now.Add(time.Minute), time.Minute))
+
+	// the draining time is kept
+	assert.NoError(t, host.SetState(HostStateDraining, now.Add(time.Second)))
+	assert.Equal(t, now, *host.DrainingSince)
+
+	assert.NoError(t, host.SetState(HostStateMaintenance, now))
+	assert.True(t, host.IsInMaintenance())
+	assert.Nil(t, host.DrainingSince)
+	assert.False(t, host.IsDrained(now.Add(time.Hour), time.Minute))
+
+	assert.NoError(t, host.SetState(HostStateActive, now))
+	assert.Equal(t, "", host.State)
+
+	assert.Error(t, host.SetState("unknown", now))
+	host.State = "unknown"
+	assert.Error(t, host.Validate())
 }
@@ -0,0 +1,136 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// LabelRequirement is a condition on a label. The label only has to exist (or not exist) if Value is empty.
+type LabelRequirement struct {
+	Key      string
+	Value    string
+	Operator string
+}
+
+// LabelSelector selects the objects whose labels satisfy all of the requirements.
+type LabelSelector []LabelRequirement
+
+const (
+	// LabelEquals requires the label to have the value.
+	LabelEquals = "="
+	// LabelNotEquals requires the label not to have the value, the objects without the label are also selected.
+	LabelNotEquals = "!="
+	// LabelExists requires the label to exist.
+	LabelExists = "exists"
+	// LabelNotExists requires the label not to exist.
+	LabelNotExists = "!exists"
+	// MaxLabels is the maximum number of labels of a service or a host, it's kept small so that
+	// the labels of a host merged with its service fit in the node meta of Consul.
+	MaxLabels = 16
+	// reservedLabelPrefix is the prefix of the keys which meshem uses itself such as 'meshem_service'.
+	reservedLabelPrefix = "meshem"
+)
+
+var (
+	rLabelKey   = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,64}$`)
+	rLabelValue = regexp.MustCompile(`^[A-Za-z0-9_\-\.]{0,64}$`)
+)
+
+// ValidateLabels checks the format of the labels, they are restricted to the characters which Consul accepts as node meta.
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > MaxLabels {
+		return fmt.Errorf("number of labels must be less than or equal to %d", MaxLabels)
+	}
+	for k, v := range labels {
+		if !rLabelKey.MatchString(k) {
+			return fmt.Errorf("label key must consist of alphanumeric characters, underscores and dashes, and less than 64 characters: %s", k)
+		}
+		if strings.HasPrefix(k, reservedLabelPrefix) {
+			return fmt.Errorf("label key can not start with '%s': %s", reservedLabelPrefix, k)
+		}
+		if !rLabelValue.MatchString(v) {
+			return fmt.Errorf("label value must consist of alphanumeric characters, underscores, dashes and dots, and less than 64 characters (key=%s, value=%s)", k, v)
+		}
+	}
+	return nil
+}
+
+// MergeLabels returns the labels of base overridden by the labels of overrides.
+func MergeLabels(base map[string]string, overrides map[string]string) map[string]string {
+	merged := map[string]string{}
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
+}
+
+// HostLabels returns the labels of the host which inherits the labels of the service.
+func (s *Service) HostLabels(h *Host) map[string]string {
+	return MergeLabels(s.Labels, h.Labels)
+}
+
+// ParseLabelSelector parses the comma separated requirements such as 'team=payments,tier!=batch'.
+// A key itself requires the label to exist and a key prefixed with '!' requires the label not to exist.
+// The empty string selects everything.
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	selector := LabelSelector{}
+	if len(strings.TrimSpace(s)) == 0 {
+		return selector, nil
+	}
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		var req LabelRequirement
+		switch {
+		case strings.Contains(term, LabelNotEquals):
+			pair := strings.SplitN(term, LabelNotEquals, 2)
+			req = LabelRequirement{Key: pair[0], Value: pair[1], Operator: LabelNotEquals}
+		case strings.Contains(term, "=="):
+			pair := strings.SplitN(term, "==", 2)
+			req = LabelRequirement{Key: pair[0], Value: pair[1], Operator: LabelEquals}
+		case strings.Contains(term, LabelEquals):
+			pair := strings.SplitN(term, LabelEquals, 2)
+			req = LabelRequirement{Key: pair[0], Value: pair[1], Operator: LabelEquals}
+		case strings.HasPrefix(term, "!"):
+			req = LabelRequirement{Key: strings.TrimPrefix(term, "!"), Operator: LabelNotExists}
+		default:
+			req = LabelRequirement{Key: term, Operator: LabelExists}
+		}
+		req.Key = strings.TrimSpace(req.Key)
+		req.Value = strings.TrimSpace(req.Value)
+		if !rLabelKey.MatchString(req.Key) || !rLabelValue.MatchString(req.Value) {
+			return nil, fmt.Errorf("invalid label selector: %s", term)
+		}
+		selector = append(selector, req)
+	}
+	return selector, nil
+}
+
+// Matches returns true if the labels satisfy all of the requirements.
+func (selector LabelSelector) Matches(labels map[string]string) bool {
+	for _, req := range selector {
+		v, ok := labels[req.Key]
+		switch req.Operator {
+		case LabelEquals:
+			if !ok || v != req.Value {
+				return false
+			}
+		case LabelNotEquals:
+			if ok && v == req.Value {
+				return false
+			}
+		case LabelExists:
+			if !ok {
+				return false
+			}
+		case LabelNotExists:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateLabels(t *testing.T) {
+	assert.NoError(t, ValidateLabels(nil))
+	assert.NoError(t, ValidateLabels(map[string]string{"team": "payments", "tier": "", "version": "v1.2"}))
+	assert.Error(t, ValidateLabels(map[string]string{"": "payments"}))
+	assert.Error(t, ValidateLabels(map[string]string{"team.name": "payments"}))
+	assert.Error(t, ValidateLabels(map[string]string{"team": "pay ments"}))
+	assert.Error(t, ValidateLabels(map[string]string{"meshem_service": "app"}))
+
+	many := map[string]string{}
+	var i int
+	for i = 0; i <= MaxLabels; i++ {
+		many[string('a'+rune(i))] = "x"
+	}
+	assert.Error(t, ValidateLabels(many))
+}
+
+func TestLabelSelector(t *testing.T) {
+	selector, err := ParseLabelSelector("team=payments, tier!=batch,region,!canary,zone==a")
+	assert.NoError(t, err)
+	assert.Equal(t, LabelSelector{
+		{Key: "team", Value: "payments", Operator: LabelEquals},
+		{Key: "tier", Value: "batch", Operator: LabelNotEquals},
+		{Key: "region", Operator: LabelExists},
+		{Key: "canary", Operator: LabelNotExists},
+		{Key: "zone", Value: "a", Operator: LabelEquals},
+	}, selector)
+
+	assert.True(t, selector.Matches(map[string]string{"team": "payments", "region": "jp", "zone": "a"}))
+	assert.True(t, selector.Matches(map[string]string{"team": "payments", "tier": "web", "region": "jp", "zone": "a"}))
+	assert.False(t, selector.Matches(map[string]string{"team": "payments", "tier": "batch", "region": "jp", "zone": "a"}))
+	assert.False(t, selector.Matches(map[string]string{"team": "search", "region": "jp", "zone": "a"}))
+	assert.False(t, selector.Matches(map[string]string{"team": "payments", "zone": "a"}))
+	assert.False(t, selector.Matches(map[string]string{"team": "payments", "region": "jp", "zone": "a", "canary": ""}))
+
+	empty, err := ParseLabelSelector("")
+	assert.NoError(t, err)
+	assert.Empty(t, empty)
+	assert.True(t, empty.Matches(nil))
+
+	_, err = ParseLabelSelector("team=pay ments")
+	assert.Error(t, err)
+	_, err = ParseLabelSelector("=payments")
+	assert.Error(t, err)
+	_, err = ParseLabelSelector("team=payments,")
+	assert.Error(t, err)
+}
+
+func TestHostLabels(t *testing.T) {
+	svc := Service{Name: "app", Labels: map[string]string{"team": "payments", "tier": "web"}}
+	host := Host{Name: "app1", Labels: map[string]string{"tier": "batch", "zone": "a"}}
+	assert.Equal(t, map[string]string{"team": "payments", "tier": "batch", "zone": "a"}, svc.HostLabels(&host))
+	assert.Equal(t, map[string]string{"team": "payments", "tier": "web"}, svc.HostLabels(&Host{Name: "app2"}))
+}
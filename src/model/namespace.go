@@ -0,0 +1,141 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The services and the hosts belong to a namespace, and their names are unique only within it.
+// They are identified by the qualified names such as 'api.payments' in the inventory,
+// the names in the default namespace are not qualified so that they stay the same as before namespaces.
+
+const (
+	// DefaultNamespace is the namespace of the services and hosts whose names are not qualified.
+	DefaultNamespace = "default"
+	// NamespaceSeparator separates the name and the namespace in the qualified name.
+	NamespaceSeparator = "."
+)
+
+var (
+	rNamespace = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,64}$`)
+)
+
+// ValidateNamespace checks the format of the namespace.
+func ValidateNamespace(namespace string) error {
+	if !rNamespace.MatchString(namespace) {
+		return errors.New("namespace must consist of alphanumeric characters, underscores and dashes, and less than 64 characters")
+	}
+	return nil
+}
+
+// QualifiedName returns the name of the service or the host in the namespace.
+func QualifiedName(namespace string, name string) string {
+	if len(namespace) == 0 || namespace == DefaultNamespace {
+		return name
+	}
+	return name + NamespaceSeparator + namespace
+}
+
+// SplitQualifiedName splits the qualified name into the name and the namespace.
+func SplitQualifiedName(qualified string) (name string, namespace string) {
+	pair := strings.SplitN(qualified, NamespaceSeparator, 2)
+	if len(pair) == 1 {
+		return pair[0], DefaultNamespace
+	}
+	return pair[0], pair[1]
+}
+
+// NamespaceOf returns the namespace of the qualified name.
+func NamespaceOf(qualified string) string {
+	_, namespace := SplitQualifiedName(qualified)
+	return namespace
+}
+
+// ResolveName returns the qualified name of the service or the host referred from the namespace.
+// The name refers to the one in the same namespace unless it is qualified explicitly such as 'db.billing' or 'db.default'.
+func ResolveName(namespace string, name string) string {
+	if strings.Contains(name, NamespaceSeparator) {
+		name, namespace = SplitQualifiedName(name)
+	}
+	return QualifiedName(namespace, name)
+}
+
+// RelativeName returns the name as it is referred from the namespace, which is the inverse of ResolveName.
+func RelativeName(namespace string, qualified string) string {
+	if len(namespace) == 0 {
+		namespace = DefaultNamespace
+	}
+	name, ns := SplitQualifiedName(qualified)
+	if ns == namespace {
+		return name
+	}
+	return name + NamespaceSeparator + ns
+}
+
+// ResolveServiceRef returns the reference to the service such as 'db.billing:grpc' referred from the namespace.
+func ResolveServiceRef(namespace string, ref string) string {
+	name, port := ParseServiceRef(ref)
+	dep := DependentService{Name: ResolveName(namespace, name), Port: port}
+	return dep.Ref()
+}
+
+// validateQualifiedName checks the format of the name and the namespace of the qualified name.
+func validateQualifiedName(qualified string, validateName func(string) error) error {
+	name, namespace := SplitQualifiedName(qualified)
+	err := validateName(name)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(qualified, NamespaceSeparator) {
+		return nil
+	}
+	if namespace == DefaultNamespace {
+		return fmt.Errorf("the name in the default namespace must not be qualified: %s", qualified)
+	}
+	return errors.Wrapf(ValidateNamespace(namespace), "invalid name: %s", qualified)
+}
+
+// ResolveNames returns the parameter whose names of the hosts, the dependencies and the route targets are
+// resolved from the namespace.
+func (param *IdempotentServiceParam) ResolveNames(namespace string) IdempotentServiceParam {
+	return param.mapNames(func(name string) string { return ResolveName(namespace, name) })
+}
+
+// RelativeNames returns the parameter whose names of the hosts, the dependencies and the route targets are
+// relative to the namespace.
+func (param *IdempotentServiceParam) RelativeNames(namespace string) IdempotentServiceParam {
+	return param.mapNames(func(name string) string { return RelativeName(namespace, name) })
+}
+
+// mapNames copies the parameter with the names converted, the slices are copied not to modify the original.
+func (param *IdempotentServiceParam) mapNames(f func(string) string) IdempotentServiceParam {
+	mapped := *param
+	var i int
+	if len(param.Hosts) > 0 {
+		mapped.Hosts = make([]Host, len(param.Hosts))
+	}
+	for i = 0; i < len(param.Hosts); i++ {
+		mapped.Hosts[i] = param.Hosts[i]
+		mapped.Hosts[i].Name = f(param.Hosts[i].Name)
+	}
+	if len(param.DependentServices) > 0 {
+		mapped.DependentServices = make([]DependentService, len(param.DependentServices))
+	}
+	for i = 0; i < len(param.DependentServices); i++ {
+		mapped.DependentServices[i] = param.DependentServices[i]
+		mapped.DependentServices[i].Name = f(param.DependentServices[i].Name)
+	}
+	if len(param.Routes) > 0 {
+		mapped.Routes = make([]RouteRule, len(param.Routes))
+	}
+	for i = 0; i < len(param.Routes); i++ {
+		mapped.Routes[i] = param.Routes[i]
+		if len(param.Routes[i].Service) > 0 {
+			mapped.Routes[i].Service = f(param.Routes[i].Service)
+		}
+	}
+	return mapped
+}
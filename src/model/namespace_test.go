@@ -0,0 +1,88 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQualifiedName(t *testing.T) {
+	assert.Equal(t, "api", QualifiedName("", "api"))
+	assert.Equal(t, "api", QualifiedName(DefaultNamespace, "api"))
+	assert.Equal(t, "api.payments", QualifiedName("payments", "api"))
+
+	name, ns := SplitQualifiedName("api.payments")
+	assert.Equal(t, "api", name)
+	assert.Equal(t, "payments", ns)
+	name, ns = SplitQualifiedName("api")
+	assert.Equal(t, "api", name)
+	assert.Equal(t, DefaultNamespace, ns)
+
+	// resolve from the namespace
+	assert.Equal(t, "db.payments", ResolveName("payments", "db"))
+	assert.Equal(t, "db.billing", ResolveName("payments", "db.billing"))
+	assert.Equal(t, "db", ResolveName("payments", "db.default"))
+	assert.Equal(t, "db", ResolveName(DefaultNamespace, "db"))
+	assert.Equal(t, "db.billing:grpc", ResolveServiceRef("payments", "db.billing:grpc"))
+	assert.Equal(t, "db.payments:grpc", ResolveServiceRef("payments", "db:grpc"))
+	assert.Equal(t, "db.payments", ResolveServiceRef("payments", "db"))
+
+	// relative to the namespace
+	assert.Equal(t, "db", RelativeName("payments", "db.payments"))
+	assert.Equal(t, "db.billing", RelativeName("payments", "db.billing"))
+	assert.Equal(t, "db.default", RelativeName("payments", "db"))
+	assert.Equal(t, "db", RelativeName("", "db"))
+	assert.Equal(t, "db.payments", RelativeName(DefaultNamespace, "db.payments"))
+}
+
+func TestValidateQualifiedName(t *testing.T) {
+	svc := NewService("api.payments", ProtocolHTTP)
+	svc.HostNames = []string{"api1.payments"}
+	svc.DependentServices = []DependentService{{Name: "db.billing", EgressPort: 9001}, {Name: "cache", EgressPort: 9002}}
+	assert.NoError(t, svc.Validate())
+	assert.Equal(t, "payments", svc.Namespace())
+
+	// the hosts are in the namespace of the service
+	svc.HostNames = []string{"api1"}
+	assert.Error(t, svc.Validate())
+	svc.HostNames = []string{"api1.billing"}
+	assert.Error(t, svc.Validate())
+	svc.HostNames = nil
+
+	invalids := []string{"api.default", "api.pay.ments", "api.", ".payments", "api.pay ments"}
+	for _, name := range invalids {
+		svc.Name = name
+		assert.Error(t, svc.Validate(), name)
+	}
+
+	host, err := NewHost("api1.payments", "192.168.0.1:80", "127.0.0.1:8080", "127.0.0.1")
+	assert.NoError(t, err)
+	assert.NoError(t, host.Validate())
+	host.Name = "api1.default"
+	assert.Error(t, host.Validate())
+}
+
+func TestResolveNames(t *testing.T) {
+	param := IdempotentServiceParam{
+		Protocol: ProtocolHTTP,
+		Hosts:    []Host{{Name: "api1"}},
+		DependentServices: []DependentService{
+			{Name: "db", EgressPort: 9001},
+			{Name: "db.billing", Port: "grpc", EgressPort: 9002},
+			{Name: "cache.default", EgressPort: 9003},
+		},
+		Routes: []RouteRule{{Prefix: "/v2", Service: "api-v2"}, {Prefix: "/"}},
+	}
+	resolved := param.ResolveNames("payments")
+	assert.Equal(t, "api1.payments", resolved.Hosts[0].Name)
+	assert.Equal(t, "db.payments", resolved.DependentServices[0].Name)
+	assert.Equal(t, "db.billing", resolved.DependentServices[1].Name)
+	assert.Equal(t, "cache", resolved.DependentServices[2].Name)
+	assert.Equal(t, "api-v2.payments", resolved.Routes[0].Service)
+	assert.Empty(t, resolved.Routes[1].Service)
+	// the original is not modified
+	assert.Equal(t, "api1", param.Hosts[0].Name)
+	assert.Equal(t, "db", param.DependentServices[0].Name)
+
+	assert.Equal(t, param, resolved.RelativeNames("payments"))
+}
@@ -0,0 +1,317 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rerorero/meshem/src/utils"
+)
+
+// RetryPolicy configures retries of the requests to a dependent service.
+type RetryPolicy struct {
+	RetryOn              []string `json:"retryOn" yaml:"retryOn"`
+	NumRetries           uint32   `json:"numRetries,omitempty" yaml:"numRetries,omitempty"`
+	PerTryTimeoutMS      uint32   `json:"perTryTimeoutMS,omitempty" yaml:"perTryTimeoutMS,omitempty"`
+	RetriableStatusCodes []uint32 `json:"retriableStatusCodes,omitempty" yaml:"retriableStatusCodes,omitempty"`
+}
+
+const (
+	// RetryOn5xx retries on 5xx responses and connection failures.
+	RetryOn5xx = "5xx"
+	// RetryOnGatewayError retries on 502, 503 and 504 responses.
+	RetryOnGatewayError = "gateway-error"
+	// RetryOnConnectFailure retries on connection failures.
+	RetryOnConnectFailure = "connect-failure"
+	// RetryOnRetriable4xx retries on 409 responses.
+	RetryOnRetriable4xx = "retriable-4xx"
+	// RetryOnRefusedStream retries when the upstream resets the stream with REFUSED_STREAM.
+	RetryOnRefusedStream = "refused-stream"
+	// RetryOnRetriableStatusCodes retries on the status codes listed in RetriableStatusCodes.
+	RetryOnRetriableStatusCodes = "retriable-status-codes"
+	// RetryOnCancelled retries on gRPC status CANCELLED.
+	RetryOnCancelled = "cancelled"
+	// RetryOnDeadlineExceeded retries on gRPC status DEADLINE_EXCEEDED.
+	RetryOnDeadlineExceeded = "deadline-exceeded"
+	// RetryOnInternal retries on gRPC status INTERNAL.
+	RetryOnInternal = "internal"
+	// RetryOnResourceExhausted retries on gRPC status RESOURCE_EXHAUSTED.
+	RetryOnResourceExhausted = "resource-exhausted"
+	// RetryOnUnavailable retries on gRPC status UNAVAILABLE.
+	RetryOnUnavailable = "unavailable"
+)
+
+var (
+	allRetryOn = []string{
+		RetryOn5xx,
+		RetryOnGatewayError,
+		RetryOnConnectFailure,
+		RetryOnRetriable4xx,
+		RetryOnRefusedStream,
+		RetryOnRetriableStatusCodes,
+	}
+	allGRPCRetryOn = []string{
+		RetryOnCancelled,
+		RetryOnDeadlineExceeded,
+		RetryOnInternal,
+		RetryOnResourceExhausted,
+		RetryOnUnavailable,
+	}
+)
+
+// Validate checks the retry policy.
+func (p *RetryPolicy) Validate() error {
+	if len(p.RetryOn) == 0 {
+		return errors.New("retryOn must be specified")
+	}
+	for _, on := range p.RetryOn {
+		_, ok := utils.ContainsString(allRetryOn, on)
+		_, grpcOk := utils.ContainsString(allGRPCRetryOn, on)
+		if !ok && !grpcOk {
+			return fmt.Errorf("%s is invalid retry condition", on)
+		}
+	}
+
+	_, statusCodesEnabled := utils.ContainsString(p.RetryOn, RetryOnRetriableStatusCodes)
+	if statusCodesEnabled && len(p.RetriableStatusCodes) == 0 {
+		return fmt.Errorf("retriableStatusCodes must be specified when retryOn contains %s", RetryOnRetriableStatusCodes)
+	}
+	if !statusCodesEnabled && len(p.RetriableStatusCodes) > 0 {
+		return fmt.Errorf("retryOn must contain %s when retriableStatusCodes is specified", RetryOnRetriableStatusCodes)
+	}
+	for _, code := range p.RetriableStatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid retriable status code: %d", code)
+		}
+	}
+	return nil
+}
+
+// HasGRPCConditions returns true if the policy contains the conditions on gRPC status.
+func (p *RetryPolicy) HasGRPCConditions() bool {
+	for _, on := range p.RetryOn {
+		if _, ok := utils.ContainsString(allGRPCRetryOn, on); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Timeouts overrides the timeouts of the proxies. Zero values mean not to override.
+type Timeouts struct {
+	ConnectTimeoutMS uint32 `json:"connectTimeoutMS,omitempty" yaml:"connectTimeoutMS,omitempty"`
+	RequestTimeoutMS uint32 `json:"requestTimeoutMS,omitempty" yaml:"requestTimeoutMS,omitempty"`
+	IdleTimeoutMS    uint32 `json:"idleTimeoutMS,omitempty" yaml:"idleTimeoutMS,omitempty"`
+}
+
+// Override returns new Timeouts whose values are overridden by the non-zero values of o. Either of them may be nil.
+func (t *Timeouts) Override(o *Timeouts) *Timeouts {
+	overridden := &Timeouts{}
+	if t != nil {
+		*overridden = *t
+	}
+	if o == nil {
+		return overridden
+	}
+	if o.ConnectTimeoutMS > 0 {
+		overridden.ConnectTimeoutMS = o.ConnectTimeoutMS
+	}
+	if o.RequestTimeoutMS > 0 {
+		overridden.RequestTimeoutMS = o.RequestTimeoutMS
+	}
+	if o.IdleTimeoutMS > 0 {
+		overridden.IdleTimeoutMS = o.IdleTimeoutMS
+	}
+	return overridden
+}
+
+// ResiliencePolicy protects the hosts of a service from being overloaded by its callers.
+type ResiliencePolicy struct {
+	CircuitBreaker   *CircuitBreaker   `json:"circuitBreaker,omitempty" yaml:"circuitBreaker,omitempty"`
+	OutlierDetection *OutlierDetection `json:"outlierDetection,omitempty" yaml:"outlierDetection,omitempty"`
+}
+
+// CircuitBreaker limits the connections and requests to a service. Zero values mean the defaults of envoy.
+type CircuitBreaker struct {
+	MaxConnections     uint32 `json:"maxConnections,omitempty" yaml:"maxConnections,omitempty"`
+	MaxPendingRequests uint32 `json:"maxPendingRequests,omitempty" yaml:"maxPendingRequests,omitempty"`
+	MaxRequests        uint32 `json:"maxRequests,omitempty" yaml:"maxRequests,omitempty"`
+	MaxRetries         uint32 `json:"maxRetries,omitempty" yaml:"maxRetries,omitempty"`
+}
+
+// OutlierDetection ejects the hosts which return consecutive 5xx responses. Zero values mean the defaults of envoy.
+type OutlierDetection struct {
+	Consecutive5xx     uint32 `json:"consecutive5xx,omitempty" yaml:"consecutive5xx,omitempty"`
+	IntervalMS         uint32 `json:"intervalMS,omitempty" yaml:"intervalMS,omitempty"`
+	BaseEjectionTimeMS uint32 `json:"baseEjectionTimeMS,omitempty" yaml:"baseEjectionTimeMS,omitempty"`
+	MaxEjectionPercent uint32 `json:"maxEjectionPercent,omitempty" yaml:"maxEjectionPercent,omitempty"`
+}
+
+// Validate checks the resilience policy.
+func (p *ResiliencePolicy) Validate() error {
+	if p.OutlierDetection != nil && p.OutlierDetection.MaxEjectionPercent > 100 {
+		return fmt.Errorf("maxEjectionPercent must be less than or equal to 100: %d", p.OutlierDetection.MaxEjectionPercent)
+	}
+	return nil
+}
+
+// FaultPolicy injects faults into the requests to a dependent service.
+type FaultPolicy struct {
+	Delay *FaultDelay `json:"delay,omitempty" yaml:"delay,omitempty"`
+	Abort *FaultAbort `json:"abort,omitempty" yaml:"abort,omitempty"`
+	// ExpiresAt is the time when the fault is removed, it never expires if nil.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" yaml:"expiresAt,omitempty"`
+}
+
+// FaultDelay delays the percentage of the requests for the fixed duration.
+type FaultDelay struct {
+	Percentage uint32 `json:"percentage" yaml:"percentage"`
+	DurationMS uint32 `json:"durationMS" yaml:"durationMS"`
+}
+
+// FaultAbort aborts the percentage of the requests with the HTTP status.
+type FaultAbort struct {
+	Percentage uint32 `json:"percentage" yaml:"percentage"`
+	HTTPStatus uint32 `json:"httpStatus" yaml:"httpStatus"`
+}
+
+// Validate checks the fault policy.
+func (p *FaultPolicy) Validate() error {
+	if p.Delay == nil && p.Abort == nil {
+		return errors.New("either delay or abort must be specified")
+	}
+	if p.Delay != nil {
+		if p.Delay.Percentage > 100 {
+			return fmt.Errorf("delay percentage must be less than or equal to 100: %d", p.Delay.Percentage)
+		}
+		if p.Delay.DurationMS == 0 {
+			return errors.New("delay durationMS must be greater than 0")
+		}
+	}
+	if p.Abort != nil {
+		if p.Abort.Percentage > 100 {
+			return fmt.Errorf("abort percentage must be less than or equal to 100: %d", p.Abort.Percentage)
+		}
+		if p.Abort.HTTPStatus < 200 || p.Abort.HTTPStatus > 599 {
+			return fmt.Errorf("invalid abort HTTP status: %d", p.Abort.HTTPStatus)
+		}
+	}
+	return nil
+}
+
+// IsActive returns true if the fault is not nil and not expired at the time.
+func (p *FaultPolicy) IsActive(now time.Time) bool {
+	return p != nil && (p.ExpiresAt == nil || now.Before(*p.ExpiresAt))
+}
+
+// RateLimit limits the requests or the connections which each host of a service accepts.
+// The requests are limited for HTTP based protocols. Each worker thread of envoy has its own token bucket for them,
+// so a host accepts up to RequestsPerSecond multiplied by the concurrency of envoy, and the bucket is refilled every second.
+// The connections are limited for TCP by the rate limit service of meshem, the limit is shared by all the worker threads of the host.
+type RateLimit struct {
+	RequestsPerSecond uint32 `json:"requestsPerSecond,omitempty" yaml:"requestsPerSecond,omitempty"`
+	// Burst is the number of the requests allowed to exceed the rate, RequestsPerSecond is used if zero.
+	Burst                uint32 `json:"burst,omitempty" yaml:"burst,omitempty"`
+	ConnectionsPerSecond uint32 `json:"connectionsPerSecond,omitempty" yaml:"connectionsPerSecond,omitempty"`
+}
+
+// Validate checks the rate limit is valid for the protocol.
+func (r *RateLimit) Validate(protocol string) error {
+	if IsHTTPBasedProtocol(protocol) {
+		if r.RequestsPerSecond == 0 {
+			return fmt.Errorf("requestsPerSecond must be greater than 0 for %s protocol", protocol)
+		}
+		if r.ConnectionsPerSecond > 0 {
+			return fmt.Errorf("connectionsPerSecond is not supported by %s protocol", protocol)
+		}
+		if r.Burst > 0 && r.Burst < r.RequestsPerSecond {
+			return fmt.Errorf("burst must be greater than or equal to requestsPerSecond: %d", r.Burst)
+		}
+		return nil
+	}
+	if r.ConnectionsPerSecond == 0 {
+		return fmt.Errorf("connectionsPerSecond must be greater than 0 for %s protocol", protocol)
+	}
+	if r.RequestsPerSecond > 0 || r.Burst > 0 {
+		return fmt.Errorf("requestsPerSecond and burst are not supported by %s protocol", protocol)
+	}
+	return nil
+}
+
+// MaxTokens returns the size of the token bucket for the requests.
+func (r *RateLimit) MaxTokens() uint32 {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+	return r.RequestsPerSecond
+}
+
+// LoadBalancer is the policy to select the host of a service which the callers send the requests to.
+type LoadBalancer struct {
+	Policy string `json:"policy" yaml:"policy"`
+	// HashKey is the source of the key of the consistent hashing, which ringHash and maglev require.
+	HashKey *HashKey `json:"hashKey,omitempty" yaml:"hashKey,omitempty"`
+}
+
+// HashKey specifies either of a header, a cookie or the source IP address as the hash key.
+type HashKey struct {
+	Header   string `json:"header,omitempty" yaml:"header,omitempty"`
+	Cookie   string `json:"cookie,omitempty" yaml:"cookie,omitempty"`
+	SourceIP bool   `json:"sourceIP,omitempty" yaml:"sourceIP,omitempty"`
+}
+
+const (
+	// LBRoundRobin selects the hosts in turn.
+	LBRoundRobin = "roundRobin"
+	// LBLeastRequest selects the host which has the fewest active requests.
+	LBLeastRequest = "leastRequest"
+	// LBRandom selects a host at random.
+	LBRandom = "random"
+	// LBRingHash selects a host by consistent hashing with a hash ring.
+	LBRingHash = "ringHash"
+	// LBMaglev selects a host by consistent hashing with Maglev.
+	LBMaglev = "maglev"
+)
+
+var (
+	allLBPolicy = []string{LBRoundRobin, LBLeastRequest, LBRandom, LBRingHash, LBMaglev}
+)
+
+// IsConsistentHashing returns true if the policy selects the hosts by the hash key.
+func (lb *LoadBalancer) IsConsistentHashing() bool {
+	return lb.Policy == LBRingHash || lb.Policy == LBMaglev
+}
+
+// Validate checks the load balancer is valid for the protocol.
+func (lb *LoadBalancer) Validate(protocol string) error {
+	if _, ok := utils.ContainsString(allLBPolicy, lb.Policy); !ok {
+		return fmt.Errorf("%s is invalid load balancing policy", lb.Policy)
+	}
+	if !lb.IsConsistentHashing() {
+		if lb.HashKey != nil {
+			return fmt.Errorf("hashKey is not supported by %s policy", lb.Policy)
+		}
+		return nil
+	}
+
+	if !IsHTTPBasedProtocol(protocol) {
+		return fmt.Errorf("%s policy is not supported by %s protocol", lb.Policy, protocol)
+	}
+	if lb.HashKey == nil {
+		return fmt.Errorf("hashKey must be specified for %s policy", lb.Policy)
+	}
+	var sources int
+	if len(lb.HashKey.Header) > 0 {
+		sources++
+	}
+	if len(lb.HashKey.Cookie) > 0 {
+		sources++
+	}
+	if lb.HashKey.SourceIP {
+		sources++
+	}
+	if sources != 1 {
+		return errors.New("hashKey must have exactly one of header, cookie or sourceIP")
+	}
+	return nil
+}
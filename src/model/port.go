@@ -0,0 +1,202 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rerorero/meshem/src/utils"
+)
+
+// ServicePort is a named port which the hosts of the service expose in addition to the default port.
+// The hosts listen on IngressPort at the hostname of their IngressAddr, and the substance serves on SubstancePort
+// at the hostname of their SubstanceAddr.
+type ServicePort struct {
+	Name          string `json:"name" yaml:"name"`
+	Protocol      string `json:"protocol" yaml:"protocol"`
+	IngressPort   uint32 `json:"ingressPort" yaml:"ingressPort"`
+	SubstancePort uint32 `json:"substancePort" yaml:"substancePort"`
+}
+
+const (
+	// ServiceRefSeparator separates the service name and the port name in the reference such as 'app:grpc'.
+	ServiceRefSeparator = ":"
+)
+
+var (
+	rPortName = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,64}$`)
+)
+
+// Validate checks the format of the port.
+func (p *ServicePort) Validate() error {
+	err := validatePortName(p.Name)
+	if err != nil {
+		return err
+	}
+	if _, ok := utils.ContainsString(allProtocol, p.Protocol); !ok {
+		return fmt.Errorf("%s is invalid protocol (port=%s)", p.Protocol, p.Name)
+	}
+	if p.IngressPort == 0 || p.SubstancePort == 0 {
+		return fmt.Errorf("invalid port number (port=%s, ingress=%d, substance=%d)", p.Name, p.IngressPort, p.SubstancePort)
+	}
+	return nil
+}
+
+func validatePortName(s string) error {
+	if !rPortName.MatchString(s) {
+		return errors.New("port name must consist of alphanumeric characters, underscores and dashes, and less than 64 characters")
+	}
+	return nil
+}
+
+// validatePorts checks that the names and the port numbers are not duplicated.
+func (s *Service) validatePorts() error {
+	names := map[string]bool{}
+	ingress := map[uint32]string{}
+	substance := map[uint32]string{}
+	var i int
+	for i = 0; i < len(s.Ports); i++ {
+		p := &s.Ports[i]
+		err := p.Validate()
+		if err != nil {
+			return err
+		}
+		if names[p.Name] {
+			return fmt.Errorf("duplicate port names: %s", p.Name)
+		}
+		names[p.Name] = true
+		if dup, ok := ingress[p.IngressPort]; ok {
+			return fmt.Errorf("duplicate ingress port=%d used for %s and %s", p.IngressPort, p.Name, dup)
+		}
+		ingress[p.IngressPort] = p.Name
+		if dup, ok := substance[p.SubstancePort]; ok {
+			return fmt.Errorf("duplicate substance port=%d used for %s and %s", p.SubstancePort, p.Name, dup)
+		}
+		substance[p.SubstancePort] = p.Name
+		if ok, dep := s.FindDependentServicePort(p.IngressPort); ok {
+			return fmt.Errorf("ingress port=%d of port=%s is already used by the dependency %s", p.IngressPort, p.Name, dep.Ref())
+		}
+		err = s.validatePortPolicies(p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatePortPolicies checks that the ingress policies which the port inherits are supported by its protocol.
+func (s *Service) validatePortPolicies(p *ServicePort) error {
+	if s.HealthCheck != nil {
+		err := s.HealthCheck.Validate(p.Protocol)
+		if err != nil {
+			return errors.Wrapf(err, "health check of service=%s can not be applied to port=%s", s.Name, p.Name)
+		}
+	}
+	if s.HealthCheckFilter != nil {
+		err := s.HealthCheckFilter.Validate(p.Protocol)
+		if err != nil {
+			return errors.Wrapf(err, "health check filter of service=%s can not be applied to port=%s", s.Name, p.Name)
+		}
+	}
+	if s.RateLimit != nil {
+		err := s.RateLimit.Validate(p.Protocol)
+		if err != nil {
+			return errors.Wrapf(err, "rate limit of service=%s can not be applied to port=%s", s.Name, p.Name)
+		}
+	}
+	return nil
+}
+
+// FindPort finds a named port by name.
+func (s *Service) FindPort(name string) (bool, *ServicePort) {
+	var i int
+	for i = 0; i < len(s.Ports); i++ {
+		if s.Ports[i].Name == name {
+			return true, &s.Ports[i]
+		}
+	}
+	return false, nil
+}
+
+// PortService returns the service as it is served on the named port.
+// The ingress policies are inherited, which validatePorts checks are supported by the protocol of the port.
+// The policies which route the requests of the default port, such as subsets and routes, are not inherited.
+func (s *Service) PortService(port *ServicePort) Service {
+	return Service{
+		Name:              s.Name,
+		HostNames:         s.HostNames,
+		Protocol:          port.Protocol,
+		TraceSpan:         s.TraceSpan,
+		Timeouts:          s.Timeouts,
+		Resilience:        s.Resilience,
+		HealthCheck:       s.HealthCheck,
+		HealthCheckFilter: s.HealthCheckFilter,
+		RateLimit:         s.RateLimit,
+		LoadBalancer:      s.LoadBalancer,
+		Labels:            s.Labels,
+		Headers:           s.Headers,
+		Version:           s.Version,
+	}
+}
+
+// ValidateHostPorts checks that the named ports don't conflict with the addresses of the host.
+func (s *Service) ValidateHostPorts(h *Host) error {
+	if len(s.Ports) == 0 {
+		return nil
+	}
+	if h.SubstanceAddr.IsPipe() {
+		return fmt.Errorf("host=%s with the unix socket substance can not serve the named ports of service=%s", h.Name, s.Name)
+	}
+	var i int
+	for i = 0; i < len(s.Ports); i++ {
+		p := &s.Ports[i]
+		ingress := Address{Hostname: h.IngressAddr.Hostname, Port: p.IngressPort}
+		if ingress.Conflicts(&h.IngressAddr) || ingress.Conflicts(h.GetAdminAddr()) {
+			return fmt.Errorf("ingress port=%d of port=%s conflicts with the addresses of host=%s", p.IngressPort, p.Name, h.Name)
+		}
+		if p.SubstancePort == h.SubstanceAddr.Port {
+			return fmt.Errorf("substance port=%d of port=%s conflicts with the substance of host=%s", p.SubstancePort, p.Name, h.Name)
+		}
+	}
+	return nil
+}
+
+// Ref returns the reference to the dependent service such as 'app' or 'app:grpc'.
+func (d *DependentService) Ref() string {
+	if len(d.Port) == 0 {
+		return d.Name
+	}
+	return d.Name + ServiceRefSeparator + d.Port
+}
+
+// ParseServiceRef splits the reference to the service into the service name and the port name.
+// The port name is empty if the reference is to the default port.
+func ParseServiceRef(ref string) (name string, port string) {
+	pair := strings.SplitN(ref, ServiceRefSeparator, 2)
+	if len(pair) == 1 {
+		return pair[0], ""
+	}
+	return pair[0], pair[1]
+}
+
+// EqualsServicePorts compares two ServicePort slices.
+func EqualsServicePorts(l []ServicePort, r []ServicePort) bool {
+	if len(l) != len(r) {
+		return false
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Name < l[j].Name
+	})
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Name < r[j].Name
+	})
+	var i int
+	for i = 0; i < len(l); i++ {
+		if l[i] != r[i] {
+			return false
+		}
+	}
+	return true
+}
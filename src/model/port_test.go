@@ -0,0 +1,138 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServicePortsValidate(t *testing.T) {
+	s := NewService("app", ProtocolHTTP)
+	s.Ports = []ServicePort{
+		{Name: "grpc", Protocol: ProtocolGRPC, IngressPort: 8081, SubstancePort: 9091},
+		{Name: "admin", Protocol: ProtocolTCP, IngressPort: 8082, SubstancePort: 9092},
+	}
+	assert.NoError(t, s.Validate())
+	ok, port := s.FindPort("admin")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(8082), port.IngressPort)
+	ok, _ = s.FindPort("unknown")
+	assert.False(t, ok)
+
+	invalids := []ServicePort{
+		{Name: "in.valid", Protocol: ProtocolGRPC, IngressPort: 8083, SubstancePort: 9093},
+		{Name: "invalid", Protocol: "UDP", IngressPort: 8083, SubstancePort: 9093},
+		{Name: "invalid", Protocol: ProtocolTCP, IngressPort: 0, SubstancePort: 9093},
+		{Name: "invalid", Protocol: ProtocolTCP, IngressPort: 8083, SubstancePort: 0},
+		// duplicates
+		{Name: "grpc", Protocol: ProtocolTCP, IngressPort: 8083, SubstancePort: 9093},
+		{Name: "invalid", Protocol: ProtocolTCP, IngressPort: 8081, SubstancePort: 9093},
+		{Name: "invalid", Protocol: ProtocolTCP, IngressPort: 8083, SubstancePort: 9091},
+	}
+	for _, p := range invalids {
+		invalid := s
+		invalid.Ports = append([]ServicePort{p}, s.Ports...)
+		assert.Error(t, invalid.Validate(), "%+v", p)
+	}
+
+	// egress port conflicts with the ingress port
+	s.DependentServices = []DependentService{{Name: "other", EgressPort: 8081}}
+	assert.Error(t, s.Validate())
+	s.DependentServices = []DependentService{{Name: "other", EgressPort: 10001}}
+	assert.NoError(t, s.Validate())
+
+	// external service
+	ext := NewService("ext", ProtocolHTTP)
+	ext.External = &ExternalService{Hostname: "example.com", Port: 443}
+	ext.Ports = s.Ports
+	assert.Error(t, ext.Validate())
+}
+
+func TestPortService(t *testing.T) {
+	s := NewService("app", ProtocolHTTP)
+	s.Subsets = []SubsetWeight{{Name: "v1", Weight: 1}}
+	s.HealthCheck = &ActiveHealthCheck{IntervalMS: 1000, TimeoutMS: 100, HealthyThreshold: 1, UnhealthyThreshold: 1}
+	s.RateLimit = &RateLimit{RequestsPerSecond: 100}
+	s.Labels = map[string]string{"team": "payments"}
+	s.Timeouts = &Timeouts{ConnectTimeoutMS: 100}
+	s.Version = "1"
+	port := ServicePort{Name: "grpc", Protocol: ProtocolGRPC, IngressPort: 8081, SubstancePort: 9091}
+
+	actual := s.PortService(&port)
+	assert.Equal(t, "app", actual.Name)
+	assert.Equal(t, ProtocolGRPC, actual.Protocol)
+	assert.Equal(t, s.Timeouts, actual.Timeouts)
+	assert.Equal(t, s.Version, actual.Version)
+	assert.Empty(t, actual.Subsets)
+	assert.Equal(t, s.HealthCheck, actual.HealthCheck)
+	assert.Equal(t, s.RateLimit, actual.RateLimit)
+	assert.Equal(t, s.Labels, actual.Labels)
+
+	// the inherited policies must be supported by the port
+	s.Subsets = nil
+	s.Ports = []ServicePort{port}
+	assert.NoError(t, s.Validate())
+	s.Ports = []ServicePort{{Name: "admin", Protocol: ProtocolTCP, IngressPort: 8082, SubstancePort: 9092}}
+	assert.Error(t, s.Validate())
+	s.RateLimit = nil
+	assert.NoError(t, s.Validate())
+	s.HealthCheck.Path = "/health"
+	assert.Error(t, s.Validate())
+	s.HealthCheck = nil
+	s.HealthCheckFilter = &HealthCheckFilter{Mode: HealthCheckFilterPassThrough}
+	assert.Error(t, s.Validate())
+}
+
+func TestValidateHostPorts(t *testing.T) {
+	s := NewService("app", ProtocolHTTP)
+	host, err := NewHost("app1", "192.168.0.1:80", "127.0.0.1:8080", "127.0.0.1")
+	assert.NoError(t, err)
+	assert.NoError(t, s.ValidateHostPorts(&host))
+
+	s.Ports = []ServicePort{{Name: "grpc", Protocol: ProtocolGRPC, IngressPort: 8081, SubstancePort: 9091}}
+	assert.NoError(t, s.ValidateHostPorts(&host))
+
+	conflicts := []ServicePort{
+		{Name: "grpc", Protocol: ProtocolGRPC, IngressPort: 80, SubstancePort: 9091},
+		{Name: "grpc", Protocol: ProtocolGRPC, IngressPort: DefaultAdminPort, SubstancePort: 9091},
+		{Name: "grpc", Protocol: ProtocolGRPC, IngressPort: 8081, SubstancePort: 8080},
+	}
+	for _, p := range conflicts {
+		s.Ports = []ServicePort{p}
+		assert.Error(t, s.ValidateHostPorts(&host), "%+v", p)
+	}
+
+	// the named ports can not be served by the unix socket
+	s.Ports = []ServicePort{{Name: "grpc", Protocol: ProtocolGRPC, IngressPort: 8081, SubstancePort: 9091}}
+	host, err = NewHost("app1", "192.168.0.1:80", "unix:///run/app.sock", "127.0.0.1")
+	assert.NoError(t, err)
+	assert.Error(t, s.ValidateHostPorts(&host))
+}
+
+func TestDependentServiceRef(t *testing.T) {
+	name, port := ParseServiceRef("app")
+	assert.Equal(t, "app", name)
+	assert.Empty(t, port)
+	name, port = ParseServiceRef("app:grpc")
+	assert.Equal(t, "app", name)
+	assert.Equal(t, "grpc", port)
+
+	s := NewService("front", ProtocolHTTP)
+	assert.NoError(t, s.AppendDependent(DependentService{Name: "app", EgressPort: 9001}))
+	assert.NoError(t, s.AppendDependent(DependentService{Name: "app", Port: "grpc", EgressPort: 9002}))
+	assert.Error(t, s.AppendDependent(DependentService{Name: "app", Port: "grpc", EgressPort: 9003}))
+	assert.NoError(t, s.Validate())
+	assert.Equal(t, "app:grpc", s.DependentServices[1].Ref())
+
+	ok, dep := s.FindDependentServiceRef("app:grpc")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(9002), dep.EgressPort)
+
+	invalid := s
+	invalid.DependentServices = []DependentService{{Name: "app", Port: "in.valid", EgressPort: 9001}}
+	assert.Error(t, invalid.Validate())
+
+	assert.True(t, s.RemoveDependent("app:grpc"))
+	assert.Equal(t, []DependentService{{Name: "app", EgressPort: 9001}}, s.DependentServices)
+	assert.False(t, s.RemoveDependent("app:grpc"))
+}
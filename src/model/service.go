@@ -1,38 +1,102 @@
 package model
 
 import (
-	"errors"
 	"fmt"
 	"reflect"
 	"regexp"
 	"sort"
 	"strings"
 
+	"github.com/pkg/errors"
 	"github.com/rerorero/meshem/src/utils"
 )
 
 // DependentService contains service and port
 type DependentService struct {
-	Name       string `json:"name" yaml:"name"`
-	EgressPort uint32 `json:"egressPort" yaml:"egressPort"`
+	Name string `json:"name" yaml:"name"`
+	// Port is the named port of the dependent service, the default port is used if it's empty.
+	Port       string       `json:"port,omitempty" yaml:"port,omitempty"`
+	EgressPort uint32       `json:"egressPort" yaml:"egressPort"`
+	Retry      *RetryPolicy `json:"retry,omitempty" yaml:"retry,omitempty"`
+	Timeouts   *Timeouts    `json:"timeouts,omitempty" yaml:"timeouts,omitempty"`
+	Fault      *FaultPolicy `json:"fault,omitempty" yaml:"fault,omitempty"`
+	// Headers are manipulated on the route to the dependent service.
+	Headers *HeaderPolicy `json:"headers,omitempty" yaml:"headers,omitempty"`
+}
+
+// SubsetWeight is the weight of traffic sent to the hosts which belong to the subset.
+type SubsetWeight struct {
+	Name   string `json:"name" yaml:"name"`
+	Weight uint32 `json:"weight" yaml:"weight"`
+}
+
+// HeaderMatch is a condition on a request header. The header only has to be present if Value is empty.
+type HeaderMatch struct {
+	Name  string `json:"name" yaml:"name"`
+	Value string `json:"value,omitempty" yaml:"value,omitempty"`
+	Regex bool   `json:"regex,omitempty" yaml:"regex,omitempty"`
+}
+
+// RouteRedirect responds to the matched requests with a redirect.
+type RouteRedirect struct {
+	Host         string `json:"host,omitempty" yaml:"host,omitempty"`
+	Path         string `json:"path,omitempty" yaml:"path,omitempty"`
+	ResponseCode uint32 `json:"responseCode,omitempty" yaml:"responseCode,omitempty"`
+}
+
+// RouteRule routes HTTP requests which match the path and headers to the target service or its subset.
+// The service itself is the target if Service is empty.
+type RouteRule struct {
+	Prefix        string         `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+	Path          string         `json:"path,omitempty" yaml:"path,omitempty"`
+	Regex         string         `json:"regex,omitempty" yaml:"regex,omitempty"`
+	Headers       []HeaderMatch  `json:"headers,omitempty" yaml:"headers,omitempty"`
+	PrefixRewrite string         `json:"prefixRewrite,omitempty" yaml:"prefixRewrite,omitempty"`
+	Redirect      *RouteRedirect `json:"redirect,omitempty" yaml:"redirect,omitempty"`
+	Service       string         `json:"service,omitempty" yaml:"service,omitempty"`
+	Subset        string         `json:"subset,omitempty" yaml:"subset,omitempty"`
 }
 
 // Service contains information of user service.
-// TODO: be able to configure more flexible routes.
+// Routes are evaluated in order by the callers of the service before the default route.
 type Service struct {
 	Name              string             `json:"name" yaml:"name"`
 	HostNames         []string           `json:"hostNames" yaml:"hostNames"`
 	DependentServices []DependentService `json:"dependentServices" yaml:"dependentServices"`
 	Protocol          string             `json:"protocol" yaml:"protocol"`
+	Ports             []ServicePort      `json:"ports,omitempty" yaml:"ports,omitempty"`
 	TraceSpan         string             `json:"trace_sapn" yaml:"trace_span"`
+	Subsets           []SubsetWeight     `json:"subsets" yaml:"subsets"`
+	Routes            []RouteRule        `json:"routes" yaml:"routes"`
+	Timeouts          *Timeouts          `json:"timeouts,omitempty" yaml:"timeouts,omitempty"`
+	Resilience        *ResiliencePolicy  `json:"resilience,omitempty" yaml:"resilience,omitempty"`
+	HealthCheck       *ActiveHealthCheck `json:"healthCheck,omitempty" yaml:"healthCheck,omitempty"`
+	HealthCheckFilter *HealthCheckFilter `json:"healthCheckFilter,omitempty" yaml:"healthCheckFilter,omitempty"`
+	RateLimit         *RateLimit         `json:"rateLimit,omitempty" yaml:"rateLimit,omitempty"`
+	LoadBalancer      *LoadBalancer      `json:"loadBalancer,omitempty" yaml:"loadBalancer,omitempty"`
+	External          *ExternalService   `json:"external,omitempty" yaml:"external,omitempty"`
+	Labels            map[string]string  `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Headers           *HeaderPolicy      `json:"headers,omitempty" yaml:"headers,omitempty"`
 	Version           Version            `json:"version" yaml:"version"`
 }
 
 // IdempotentServiceParam is used as a parameter by updating idempotently
 type IdempotentServiceParam struct {
 	Protocol          string             `json:"protocol" yaml:"protocol"`
+	Ports             []ServicePort      `json:"ports,omitempty" yaml:"ports,omitempty"`
 	Hosts             []Host             `json:"hosts" yaml:"hosts"`
 	DependentServices []DependentService `json:"dependentServices" yaml:"dependentServices"`
+	Subsets           []SubsetWeight     `json:"subsets" yaml:"subsets"`
+	Routes            []RouteRule        `json:"routes" yaml:"routes"`
+	Timeouts          *Timeouts          `json:"timeouts,omitempty" yaml:"timeouts,omitempty"`
+	Resilience        *ResiliencePolicy  `json:"resilience,omitempty" yaml:"resilience,omitempty"`
+	HealthCheck       *ActiveHealthCheck `json:"healthCheck,omitempty" yaml:"healthCheck,omitempty"`
+	HealthCheckFilter *HealthCheckFilter `json:"healthCheckFilter,omitempty" yaml:"healthCheckFilter,omitempty"`
+	RateLimit         *RateLimit         `json:"rateLimit,omitempty" yaml:"rateLimit,omitempty"`
+	LoadBalancer      *LoadBalancer      `json:"loadBalancer,omitempty" yaml:"loadBalancer,omitempty"`
+	External          *ExternalService   `json:"external,omitempty" yaml:"external,omitempty"`
+	Labels            map[string]string  `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Headers           *HeaderPolicy      `json:"headers,omitempty" yaml:"headers,omitempty"`
 }
 
 const (
@@ -40,19 +104,34 @@ const (
 	ProtocolHTTP = "HTTP"
 	// ProtocolTCP is for TCP service
 	ProtocolTCP = "TCP"
+	// ProtocolGRPC is for gRPC service which speaks HTTP/2
+	ProtocolGRPC = "GRPC"
 )
 
 var (
 	rServiceName = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,64}$`)
-	allProtocol  = []string{ProtocolHTTP, ProtocolTCP}
+	rSubsetName  = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,64}$`)
+	allProtocol  = []string{ProtocolHTTP, ProtocolTCP, ProtocolGRPC}
+	// 0 means the default code(301)
+	redirectResponseCodes = map[uint32]struct{}{0: {}, 301: {}, 302: {}, 303: {}, 307: {}, 308: {}}
 )
 
+// IsHTTPBasedProtocol returns true if the protocol is proxied at HTTP level.
+func IsHTTPBasedProtocol(protocol string) bool {
+	return protocol == ProtocolHTTP || protocol == ProtocolGRPC
+}
+
 // NewService creates a new service instance.
 func NewService(name string, protocol string) Service {
 	// currently trace span is same as service name.
 	return Service{Name: name, Protocol: protocol, TraceSpan: name}
 }
 
+// Namespace returns the namespace which the service belongs to.
+func (s *Service) Namespace() string {
+	return NamespaceOf(s.Name)
+}
+
 // Validate checks Service object format.
 func (s *Service) Validate() error {
 	err := validateServiceName(s.Name)
@@ -61,32 +140,59 @@ func (s *Service) Validate() error {
 	}
 
 	for _, h := range s.HostNames {
-		err := validateHostname(h)
+		err := validateQualifiedName(h, validateHostname)
 		if err != nil {
 			return err
 		}
+		if NamespaceOf(h) != s.Namespace() {
+			return fmt.Errorf("host=%s is not in the namespace of service=%s", h, s.Name)
+		}
 	}
 
 	// check the service names and port duplicates
 	ports := map[uint32]string{}
-	svcNames := map[string]bool{}
+	svcRefs := map[string]bool{}
 	var i int
 	for i = 0; i < len(s.DependentServices); i++ {
 		err := validateServiceName(s.DependentServices[i].Name)
 		if err != nil {
 			return err
 		}
+		if len(s.DependentServices[i].Port) > 0 {
+			err := validatePortName(s.DependentServices[i].Port)
+			if err != nil {
+				return err
+			}
+		}
 		if s.DependentServices[i].EgressPort == 0 {
 			return fmt.Errorf("invalid egress port number(%d) of service=%s", s.DependentServices[i].EgressPort, s.DependentServices[i].Name)
 		}
 		if s2, ok := ports[s.DependentServices[i].EgressPort]; ok {
-			return fmt.Errorf("duplicate service port=%d used for %s and %s", s.DependentServices[i].EgressPort, s.DependentServices[i].Name, s2)
+			return fmt.Errorf("duplicate service port=%d used for %s and %s", s.DependentServices[i].EgressPort, s.DependentServices[i].Ref(), s2)
+		}
+		if _, ok := svcRefs[s.DependentServices[i].Ref()]; ok {
+			return fmt.Errorf("duplicate dependent services: %s", s.DependentServices[i].Ref())
+		}
+		if s.DependentServices[i].Retry != nil {
+			err := s.DependentServices[i].Retry.Validate()
+			if err != nil {
+				return errors.Wrapf(err, "invalid retry policy of dependent service=%s", s.DependentServices[i].Name)
+			}
+		}
+		if s.DependentServices[i].Fault != nil {
+			err := s.DependentServices[i].Fault.Validate()
+			if err != nil {
+				return errors.Wrapf(err, "invalid fault policy of dependent service=%s", s.DependentServices[i].Name)
+			}
 		}
-		if _, ok := svcNames[s.DependentServices[i].Name]; ok {
-			return fmt.Errorf("duplicate dependent service names: %s", s.DependentServices[i].Name)
+		if s.DependentServices[i].Headers != nil {
+			err := s.DependentServices[i].Headers.Validate()
+			if err != nil {
+				return errors.Wrapf(err, "invalid headers of dependent service=%s", s.DependentServices[i].Name)
+			}
 		}
-		svcNames[s.DependentServices[i].Name] = true
-		ports[s.DependentServices[i].EgressPort] = s.DependentServices[i].Name
+		svcRefs[s.DependentServices[i].Ref()] = true
+		ports[s.DependentServices[i].EgressPort] = s.DependentServices[i].Ref()
 	}
 
 	_, ok := utils.ContainsString(allProtocol, s.Protocol)
@@ -94,17 +200,248 @@ func (s *Service) Validate() error {
 		return fmt.Errorf("%s is invalid protocol", s.Protocol)
 	}
 
+	err = s.validatePorts()
+	if err != nil {
+		return errors.Wrapf(err, "invalid ports of service=%s", s.Name)
+	}
+
+	// check the subset names and weights
+	if len(s.Subsets) > 0 {
+		if !IsHTTPBasedProtocol(s.Protocol) {
+			return fmt.Errorf("subsets are not supported by %s protocol (service=%s)", s.Protocol, s.Name)
+		}
+		subsetNames := map[string]bool{}
+		var total uint32
+		for i = 0; i < len(s.Subsets); i++ {
+			err := validateSubsetName(s.Subsets[i].Name)
+			if err != nil {
+				return err
+			}
+			if _, ok := subsetNames[s.Subsets[i].Name]; ok {
+				return fmt.Errorf("duplicate subset names: %s", s.Subsets[i].Name)
+			}
+			subsetNames[s.Subsets[i].Name] = true
+			total += s.Subsets[i].Weight
+		}
+		if total == 0 {
+			return fmt.Errorf("total weight of subsets must be greater than 0 (service=%s)", s.Name)
+		}
+	}
+
+	// check the timeouts
+	if s.Timeouts != nil && s.Timeouts.RequestTimeoutMS > 0 && !IsHTTPBasedProtocol(s.Protocol) {
+		return fmt.Errorf("request timeout is not supported by %s protocol (service=%s)", s.Protocol, s.Name)
+	}
+
+	if s.Resilience != nil {
+		err := s.Resilience.Validate()
+		if err != nil {
+			return errors.Wrapf(err, "invalid resilience policy of service=%s", s.Name)
+		}
+	}
+
+	if s.HealthCheck != nil {
+		err := s.HealthCheck.Validate(s.Protocol)
+		if err != nil {
+			return errors.Wrapf(err, "invalid health check of service=%s", s.Name)
+		}
+	}
+	if s.HealthCheckFilter != nil {
+		err := s.HealthCheckFilter.Validate(s.Protocol)
+		if err != nil {
+			return errors.Wrapf(err, "invalid health check filter of service=%s", s.Name)
+		}
+	}
+	if s.RateLimit != nil {
+		err := s.RateLimit.Validate(s.Protocol)
+		if err != nil {
+			return errors.Wrapf(err, "invalid rate limit of service=%s", s.Name)
+		}
+	}
+	if s.LoadBalancer != nil {
+		err := s.LoadBalancer.Validate(s.Protocol)
+		if err != nil {
+			return errors.Wrapf(err, "invalid load balancer of service=%s", s.Name)
+		}
+	}
+
+	if s.Headers != nil {
+		if !IsHTTPBasedProtocol(s.Protocol) {
+			return fmt.Errorf("headers are not supported by %s protocol (service=%s)", s.Protocol, s.Name)
+		}
+		err := s.Headers.Validate()
+		if err != nil {
+			return errors.Wrapf(err, "invalid headers of service=%s", s.Name)
+		}
+	}
+
+	err = ValidateLabels(s.Labels)
+	if err != nil {
+		return errors.Wrapf(err, "invalid labels of service=%s", s.Name)
+	}
+
+	if s.External != nil {
+		err := s.validateExternal()
+		if err != nil {
+			return errors.Wrapf(err, "invalid external service=%s", s.Name)
+		}
+	}
+
+	// check the route rules
+	if len(s.Routes) > 0 && !IsHTTPBasedProtocol(s.Protocol) {
+		return fmt.Errorf("routes are not supported by %s protocol (service=%s)", s.Protocol, s.Name)
+	}
+	for i = 0; i < len(s.Routes); i++ {
+		err := s.validateRouteRule(&s.Routes[i])
+		if err != nil {
+			return errors.Wrapf(err, "invalid route rule[%d] of service=%s", i, s.Name)
+		}
+	}
+
+	return nil
+}
+
+// validateExternal checks that the external service has nothing which requires the sidecars of its own.
+func (s *Service) validateExternal() error {
+	err := s.External.Validate()
+	if err != nil {
+		return err
+	}
+	if len(s.HostNames) > 0 {
+		return fmt.Errorf("external service can not have hosts")
+	}
+	if len(s.DependentServices) > 0 {
+		return fmt.Errorf("external service can not have dependencies")
+	}
+	if len(s.Subsets) > 0 {
+		return fmt.Errorf("external service can not have subsets")
+	}
+	if len(s.Ports) > 0 {
+		return fmt.Errorf("external service can not have named ports")
+	}
+	if s.HealthCheckFilter != nil || s.RateLimit != nil {
+		return fmt.Errorf("external service can not have ingress policies")
+	}
+	return nil
+}
+
+// IsExternal returns true if the service is outside of the mesh.
+func (s *Service) IsExternal() bool {
+	return s.External != nil
+}
+
+func (s *Service) validateRouteRule(rule *RouteRule) error {
+	err := rule.Validate()
+	if err != nil {
+		return err
+	}
+	// subsets of the other services are checked when they are applied.
+	if rule.Redirect == nil && len(rule.Subset) > 0 && rule.TargetService(s.Name) == s.Name {
+		if ok, _ := s.FindSubset(rule.Subset); !ok {
+			return fmt.Errorf("subset=%s is not defined", rule.Subset)
+		}
+	}
+	return nil
+}
+
+// Validate checks the format of the route rule regardless of the services to which it routes.
+func (rule *RouteRule) Validate() error {
+	matchers := 0
+	if len(rule.Prefix) > 0 {
+		matchers++
+		if !strings.HasPrefix(rule.Prefix, "/") {
+			return fmt.Errorf("prefix must start with '/': %s", rule.Prefix)
+		}
+	}
+	if len(rule.Path) > 0 {
+		matchers++
+		if !strings.HasPrefix(rule.Path, "/") {
+			return fmt.Errorf("path must start with '/': %s", rule.Path)
+		}
+	}
+	if len(rule.Regex) > 0 {
+		matchers++
+		if _, err := regexp.Compile(rule.Regex); err != nil {
+			return errors.Wrapf(err, "invalid regex: %s", rule.Regex)
+		}
+	}
+	if matchers != 1 {
+		return errors.New("exactly one of prefix, path and regex must be specified")
+	}
+
+	for _, h := range rule.Headers {
+		if len(h.Name) == 0 {
+			return errors.New("header name must be specified")
+		}
+		if h.Regex {
+			if _, err := regexp.Compile(h.Value); err != nil {
+				return errors.Wrapf(err, "invalid regex of header %s: %s", h.Name, h.Value)
+			}
+		}
+	}
+
+	if rule.Redirect != nil {
+		if len(rule.Service) > 0 || len(rule.Subset) > 0 || len(rule.PrefixRewrite) > 0 {
+			return errors.New("redirect can not be used with service, subset and prefixRewrite")
+		}
+		if len(rule.Redirect.Host) == 0 && len(rule.Redirect.Path) == 0 {
+			return errors.New("redirect needs either host or path")
+		}
+		if _, ok := redirectResponseCodes[rule.Redirect.ResponseCode]; !ok {
+			return fmt.Errorf("invalid redirect response code: %d", rule.Redirect.ResponseCode)
+		}
+		return nil
+	}
+
+	if len(rule.Service) > 0 {
+		err := validateServiceName(rule.Service)
+		if err != nil {
+			return err
+		}
+	}
+	if len(rule.Subset) > 0 {
+		err := validateSubsetName(rule.Subset)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// TargetService returns the name of service to which the rule routes requests.
+func (rule *RouteRule) TargetService(self string) string {
+	if len(rule.Service) > 0 {
+		return rule.Service
+	}
+	return self
+}
+
+// RouteTargetServiceNames returns names of the other services which the routes refer to.
+func (s *Service) RouteTargetServiceNames() (names []string) {
+	var i int
+	for i = 0; i < len(s.Routes); i++ {
+		if s.Routes[i].Redirect != nil {
+			continue
+		}
+		target := s.Routes[i].TargetService(s.Name)
+		if target == s.Name {
+			continue
+		}
+		if _, ok := utils.ContainsString(names, target); !ok {
+			names = append(names, target)
+		}
+	}
+	return names
+}
+
 // AppendDependent appends a new dpendent service.
 func (s *Service) AppendDependent(dependent DependentService) error {
 	// check dupclicates
 	if ok, dup := s.FindDependentServicePort(dependent.EgressPort); ok {
 		return fmt.Errorf("the port=%d is already used by %s", dependent.EgressPort, dup.Name)
 	}
-	if ok, _ := s.FindDependentServiceName(dependent.Name); ok {
-		return fmt.Errorf("the service=%s is already referenced by %s", dependent.Name, s.Name)
+	if ok, _ := s.FindDependentServiceRef(dependent.Ref()); ok {
+		return fmt.Errorf("the service=%s is already referenced by %s", dependent.Ref(), s.Name)
 	}
 	if dependent.EgressPort == 0 {
 		return fmt.Errorf("invalid egress port number(%d) of service=%s", dependent.EgressPort, dependent.Name)
@@ -113,13 +450,13 @@ func (s *Service) AppendDependent(dependent DependentService) error {
 	return nil
 }
 
-// RemoveDependent removes a dependent service.
-func (s *Service) RemoveDependent(name string) bool {
+// RemoveDependent removes a dependent service by the reference such as 'app' or 'app:grpc'.
+func (s *Service) RemoveDependent(ref string) bool {
 	updated := false
 	services := []DependentService{}
 	var i int
 	for i = 0; i < len(s.DependentServices); i++ {
-		if s.DependentServices[i].Name == name {
+		if s.DependentServices[i].Ref() == ref {
 			updated = true
 		} else {
 			services = append(services, s.DependentServices[i])
@@ -151,6 +488,43 @@ func (s *Service) FindDependentServiceName(name string) (bool, *DependentService
 	return false, nil
 }
 
+// FindDependentServiceRef finds a dependent service by the reference such as 'app' or 'app:grpc'.
+func (s *Service) FindDependentServiceRef(ref string) (bool, *DependentService) {
+	var i int
+	for i = 0; i < len(s.DependentServices); i++ {
+		if s.DependentServices[i].Ref() == ref {
+			return true, &s.DependentServices[i]
+		}
+	}
+	return false, nil
+}
+
+// FindSubset finds a subset by name.
+func (s *Service) FindSubset(name string) (bool, *SubsetWeight) {
+	var i int
+	for i = 0; i < len(s.Subsets); i++ {
+		if s.Subsets[i].Name == name {
+			return true, &s.Subsets[i]
+		}
+	}
+	return false, nil
+}
+
+// ValidateHostSubset checks that the host belongs to one of the subsets if the service has subsets,
+// otherwise the host never receives the requests.
+func (s *Service) ValidateHostSubset(h *Host) error {
+	if len(h.Subset) == 0 {
+		if len(s.Subsets) > 0 {
+			return fmt.Errorf("host=%s must belong to one of the subsets of service=%s", h.Name, s.Name)
+		}
+		return nil
+	}
+	if ok, _ := s.FindSubset(h.Subset); !ok {
+		return fmt.Errorf("host=%s belongs to the subset=%s which is not defined in service=%s", h.Name, h.Subset, s.Name)
+	}
+	return nil
+}
+
 // DependentServiceNames returns dependent service names.
 func (s *Service) DependentServiceNames() []string {
 	names := make([]string, len(s.DependentServices))
@@ -165,6 +539,9 @@ func compareServiceDependent(l *DependentService, r *DependentService) int {
 	if l.Name != r.Name {
 		return strings.Compare(l.Name, r.Name)
 	}
+	if l.Port != r.Port {
+		return strings.Compare(l.Port, r.Port)
+	}
 	return int(r.EgressPort) - int(l.EgressPort)
 }
 
@@ -188,9 +565,53 @@ func EqualsServiceDependencies(l []DependentService, r []DependentService) bool
 	return true
 }
 
+// EqualsSubsets compares two SubsetWeight slices.
+func EqualsSubsets(l []SubsetWeight, r []SubsetWeight) bool {
+	if len(l) != len(r) {
+		return false
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Name < l[j].Name
+	})
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Name < r[j].Name
+	})
+	var i int
+	for i = 0; i < len(l); i++ {
+		if l[i] != r[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// EqualsRouteRules compares two RouteRule slices. The order of rules is significant.
+func EqualsRouteRules(l []RouteRule, r []RouteRule) bool {
+	if len(l) != len(r) {
+		return false
+	}
+	var i int
+	for i = 0; i < len(l); i++ {
+		if !reflect.DeepEqual(l[i], r[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateServiceName checks the format of the service name which may be qualified with the namespace.
 func validateServiceName(s string) error {
-	if !rServiceName.MatchString(s) {
-		return errors.New("service name must consist of alphanumeric characters, underscores and dashes, and less than 64 characters")
+	return validateQualifiedName(s, func(name string) error {
+		if !rServiceName.MatchString(name) {
+			return errors.New("service name must consist of alphanumeric characters, underscores and dashes, and less than 64 characters")
+		}
+		return nil
+	})
+}
+
+func validateSubsetName(s string) error {
+	if !rSubsetName.MatchString(s) {
+		return errors.New("subset name must consist of alphanumeric characters, underscores and dashes, and less than 64 characters")
 	}
 	return nil
 }
@@ -226,7 +647,19 @@ func (param *IdempotentServiceParam) NewService(name string) Service {
 		HostNames:         hostnames,
 		DependentServices: param.DependentServices,
 		Protocol:          param.Protocol,
+		Ports:             param.Ports,
 		TraceSpan:         name,
+		Subsets:           param.Subsets,
+		Routes:            param.Routes,
+		Timeouts:          param.Timeouts,
+		Resilience:        param.Resilience,
+		HealthCheck:       param.HealthCheck,
+		HealthCheckFilter: param.HealthCheckFilter,
+		RateLimit:         param.RateLimit,
+		LoadBalancer:      param.LoadBalancer,
+		External:          param.External,
+		Labels:            param.Labels,
+		Headers:           param.Headers,
 	}
 }
 
@@ -234,7 +667,19 @@ func (param *IdempotentServiceParam) NewService(name string) Service {
 func NewIdempotentService(svc *Service, hosts []Host) IdempotentServiceParam {
 	return IdempotentServiceParam{
 		Protocol:          svc.Protocol,
+		Ports:             svc.Ports,
 		Hosts:             hosts,
 		DependentServices: svc.DependentServices,
+		Subsets:           svc.Subsets,
+		Routes:            svc.Routes,
+		Timeouts:          svc.Timeouts,
+		Resilience:        svc.Resilience,
+		HealthCheck:       svc.HealthCheck,
+		HealthCheckFilter: svc.HealthCheckFilter,
+		RateLimit:         svc.RateLimit,
+		LoadBalancer:      svc.LoadBalancer,
+		External:          svc.External,
+		Labels:            svc.Labels,
+		Headers:           svc.Headers,
 	}
 }
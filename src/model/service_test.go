@@ -2,6 +2,7 @@ package model
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -28,12 +29,12 @@ func TestServiceValidate(t *testing.T) {
 	assert.NoError(t, s.Validate())
 
 	// invalid service name
-	s.Name = "ivalid.aaa"
+	s.Name = "ivalid.aa.a"
 	assert.Error(t, s.Validate())
 
 	// invalid host names
 	s.Name = "valid"
-	s.HostNames = append(s.HostNames, "in.valid")
+	s.HostNames = append(s.HostNames, "in.val.id")
 	assert.Error(t, s.Validate())
 
 	// invalid protocol
@@ -132,3 +133,400 @@ func TestEqualsSserviceDependencies(t *testing.T) {
 	assert.False(t, EqualsServiceDependencies(a, b))
 	assert.False(t, EqualsServiceDependencies(a, c))
 }
+
+func TestServiceValidateSubsets(t *testing.T) {
+	s := Service{
+		Name:      "service",
+		HostNames: []string{"valid"},
+		Protocol:  ProtocolHTTP,
+		Subsets: []SubsetWeight{
+			{Name: "v1", Weight: 90},
+			{Name: "v2", Weight: 10},
+		},
+	}
+	assert.NoError(t, s.Validate())
+	ok, subset := s.FindSubset("v2")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(10), subset.Weight)
+	ok, _ = s.FindSubset("v3")
+	assert.False(t, ok)
+	assert.NoError(t, s.ValidateHostSubset(&Host{Name: "a", Subset: "v1"}))
+	assert.Error(t, s.ValidateHostSubset(&Host{Name: "a", Subset: "v3"}))
+	assert.Error(t, s.ValidateHostSubset(&Host{Name: "a"}))
+
+	// invalid subset name
+	s.Subsets = []SubsetWeight{{Name: "v.1", Weight: 100}}
+	assert.Error(t, s.Validate())
+
+	// duplicate subset names
+	s.Subsets = []SubsetWeight{{Name: "v1", Weight: 50}, {Name: "v1", Weight: 50}}
+	assert.Error(t, s.Validate())
+
+	// zero total weight
+	s.Subsets = []SubsetWeight{{Name: "v1", Weight: 0}, {Name: "v2", Weight: 0}}
+	assert.Error(t, s.Validate())
+
+	// TCP doesn't support subsets
+	s.Subsets = []SubsetWeight{{Name: "v1", Weight: 100}}
+	s.Protocol = ProtocolTCP
+	assert.Error(t, s.Validate())
+}
+
+func TestEqualsSubsets(t *testing.T) {
+	a := []SubsetWeight{{Name: "v1", Weight: 90}, {Name: "v2", Weight: 10}}
+	sameA := []SubsetWeight{{Name: "v2", Weight: 10}, {Name: "v1", Weight: 90}}
+	b := []SubsetWeight{{Name: "v1", Weight: 80}, {Name: "v2", Weight: 20}}
+	c := []SubsetWeight{{Name: "v1", Weight: 90}}
+	assert.True(t, EqualsSubsets(a, sameA))
+	assert.False(t, EqualsSubsets(a, b))
+	assert.False(t, EqualsSubsets(a, c))
+	assert.True(t, EqualsSubsets(nil, []SubsetWeight{}))
+}
+
+func TestServiceValidateRoutes(t *testing.T) {
+	s := Service{
+		Name:      "service",
+		HostNames: []string{"valid"},
+		Protocol:  ProtocolHTTP,
+		Subsets:   []SubsetWeight{{Name: "v1", Weight: 100}},
+		Routes: []RouteRule{
+			{Prefix: "/api", Subset: "v1", PrefixRewrite: "/"},
+			{Path: "/exact", Service: "other", Subset: "v2"},
+			{Regex: "/users/[0-9]+", Headers: []HeaderMatch{{Name: "x-debug"}, {Name: "x-user", Value: "^a.*", Regex: true}}},
+			{Prefix: "/old", Redirect: &RouteRedirect{Path: "/new", ResponseCode: 302}},
+		},
+	}
+	assert.NoError(t, s.Validate())
+	assert.Equal(t, []string{"other"}, s.RouteTargetServiceNames())
+
+	valid := s.Routes
+	invalids := []RouteRule{
+		// no matcher
+		{Service: "other"},
+		// multiple matchers
+		{Prefix: "/a", Path: "/a"},
+		// invalid path
+		{Prefix: "a"},
+		{Path: "a"},
+		{Regex: "(a"},
+		// invalid header
+		{Prefix: "/", Headers: []HeaderMatch{{Value: "a"}}},
+		{Prefix: "/", Headers: []HeaderMatch{{Name: "a", Value: "(a", Regex: true}}},
+		// invalid target
+		{Prefix: "/", Service: "in.val.id"},
+		{Prefix: "/", Subset: "v2"},
+		// invalid redirect
+		{Prefix: "/", Redirect: &RouteRedirect{}},
+		{Prefix: "/", Redirect: &RouteRedirect{Host: "example.com", ResponseCode: 200}},
+		{Prefix: "/", Service: "other", Redirect: &RouteRedirect{Host: "example.com"}},
+	}
+	for _, rule := range invalids {
+		s.Routes = append(valid, rule)
+		assert.Error(t, s.Validate(), "%+v", rule)
+	}
+
+	// TCP doesn't support routes
+	s.Subsets = nil
+	s.Routes = []RouteRule{{Prefix: "/", Service: "other"}}
+	s.Protocol = ProtocolTCP
+	assert.Error(t, s.Validate())
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	s := Service{
+		Name:     "service",
+		Protocol: ProtocolHTTP,
+		DependentServices: []DependentService{{
+			Name:       "dep",
+			EgressPort: 9001,
+			Retry: &RetryPolicy{
+				RetryOn:         []string{RetryOn5xx, RetryOnConnectFailure},
+				NumRetries:      2,
+				PerTryTimeoutMS: 100,
+			},
+		}},
+	}
+	assert.NoError(t, s.Validate())
+
+	invalids := []RetryPolicy{
+		// no condition
+		{NumRetries: 2},
+		// unknown condition
+		{RetryOn: []string{"unknown"}},
+		// status codes without the condition
+		{RetryOn: []string{RetryOn5xx}, RetriableStatusCodes: []uint32{409}},
+		// condition without status codes
+		{RetryOn: []string{RetryOnRetriableStatusCodes}},
+		// invalid status code
+		{RetryOn: []string{RetryOnRetriableStatusCodes}, RetriableStatusCodes: []uint32{999}},
+	}
+	for i := range invalids {
+		s.DependentServices[0].Retry = &invalids[i]
+		assert.Error(t, s.Validate(), "%+v", invalids[i])
+	}
+
+	s.DependentServices[0].Retry = &RetryPolicy{
+		RetryOn:              []string{RetryOnRetriableStatusCodes},
+		RetriableStatusCodes: []uint32{409, 503},
+	}
+	assert.NoError(t, s.Validate())
+	assert.False(t, s.DependentServices[0].Retry.HasGRPCConditions())
+
+	// gRPC status
+	s.DependentServices[0].Retry = &RetryPolicy{
+		RetryOn: []string{RetryOnUnavailable, RetryOnDeadlineExceeded, RetryOnConnectFailure},
+	}
+	assert.NoError(t, s.Validate())
+	assert.True(t, s.DependentServices[0].Retry.HasGRPCConditions())
+}
+
+func TestServiceValidateGRPC(t *testing.T) {
+	s := Service{
+		Name:     "service",
+		Protocol: ProtocolGRPC,
+		Subsets: []SubsetWeight{
+			{Name: "v1", Weight: 100},
+		},
+		Routes: []RouteRule{
+			{Prefix: "/helloworld.Greeter/", Subset: "v1"},
+		},
+		Timeouts:          &Timeouts{RequestTimeoutMS: 1000},
+		HealthCheck:       &ActiveHealthCheck{IntervalMS: 5000, TimeoutMS: 1000, HealthyThreshold: 1, UnhealthyThreshold: 2},
+		HealthCheckFilter: &HealthCheckFilter{Mode: HealthCheckFilterEndpoint},
+	}
+	assert.NoError(t, s.Validate())
+	assert.True(t, IsHTTPBasedProtocol(ProtocolGRPC))
+	assert.True(t, IsHTTPBasedProtocol(ProtocolHTTP))
+	assert.False(t, IsHTTPBasedProtocol(ProtocolTCP))
+}
+
+func TestTimeoutsOverride(t *testing.T) {
+	var empty *Timeouts
+	assert.Equal(t, &Timeouts{}, empty.Override(nil))
+	assert.Equal(t, &Timeouts{IdleTimeoutMS: 10}, empty.Override(&Timeouts{IdleTimeoutMS: 10}))
+
+	base := &Timeouts{ConnectTimeoutMS: 100, RequestTimeoutMS: 200, IdleTimeoutMS: 300}
+	assert.Equal(t, base, base.Override(nil))
+	assert.Equal(t, &Timeouts{ConnectTimeoutMS: 100, RequestTimeoutMS: 50, IdleTimeoutMS: 300}, base.Override(&Timeouts{RequestTimeoutMS: 50}))
+	// base is not modified
+	assert.Equal(t, uint32(200), base.RequestTimeoutMS)
+
+	// request timeout is only for HTTP
+	s := Service{Name: "service", Protocol: ProtocolTCP, Timeouts: &Timeouts{IdleTimeoutMS: 300}}
+	assert.NoError(t, s.Validate())
+	s.Timeouts.RequestTimeoutMS = 200
+	assert.Error(t, s.Validate())
+	s.Protocol = ProtocolHTTP
+	assert.NoError(t, s.Validate())
+}
+
+func TestResiliencePolicyValidate(t *testing.T) {
+	s := Service{
+		Name:     "service",
+		Protocol: ProtocolTCP,
+		Resilience: &ResiliencePolicy{
+			CircuitBreaker:   &CircuitBreaker{MaxConnections: 100},
+			OutlierDetection: &OutlierDetection{Consecutive5xx: 5, MaxEjectionPercent: 100},
+		},
+	}
+	assert.NoError(t, s.Validate())
+	s.Resilience.OutlierDetection.MaxEjectionPercent = 101
+	assert.Error(t, s.Validate())
+}
+
+func TestActiveHealthCheckValidate(t *testing.T) {
+	s := Service{
+		Name:        "service",
+		Protocol:    ProtocolHTTP,
+		HealthCheck: &ActiveHealthCheck{Path: "/health", IntervalMS: 5000, TimeoutMS: 1000, HealthyThreshold: 1, UnhealthyThreshold: 2},
+	}
+	assert.NoError(t, s.Validate())
+	assert.True(t, s.HealthCheck.IsHTTP())
+
+	invalids := []ActiveHealthCheck{
+		{Path: "health", IntervalMS: 5000, TimeoutMS: 1000, HealthyThreshold: 1, UnhealthyThreshold: 2},
+		{Path: "/health", TimeoutMS: 1000, HealthyThreshold: 1, UnhealthyThreshold: 2},
+		{Path: "/health", IntervalMS: 5000, HealthyThreshold: 1, UnhealthyThreshold: 2},
+		{Path: "/health", IntervalMS: 5000, TimeoutMS: 1000, UnhealthyThreshold: 2},
+		{Path: "/health", IntervalMS: 5000, TimeoutMS: 1000, HealthyThreshold: 1},
+	}
+	for i := range invalids {
+		s.HealthCheck = &invalids[i]
+		assert.Error(t, s.Validate(), "%+v", invalids[i])
+	}
+
+	// TCP service can only be checked by connecting
+	s.Protocol = ProtocolTCP
+	s.HealthCheck = &ActiveHealthCheck{Path: "/health", IntervalMS: 5000, TimeoutMS: 1000, HealthyThreshold: 1, UnhealthyThreshold: 2}
+	assert.Error(t, s.Validate())
+	s.HealthCheck.Path = ""
+	assert.NoError(t, s.Validate())
+	assert.False(t, s.HealthCheck.IsHTTP())
+}
+
+func TestHealthCheckFilterValidate(t *testing.T) {
+	s := Service{
+		Name:              "service",
+		Protocol:          ProtocolHTTP,
+		HealthCheckFilter: &HealthCheckFilter{Mode: HealthCheckFilterPassThrough, Endpoint: "/health", CacheTimeMS: 1000},
+	}
+	assert.NoError(t, s.Validate())
+	assert.True(t, s.HealthCheckFilter.IsEnabled())
+
+	invalids := []HealthCheckFilter{
+		{Mode: "unknown"},
+		{Mode: HealthCheckFilterEndpoint, Endpoint: "health"},
+		{Mode: HealthCheckFilterEndpoint, Endpoint: "/health", CacheTimeMS: 1000},
+	}
+	for i := range invalids {
+		s.HealthCheckFilter = &invalids[i]
+		assert.Error(t, s.Validate(), "%+v", invalids[i])
+	}
+
+	// only disabled for TCP
+	s.Protocol = ProtocolTCP
+	s.HealthCheckFilter = &HealthCheckFilter{Mode: HealthCheckFilterEndpoint}
+	assert.Error(t, s.Validate())
+	s.HealthCheckFilter = &HealthCheckFilter{Mode: HealthCheckFilterDisabled}
+	assert.NoError(t, s.Validate())
+	assert.False(t, s.HealthCheckFilter.IsEnabled())
+}
+
+func TestFaultPolicyValidate(t *testing.T) {
+	s := Service{
+		Name:     "service",
+		Protocol: ProtocolHTTP,
+		DependentServices: []DependentService{{
+			Name:       "app",
+			EgressPort: 9001,
+			Fault: &FaultPolicy{
+				Delay: &FaultDelay{Percentage: 50, DurationMS: 1000},
+				Abort: &FaultAbort{Percentage: 100, HTTPStatus: 503},
+			},
+		}},
+	}
+	assert.NoError(t, s.Validate())
+	fault := s.DependentServices[0].Fault
+
+	fault.Delay.Percentage = 101
+	assert.Error(t, s.Validate())
+	fault.Delay.Percentage = 50
+	fault.Delay.DurationMS = 0
+	assert.Error(t, s.Validate())
+	fault.Delay = nil
+	assert.NoError(t, s.Validate())
+	fault.Abort.HTTPStatus = 600
+	assert.Error(t, s.Validate())
+	fault.Abort = nil
+	assert.Error(t, s.Validate())
+}
+
+func TestFaultPolicyIsActive(t *testing.T) {
+	now := time.Now()
+	var nilFault *FaultPolicy
+	assert.False(t, nilFault.IsActive(now))
+	assert.True(t, (&FaultPolicy{}).IsActive(now))
+	expiresAt := now.Add(time.Minute)
+	fault := &FaultPolicy{ExpiresAt: &expiresAt}
+	assert.True(t, fault.IsActive(now))
+	assert.False(t, fault.IsActive(expiresAt))
+}
+
+func TestRateLimitValidate(t *testing.T) {
+	s := Service{
+		Name:      "service",
+		Protocol:  ProtocolHTTP,
+		RateLimit: &RateLimit{RequestsPerSecond: 100, Burst: 200},
+	}
+	assert.NoError(t, s.Validate())
+	assert.Equal(t, uint32(200), s.RateLimit.MaxTokens())
+	s.RateLimit.Burst = 0
+	assert.NoError(t, s.Validate())
+	assert.Equal(t, uint32(100), s.RateLimit.MaxTokens())
+	s.RateLimit.Burst = 50
+	assert.Error(t, s.Validate())
+	s.RateLimit = &RateLimit{Burst: 10}
+	assert.Error(t, s.Validate())
+	s.RateLimit = &RateLimit{RequestsPerSecond: 100, ConnectionsPerSecond: 10}
+	assert.Error(t, s.Validate())
+
+	// TCP limits the connections
+	s.Protocol = ProtocolTCP
+	s.RateLimit = &RateLimit{ConnectionsPerSecond: 10}
+	assert.NoError(t, s.Validate())
+	s.RateLimit = &RateLimit{RequestsPerSecond: 100}
+	assert.Error(t, s.Validate())
+	s.RateLimit = &RateLimit{ConnectionsPerSecond: 10, Burst: 20}
+	assert.Error(t, s.Validate())
+}
+
+func TestLoadBalancerValidate(t *testing.T) {
+	s := Service{
+		Name:         "service",
+		Protocol:     ProtocolTCP,
+		LoadBalancer: &LoadBalancer{Policy: LBLeastRequest},
+	}
+	assert.NoError(t, s.Validate())
+	s.LoadBalancer.Policy = "unknown"
+	assert.Error(t, s.Validate())
+	s.LoadBalancer = &LoadBalancer{Policy: LBRandom, HashKey: &HashKey{SourceIP: true}}
+	assert.Error(t, s.Validate())
+
+	// consistent hashing
+	s.LoadBalancer = &LoadBalancer{Policy: LBRingHash, HashKey: &HashKey{Header: "x-user-id"}}
+	assert.Error(t, s.Validate())
+	s.Protocol = ProtocolHTTP
+	assert.NoError(t, s.Validate())
+	s.LoadBalancer = &LoadBalancer{Policy: LBMaglev, HashKey: &HashKey{Cookie: "session"}}
+	assert.NoError(t, s.Validate())
+	s.LoadBalancer.HashKey = nil
+	assert.Error(t, s.Validate())
+	s.LoadBalancer.HashKey = &HashKey{}
+	assert.Error(t, s.Validate())
+	s.LoadBalancer.HashKey = &HashKey{Cookie: "session", SourceIP: true}
+	assert.Error(t, s.Validate())
+}
+
+func TestExternalServiceValidate(t *testing.T) {
+	s := Service{
+		Name:     "payment",
+		Protocol: ProtocolHTTP,
+		External: &ExternalService{Hostname: "api.payment.example.com", Port: 443, TLS: &ExternalTLS{CAFile: "/etc/ssl/certs/ca-certificates.crt"}},
+	}
+	assert.NoError(t, s.Validate())
+	assert.True(t, s.IsExternal())
+	assert.Equal(t, "api.payment.example.com", s.External.SNI())
+	s.External.TLS.SNI = "payment.example.com"
+	assert.Equal(t, "payment.example.com", s.External.SNI())
+	s.External.Resolution = ResolutionLogicalDNS
+	assert.NoError(t, s.Validate())
+	s.External.TLS = &ExternalTLS{InsecureSkipVerify: true}
+	assert.NoError(t, s.Validate())
+
+	invalids := []ExternalService{
+		{Port: 443},
+		{Hostname: "api.payment.example.com:443", Port: 443},
+		{Hostname: "api.payment.example.com"},
+		{Hostname: "api.payment.example.com", Port: 443, Resolution: "static"},
+		{Hostname: "api.payment.example.com", Port: 443, TLS: &ExternalTLS{}},
+		{Hostname: "api.payment.example.com", Port: 443, TLS: &ExternalTLS{CAFile: "/etc/ssl/certs/ca-certificates.crt", InsecureSkipVerify: true}},
+	}
+	for _, external := range invalids {
+		s.External = &external
+		assert.Error(t, s.Validate(), external)
+	}
+
+	// the external service has no sidecars
+	s.External = &ExternalService{Hostname: "api.payment.example.com", Port: 443}
+	s.HostNames = []string{"host1"}
+	assert.Error(t, s.Validate())
+	s.HostNames = nil
+	s.DependentServices = []DependentService{{Name: "app", EgressPort: 9001}}
+	assert.Error(t, s.Validate())
+	s.DependentServices = nil
+	s.Subsets = []SubsetWeight{{Name: "v1", Weight: 1}}
+	assert.Error(t, s.Validate())
+	s.Subsets = nil
+	s.RateLimit = &RateLimit{RequestsPerSecond: 10}
+	assert.Error(t, s.Validate())
+}
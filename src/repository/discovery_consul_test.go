@@ -47,6 +47,7 @@ func TestDiscoveryRegister(t *testing.T) {
 
 	// overwrite
 	host2, err := model.NewHost("reg1", "192.168.20.30:9000", "127.0.0.1:9090", "127.0.0.1")
+	host2.AdminAddr = &model.Address{Hostname: "192.168.20.30", Port: 18001}
 	tags["c"] = "c1"
 	err = sut.Register(host2, tags)
 	assert.NoError(t, err)
@@ -18,6 +18,7 @@ type inventoryConsul struct {
 const (
 	hostPrefix    = "hosts"
 	servicePrefix = "services"
+	gatewayPrefix = "gateways"
 )
 
 func NewInventoryConsul(consul *utils.Consul) InventoryRepository {
@@ -56,7 +57,7 @@ func (inventory *inventoryConsul) SelectHostByName(name string) (host model.Host
 
 // returns (true, nil) if it is deleted
 func (inventory *inventoryConsul) DeleteHost(name string) (bool, error) {
-	return inventory.consul.DeleteTreeIfExists(withHostPrefix(name))
+	return inventory.consul.DeleteIfExists(withHostPrefix(name))
 }
 
 func (inventory *inventoryConsul) SelectAllHostNames() ([]string, error) {
@@ -137,9 +138,9 @@ func (inventory *inventoryConsul) SelectServiceByName(name string) (service mode
 	return service, true, nil
 }
 
-// returns (true, nil) if it is deleted
+// returns (true, nil) if it is deleted, the services in the other namespaces such as 'name.ns' are kept.
 func (inventory *inventoryConsul) DeleteService(name string) (bool, error) {
-	return inventory.consul.DeleteTreeIfExists(withServicePrefix(name))
+	return inventory.consul.DeleteIfExists(withServicePrefix(name))
 }
 
 func (inventory *inventoryConsul) SelectAllServiceNames() ([]string, error) {
@@ -217,6 +218,46 @@ func (inventory *inventoryConsul) SelectReferringServiceNamesTo(service string)
 	return referrers, nil
 }
 
+// PutGateway puts Gateway object to Consul.
+func (inventory *inventoryConsul) PutGateway(gw model.Gateway, version model.Version) error {
+	gw.Version = version
+	js, err := json.Marshal(gw)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to marshal Gateway: %+v", gw)
+	}
+	return inventory.consul.PutKV(withGatewayPrefix(gw.Name), string(js))
+}
+
+func (inventory *inventoryConsul) SelectGatewayByName(name string) (gw model.Gateway, ok bool, err error) {
+	js, ok, err := inventory.consul.GetKV(withGatewayPrefix(name))
+	if err != nil {
+		return gw, false, err
+	}
+	if !ok {
+		return gw, false, nil
+	}
+
+	err = json.Unmarshal([]byte(js), &gw)
+	if err != nil {
+		return gw, false, errors.Wrapf(err, "Gateway object may be broken: %s", js)
+	}
+
+	return gw, true, nil
+}
+
+// returns (true, nil) if it is deleted
+func (inventory *inventoryConsul) DeleteGateway(name string) (bool, error) {
+	return inventory.consul.DeleteIfExists(withGatewayPrefix(name))
+}
+
+func (inventory *inventoryConsul) SelectAllGatewayNames() ([]string, error) {
+	names, err := inventory.consul.GetSubKeyNames(gatewayPrefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list gateway names")
+	}
+	return names, nil
+}
+
 // returns an error if key doesn't exist
 func (inventory *inventoryConsul) getKVAddressExactly(key string) (*model.Address, error) {
 	str, err := inventory.consul.GetKVExactly(key)
@@ -242,3 +283,7 @@ func withServicePrefix(sub string) string {
 func withHostPrefix(sub string) string {
 	return fmt.Sprintf("%s/%s", hostPrefix, sub)
 }
+
+func withGatewayPrefix(sub string) string {
+	return fmt.Sprintf("%s/%s", gatewayPrefix, sub)
+}
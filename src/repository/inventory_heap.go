@@ -9,6 +9,7 @@ import (
 type inventoryHeap struct {
 	services []*model.Service
 	hosts    []*model.Host
+	gateways []*model.Gateway
 }
 
 // NewInventoryHeap creates a heap inventory instance.
@@ -182,3 +183,46 @@ func (inv *inventoryHeap) RemoveServiceDependency(serviceName string, depend str
 	removed := svc.RemoveDependent(depend)
 	return removed, inv.PutService(svc, version)
 }
+
+func (inv *inventoryHeap) findGateway(name string) (int, bool) {
+	var i int
+	for i = 0; i < len(inv.gateways); i++ {
+		if inv.gateways[i].Name == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+func (inv *inventoryHeap) PutGateway(gw model.Gateway, version model.Version) error {
+	gw.Version = version
+	if i, ok := inv.findGateway(gw.Name); ok {
+		*inv.gateways[i] = gw
+	} else {
+		inv.gateways = append(inv.gateways, &gw)
+	}
+	return nil
+}
+
+func (inv *inventoryHeap) SelectGatewayByName(name string) (model.Gateway, bool, error) {
+	if i, ok := inv.findGateway(name); ok {
+		return *inv.gateways[i], true, nil
+	}
+	return model.Gateway{}, false, nil
+}
+
+func (inv *inventoryHeap) DeleteGateway(name string) (bool, error) {
+	i, ok := inv.findGateway(name)
+	if !ok {
+		return false, nil
+	}
+	inv.gateways = append(inv.gateways[:i:i], inv.gateways[i+1:]...)
+	return true, nil
+}
+
+func (inv *inventoryHeap) SelectAllGatewayNames() (names []string, err error) {
+	for _, gw := range inv.gateways {
+		names = append(names, gw.Name)
+	}
+	return names, nil
+}
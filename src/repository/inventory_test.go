@@ -33,6 +33,7 @@ func testGetService(t *testing.T, sut InventoryRepository) {
 		Name:              "service1",
 		HostNames:         []string{"host1", "host2"},
 		DependentServices: dep1,
+		Labels:            map[string]string{"team": "payments"},
 		Version:           "abc",
 	}
 	dep2 := []model.DependentService{
@@ -96,6 +97,18 @@ func testGetService(t *testing.T, sut InventoryRepository) {
 	services, err = sut.SelectAllServices()
 	assert.NoError(t, err)
 	assert.Equal(t, []model.Service{*s2}, services)
+
+	// the service of the same name in the other namespace is kept
+	s3 := model.Service{Name: "service2.payments", Version: "ghi"}
+	err = sut.PutService(s3, s3.Version)
+	assert.NoError(t, err)
+	ok, err = sut.DeleteService(s2.Name)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	actual, ok, err := sut.SelectServiceByName(s3.Name)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, s3, actual)
 }
 
 func TestHostServiceConsul(t *testing.T) {
@@ -119,6 +132,7 @@ func testHostService(t *testing.T, sut InventoryRepository) {
 		IngressAddr:   *ip1,
 		EgressHost:    "127.0.0.1",
 		SubstanceAddr: *ip2,
+		Labels:        map[string]string{"tier": "web"},
 	}
 	h2 := &model.Host{
 		Name:          "host02",
@@ -272,3 +286,71 @@ func testServiceDependencies(t *testing.T, sut InventoryRepository) {
 	assert.NoError(t, err)
 	assert.False(t, ok)
 }
+
+func TestGatewayConsul(t *testing.T) {
+	consul := utils.NewConsulMock()
+	consul.Client.KV().DeleteTree(gatewayPrefix, nil)
+	testGateway(t, NewInventoryConsul(consul))
+}
+
+func TestGatewayHeap(t *testing.T) {
+	testGateway(t, NewInventoryHeap())
+}
+
+func testGateway(t *testing.T, sut InventoryRepository) {
+	gw1 := model.Gateway{
+		Name:  "public",
+		Nodes: []string{"gw1", "gw2"},
+		Listeners: []model.GatewayListener{{
+			Address: model.Address{Hostname: "0.0.0.0", Port: 443},
+			TLS:     &model.GatewayTLS{CertFile: "/etc/cert.pem", KeyFile: "/etc/key.pem"},
+			VirtualHosts: []model.GatewayVirtualHost{{
+				Name:    "front",
+				Domains: []string{"example.com"},
+				Routes:  []model.RouteRule{{Prefix: "/", Service: "front"}},
+			}},
+		}},
+		Version: "abc",
+	}
+	gw2 := model.Gateway{
+		Name:    "internal",
+		Nodes:   []string{"gw3"},
+		Version: "def",
+	}
+
+	// put
+	assert.NoError(t, sut.PutGateway(gw1, gw1.Version))
+	assert.NoError(t, sut.PutGateway(gw2, gw2.Version))
+
+	// by name
+	actual, ok, err := sut.SelectGatewayByName("public")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, gw1, actual)
+	_, ok, err = sut.SelectGatewayByName("unknown")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// update
+	gw1.Nodes = []string{"gw1"}
+	assert.NoError(t, sut.PutGateway(gw1, "ghi"))
+	actual, _, err = sut.SelectGatewayByName("public")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gw1"}, actual.Nodes)
+	assert.Equal(t, model.Version("ghi"), actual.Version)
+
+	names, err := sut.SelectAllGatewayNames()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"public", "internal"}, names)
+
+	// delete
+	ok, err = sut.DeleteGateway("public")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = sut.DeleteGateway("public")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	names, err = sut.SelectAllGatewayNames()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"internal"}, names)
+}
@@ -18,6 +18,10 @@ type InventoryRepository interface {
 	AddServiceDependency(serviceName string, depend model.DependentService, version model.Version) error
 	RemoveServiceDependency(serviceName string, depend string, version model.Version) (bool, error)
 	SelectReferringServiceNamesTo(service string) ([]string, error)
+	PutGateway(gw model.Gateway, version model.Version) error
+	SelectGatewayByName(name string) (model.Gateway, bool, error)
+	DeleteGateway(name string) (bool, error)
+	SelectAllGatewayNames() ([]string, error)
 }
 
 type DiscoveryInfo struct {
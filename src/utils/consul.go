@@ -169,6 +169,25 @@ func (c *Consul) DeleteTreeIfExists(key string) (bool, error) {
 	return true, nil
 }
 
+// DeleteIfExists deletes only the key, unlike DeleteTreeIfExists the keys which have it as a prefix are kept.
+// returns whether or not to delete
+func (c *Consul) DeleteIfExists(key string) (bool, error) {
+	_, ok, err := c.GetKV(key)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, nil
+	}
+
+	_, err = c.Client.KV().Delete(key, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
 // only for test
 func NewConsulMock() *Consul {
 	// It must be set the same as start-mock-consul.sh